@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
+)
+
+const keysUsage = `Usage: app keys <command> [flags]
+
+Commands:
+  create  -name <name> -role <shopper|agent|admin> [-tenant <tenant>]
+  list
+  revoke  -id <key id>
+  token   -sub <subject> -role <shopper|agent|admin> [-tenant <tenant>] [-ttl 24h]
+`
+
+// runKeysCommand manages the API keys in store and access tokens signed
+// with the secret of auth from the command line
+func runKeysCommand(auth config.AuthConfig, store *models.Store, args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ExitOnError)
+		name := fs.String("name", "", "human readable key name")
+		role := fs.String("role", models.RoleShopper, "key role")
+		tenant := fs.String("tenant", "", "tenant the key belongs to")
+		fs.Parse(args[1:])
+
+		if *name == "" {
+			log.Fatal("-name is required")
+		}
+
+		secret, key, err := store.CreateAPIKey(ctx, *name, *role, *tenant)
+		if err != nil {
+			log.Fatalf("Error creating API key: %v", err)
+		}
+		fmt.Printf("Created key %s (%s, role %s)\n", key.ID, key.Name, key.Role)
+		fmt.Printf("Secret: %s\n", secret)
+		fmt.Println("Store the secret now, it cannot be shown again.")
+
+	case "list":
+		keys, err := store.ListAPIKeys(ctx, "")
+		if err != nil {
+			log.Fatalf("Error listing API keys: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tROLE\tTENANT\tCREATED")
+		for _, key := range keys {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Role, key.Tenant, key.CreatedAt.Format(time.RFC3339))
+		}
+		tw.Flush()
+
+	case "revoke":
+		fs := flag.NewFlagSet("keys revoke", flag.ExitOnError)
+		id := fs.String("id", "", "ID of the key to revoke")
+		fs.Parse(args[1:])
+
+		revoked, err := store.RevokeAPIKey(ctx, "", *id)
+		if err != nil {
+			log.Fatalf("Error revoking API key: %v", err)
+		}
+		if !revoked {
+			log.Fatalf("API key %s not found", *id)
+		}
+		fmt.Printf("Revoked key %s\n", *id)
+
+	case "token":
+		fs := flag.NewFlagSet("keys token", flag.ExitOnError)
+		subject := fs.String("sub", "", "token subject")
+		role := fs.String("role", models.RoleShopper, "token role")
+		tenant := fs.String("tenant", "", "tenant the token belongs to")
+		ttl := fs.Duration("ttl", 24*time.Hour, "token lifetime")
+		fs.Parse(args[1:])
+
+		if *subject == "" {
+			log.Fatal("-sub is required")
+		}
+
+		token, err := middleware.SignAccessToken(auth, *subject, *role, *tenant, *ttl)
+		if err != nil {
+			log.Fatalf("Error signing token: %v", err)
+		}
+		fmt.Println(token)
+
+	default:
+		fmt.Fprint(os.Stderr, keysUsage)
+		os.Exit(2)
+	}
+}
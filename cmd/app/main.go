@@ -1,63 +1,94 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"path/filepath"
-	"strings"
 
-	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
-	"github.com/rs/cors"
 	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/controllers"
+	"strategy-fox-go-bd/pkg/lifecycle"
+	"strategy-fox-go-bd/pkg/logging"
+	"strategy-fox-go-bd/pkg/metrics"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/notify"
 	"strategy-fox-go-bd/pkg/routes"
+	"strategy-fox-go-bd/pkg/tracing"
 )
 
 func main() {
-	envPath, err := filepath.Abs("../../.env")
-	if err != nil {
-		log.Fatalf("Error determining .env path: %v", err)
-	}
+	envFile := flag.String("env-file", "", "optional .env file (default $ENV_FILE, else ./.env or ../../.env if present)")
+	configFile := flag.String("config", "", "optional YAML config file (default $CONFIG_FILE)")
+	flag.Parse()
 
-	// Load the .env file
-	err = godotenv.Load(envPath)
+	cfg, err := config.Load(config.LoadOptions{EnvFile: *envFile, YAMLFile: *configFile})
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		log.Fatalf("Error loading configuration: %v", err)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "4000"
-	}
+	args := flag.Args()
 
-	allowedOrigins := os.Getenv("ALLOWED_ORIGINS")
-	if allowedOrigins == "" {
-		log.Fatal("ALLOWED_ORIGINS is not defined in the .env file")
+	// Print the effective configuration with secrets masked: app config
+	if len(args) > 0 && args[0] == "config" {
+		dump, err := cfg.Dump()
+		if err != nil {
+			log.Fatalf("Error rendering configuration: %v", err)
+		}
+		fmt.Print(string(dump))
+		return
 	}
 
-	origins := strings.Split(allowedOrigins, ",")
+	logging.Setup(cfg.Observability.LogLevel)
+	metrics.SetAllowedTenants(cfg.Observability.MetricsTenants)
 
-	corsMiddleware := cors.New(cors.Options{
-		AllowedOrigins: origins,
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Content-Type", "Authorization"},
-	})
-
-	router := mux.NewRouter()
+	// Admin CLI: app keys <command>
+	if len(args) > 0 && args[0] == "keys" {
+		redisClient, err := config.InitRedis(context.Background(), cfg.Redis)
+		if err != nil {
+			log.Fatalf("Error connecting to Redis: %v", err)
+		}
+		runKeysCommand(cfg.Auth, models.NewStore(redisClient), args[1:])
+		return
+	}
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		os.Exit(2)
+	}
 
-	shopifyRouter := router.PathPrefix("/api/shopify").Subrouter()
-	chatbotRouter := router.PathPrefix("/api/chatbot").Subrouter()
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Observability.TracesExporter)
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
-	routes.ShopifyRoutes(shopifyRouter)
-	routes.ChatBotRoutes(chatbotRouter)
+	redisClient, err := config.InitRedis(context.Background(), cfg.Redis)
+	if err != nil {
+		log.Fatalf("Error connecting to Redis: %v", err)
+	}
+	store := models.NewStore(redisClient)
+	metrics.RegisterActiveSessions(store.CountActiveSessions)
 
-	handler := corsMiddleware.Handler(router)
+	deps := routes.Dependencies{
+		Config:    cfg,
+		Shopify:   controllers.NewShopifyClient(cfg.Shopify, nil),
+		Cache:     controllers.NewRedisCache(redisClient),
+		LLM:       controllers.NewGeminiProvider(cfg.LLM),
+		Redis:     redisClient,
+		Store:     store,
+		Analytics: models.NewAnalyticsStore(cfg.Analytics, redisClient),
+		Notifier:  notify.New(cfg.BackInStock),
+	}
+	handler := routes.NewRouter(deps)
 
-	config.InitRedis()
+	// Shops without inventory webhooks find restocks by polling
+	if cfg.BackInStock.PollInterval > 0 {
+		backInStock := controllers.NewBackInStockController(cfg, deps.Shopify, deps.Store, deps.Notifier)
+		go backInStock.Poll(lifecycle.ShutdownContext(), cfg.BackInStock.PollInterval)
+	}
 
-	log.Printf("Server is running on port %s", port)
-	if err := http.ListenAndServe(":"+port, handler); err != nil {
+	if err := runServer(":"+cfg.Server.Port, handler, cfg.Server); err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/lifecycle"
+)
+
+// runServer serves handler until SIGINT or SIGTERM, then drains: readiness
+// fails, streaming handlers are signalled through lifecycle.ShutdownContext
+// and in-flight requests get up to the shutdown timeout to finish.
+func runServer(addr string, handler http.Handler, timeouts config.ServerConfig) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.ReadHeaderTimeout,
+		ReadTimeout:       timeouts.ReadTimeout,
+		WriteTimeout:      timeouts.WriteTimeout,
+		IdleTimeout:       timeouts.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server is running", slog.String("addr", addr))
+		serveErr <- server.ListenAndServe()
+	}()
+
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-signals.Done():
+	}
+
+	slog.Info("shutdown signal received, draining", slog.String("drain_delay", timeouts.DrainDelay.String()))
+	lifecycle.StartDraining()
+	time.Sleep(timeouts.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeouts.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	slog.Info("server stopped")
+	return nil
+}
@@ -0,0 +1,594 @@
+// Package config loads the server configuration into one typed Config.
+//
+// Values are resolved in increasing precedence: built-in defaults, an
+// optional YAML file, then environment variables. An optional .env file is
+// loaded into the environment first and never overrides variables that are
+// already set. The YAML keys mirror the struct layout, and every setting can
+// also be set through the environment variable named in its env tag.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete server configuration
+type Config struct {
+	Server        ServerConfig        `yaml:"server"`
+	Shopify       ShopifyConfig       `yaml:"shopify"`
+	LLM           LLMConfig           `yaml:"llm"`
+	Redis         RedisConfig         `yaml:"redis"`
+	Auth          AuthConfig          `yaml:"auth"`
+	RateLimit     RateLimitConfig     `yaml:"rate_limit"`
+	Guardrails    GuardrailsConfig    `yaml:"guardrails"`
+	Observability ObservabilityConfig `yaml:"observability"`
+	Analytics     AnalyticsConfig     `yaml:"analytics"`
+	Returns       ReturnsConfig       `yaml:"returns"`
+	Promotions    PromotionsConfig    `yaml:"promotions"`
+	Inventory     InventoryConfig     `yaml:"inventory"`
+	BackInStock   BackInStockConfig   `yaml:"back_in_stock"`
+}
+
+// ServerConfig controls the HTTP listener and shutdown
+type ServerConfig struct {
+	Port           string   `yaml:"port" env:"PORT"`
+	AllowedOrigins []string `yaml:"allowed_origins" env:"ALLOWED_ORIGINS"`
+	// TenantOrigins maps storefront origins to tenants as origin=tenant
+	// pairs, for anonymous requests that carry no credential
+	TenantOrigins     []string      `yaml:"tenant_origins" env:"TENANT_ORIGINS"`
+	TrustProxyHeaders bool          `yaml:"trust_proxy_headers" env:"TRUST_PROXY_HEADERS"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+	// WriteTimeout must cover the slowest chat reply, including tool rounds
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT"`
+	// DrainDelay keeps serving after readiness fails so load balancers can
+	// notice before connections are closed
+	DrainDelay      time.Duration `yaml:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+// ShopifyConfig identifies the shop and the API credentials
+type ShopifyConfig struct {
+	StoreName             string `yaml:"store_name" env:"SHOPIFY_STORE_NAME"`
+	APIVersion            string `yaml:"api_version" env:"SHOPIFY_API_VERSION"`
+	StorefrontAccessToken Secret `yaml:"storefront_access_token" env:"SHOPIFY_STOREFRONT_ACCESS_TOKEN"`
+	// AdminAccessToken is only used server-side, never for public reads
+	AdminAccessToken Secret `yaml:"admin_access_token" env:"SHOPIFY_ADMIN_API_PASS_TOKEN"`
+	// StorefrontSessionSecret verifies signed sessions from the theme
+	StorefrontSessionSecret Secret `yaml:"storefront_session_secret" env:"STOREFRONT_SESSION_SECRET"`
+	// WebhookSecret verifies webhook deliveries; webhooks are refused
+	// while it is unset
+	WebhookSecret Secret `yaml:"webhook_secret" env:"SHOPIFY_WEBHOOK_SECRET"`
+}
+
+// OriginTenants returns TenantOrigins as a map from origin to tenant
+func (s ServerConfig) OriginTenants() map[string]string {
+	tenants := map[string]string{}
+	for _, pair := range s.TenantOrigins {
+		origin, tenant, ok := strings.Cut(pair, "=")
+		if ok && strings.TrimSpace(origin) != "" && strings.TrimSpace(tenant) != "" {
+			tenants[strings.TrimRight(strings.TrimSpace(origin), "/")] = strings.TrimSpace(tenant)
+		}
+	}
+	return tenants
+}
+
+// StorefrontURL is the Storefront API GraphQL endpoint
+func (s ShopifyConfig) StorefrontURL() string {
+	return fmt.Sprintf("https://%s/api/%s/graphql.json", s.StoreName, s.APIVersion)
+}
+
+// AdminURL is the Admin API GraphQL endpoint
+func (s ShopifyConfig) AdminURL() string {
+	return fmt.Sprintf("https://%s/admin/api/%s/graphql.json", s.StoreName, s.APIVersion)
+}
+
+// LLMConfig configures the Gemini provider and spend limits
+type LLMConfig struct {
+	GeminiAPIKey Secret `yaml:"gemini_api_key" env:"GEMINI_API_KEY"`
+	Model        string `yaml:"model" env:"GEMINI_MODEL"`
+	// SafetyThreshold is low, medium, high or none
+	SafetyThreshold string `yaml:"safety_threshold" env:"GEMINI_SAFETY_THRESHOLD"`
+	// DailyTokenBudget is per tenant; zero means unlimited
+	DailyTokenBudget int64 `yaml:"daily_token_budget" env:"LLM_DAILY_TOKEN_BUDGET"`
+}
+
+// RedisConfig locates the Redis deployment and sizes the connection pool.
+// URL carries the credentials, database and TLS (rediss://); Addrs lists
+// the Sentinel or Cluster nodes and defaults to the URL's host.
+type RedisConfig struct {
+	URL SecretURL `yaml:"url" env:"REDIS_URL"`
+	// Mode is standalone, sentinel or cluster
+	Mode             string   `yaml:"mode" env:"REDIS_MODE"`
+	Addrs            []string `yaml:"addrs" env:"REDIS_ADDRS"`
+	MasterName       string   `yaml:"master_name" env:"REDIS_SENTINEL_MASTER"`
+	SentinelPassword Secret   `yaml:"sentinel_password" env:"REDIS_SENTINEL_PASSWORD"`
+
+	TLS                   bool   `yaml:"tls" env:"REDIS_TLS"`
+	TLSServerName         string `yaml:"tls_server_name" env:"REDIS_TLS_SERVER_NAME"`
+	TLSCAFile             string `yaml:"tls_ca_file" env:"REDIS_TLS_CA_FILE"`
+	TLSInsecureSkipVerify bool   `yaml:"tls_insecure_skip_verify" env:"REDIS_TLS_INSECURE_SKIP_VERIFY"`
+
+	// PoolSize of zero keeps the go-redis default of 10 per CPU
+	PoolSize     int           `yaml:"pool_size" env:"REDIS_POOL_SIZE"`
+	MinIdleConns int           `yaml:"min_idle_conns" env:"REDIS_MIN_IDLE_CONNS"`
+	DialTimeout  time.Duration `yaml:"dial_timeout" env:"REDIS_DIAL_TIMEOUT"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" env:"REDIS_READ_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"REDIS_WRITE_TIMEOUT"`
+	PoolTimeout  time.Duration `yaml:"pool_timeout" env:"REDIS_POOL_TIMEOUT"`
+
+	// StartupRetries is how many times the startup ping is retried, with
+	// exponential backoff from StartupRetryDelay
+	StartupRetries    int           `yaml:"startup_retries" env:"REDIS_STARTUP_RETRIES"`
+	StartupRetryDelay time.Duration `yaml:"startup_retry_delay" env:"REDIS_STARTUP_RETRY_DELAY"`
+}
+
+// AuthConfig holds API authentication settings
+type AuthConfig struct {
+	JWTSecret Secret `yaml:"jwt_secret" env:"AUTH_JWT_SECRET"`
+}
+
+// RateLimitConfig holds the per-minute quotas of each route group
+type RateLimitConfig struct {
+	ChatPerMinute    int `yaml:"chat_per_minute" env:"RATE_LIMIT_CHAT_PER_MINUTE"`
+	CatalogPerMinute int `yaml:"catalog_per_minute" env:"RATE_LIMIT_CATALOG_PER_MINUTE"`
+}
+
+// GuardrailsConfig lists what the chatbot must refuse or never say
+type GuardrailsConfig struct {
+	BlockedTopics        []string `yaml:"blocked_topics" env:"GUARDRAIL_BLOCKED_TOPICS"`
+	Competitors          []string `yaml:"competitors" env:"GUARDRAIL_COMPETITORS"`
+	AllowedDiscountCodes []string `yaml:"allowed_discount_codes" env:"GUARDRAIL_ALLOWED_DISCOUNT_CODES"`
+}
+
+// ObservabilityConfig controls logs, traces and metrics
+type ObservabilityConfig struct {
+	// LogLevel is debug, info, warn or error
+	LogLevel string `yaml:"log_level" env:"LOG_LEVEL"`
+	// TracesExporter is none, stdout or otlp
+	TracesExporter string `yaml:"traces_exporter" env:"OTEL_TRACES_EXPORTER"`
+	// MetricsTenants bounds the tenant label; empty keeps the first 100 seen
+	MetricsTenants []string `yaml:"metrics_tenants" env:"METRICS_TENANTS"`
+}
+
+// AnalyticsConfig controls where chat events are stored and how their cost
+// is reported
+type AnalyticsConfig struct {
+	// Sink is redis (one stream per tenant), file (daily JSON Lines files
+	// under Dir) or none
+	Sink      string        `yaml:"sink" env:"ANALYTICS_SINK"`
+	Dir       string        `yaml:"dir" env:"ANALYTICS_DIR"`
+	Retention time.Duration `yaml:"retention" env:"ANALYTICS_RETENTION"`
+	// Token prices in USD per million tokens, for cost per conversation
+	PromptTokenPrice     float64 `yaml:"prompt_token_price" env:"ANALYTICS_PROMPT_TOKEN_PRICE"`
+	CompletionTokenPrice float64 `yaml:"completion_token_price" env:"ANALYTICS_COMPLETION_TOKEN_PRICE"`
+}
+
+// ReturnsConfig is the return policy of tenants that have not set their own
+type ReturnsConfig struct {
+	// WindowDays counts from delivery, or fulfillment when the delivery date
+	// is unknown; zero accepts no returns
+	WindowDays int `yaml:"window_days" env:"RETURNS_WINDOW_DAYS"`
+	// FinalSaleTags mark products that cannot be returned
+	FinalSaleTags    []string `yaml:"final_sale_tags" env:"RETURNS_FINAL_SALE_TAGS"`
+	ExchangesEnabled bool     `yaml:"exchanges_enabled" env:"RETURNS_EXCHANGES_ENABLED"`
+}
+
+// PromotionsConfig controls how long active discounts read from the Admin
+// API are cached
+type PromotionsConfig struct {
+	CacheTTL time.Duration `yaml:"cache_ttl" env:"PROMOTIONS_CACHE_TTL"`
+}
+
+// InventoryConfig controls stock lookups and low-stock signals
+type InventoryConfig struct {
+	// LowStockThreshold is the quantity at or below which a variant or
+	// location reports low stock, e.g. "Only 2 left"
+	LowStockThreshold int `yaml:"low_stock_threshold" env:"INVENTORY_LOW_STOCK_THRESHOLD"`
+	// CacheTTL bounds staleness for shops without inventory_levels/update
+	// webhooks, which invalidate cached entries
+	CacheTTL time.Duration `yaml:"cache_ttl" env:"INVENTORY_CACHE_TTL"`
+}
+
+// BackInStockConfig controls restock subscriptions and how their
+// notifications are delivered
+type BackInStockConfig struct {
+	// EmailNotifier is smtp or log; WebhookNotifier is http or log. The log
+	// notifiers only record what would have been sent.
+	EmailNotifier   string `yaml:"email_notifier" env:"BACK_IN_STOCK_EMAIL_NOTIFIER"`
+	WebhookNotifier string `yaml:"webhook_notifier" env:"BACK_IN_STOCK_WEBHOOK_NOTIFIER"`
+	SMTPHost        string `yaml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort        int    `yaml:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername    string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword    Secret `yaml:"smtp_password" env:"SMTP_PASSWORD"`
+	SMTPFrom        string `yaml:"smtp_from" env:"SMTP_FROM"`
+	// PublicURL is the externally reachable base URL unsubscribe links
+	// point at; links are relative while it is empty
+	PublicURL string `yaml:"public_url" env:"BACK_IN_STOCK_PUBLIC_URL"`
+	// PollInterval re-checks subscribed variants for shops without
+	// inventory webhooks; zero disables polling
+	PollInterval    time.Duration `yaml:"poll_interval" env:"BACK_IN_STOCK_POLL_INTERVAL"`
+	SubscriptionTTL time.Duration `yaml:"subscription_ttl" env:"BACK_IN_STOCK_SUBSCRIPTION_TTL"`
+	// TargetDailyLimit caps registrations per email address or webhook a
+	// day, so the endpoint cannot be used to flood someone's inbox
+	TargetDailyLimit int `yaml:"target_daily_limit" env:"BACK_IN_STOCK_TARGET_DAILY_LIMIT"`
+}
+
+// DefaultBlockedTopics are subjects the fashion assistant refuses to discuss
+var DefaultBlockedTopics = []string{
+	"make a bomb", "build a bomb", "firearm", "self-harm", "suicide",
+	"narcotics", "hack into", "malware", "credit card dump",
+}
+
+// Defaults returns the configuration used when nothing overrides it
+func Defaults() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:              "4000",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      90 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			DrainDelay:        5 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		Shopify: ShopifyConfig{
+			APIVersion: "2024-10",
+		},
+		LLM: LLMConfig{
+			Model:           "gemini-1.5-flash",
+			SafetyThreshold: "medium",
+		},
+		Redis: RedisConfig{
+			URL:               "redis://localhost:6379/0",
+			Mode:              "standalone",
+			DialTimeout:       5 * time.Second,
+			ReadTimeout:       3 * time.Second,
+			WriteTimeout:      3 * time.Second,
+			PoolTimeout:       4 * time.Second,
+			StartupRetries:    5,
+			StartupRetryDelay: time.Second,
+		},
+		RateLimit: RateLimitConfig{
+			ChatPerMinute:    20,
+			CatalogPerMinute: 120,
+		},
+		Guardrails: GuardrailsConfig{
+			BlockedTopics: append([]string(nil), DefaultBlockedTopics...),
+		},
+		Observability: ObservabilityConfig{
+			LogLevel:       "info",
+			TracesExporter: "none",
+		},
+		Returns: ReturnsConfig{
+			WindowDays:       30,
+			FinalSaleTags:    []string{"final-sale"},
+			ExchangesEnabled: true,
+		},
+		Promotions: PromotionsConfig{
+			CacheTTL: 5 * time.Minute,
+		},
+		Inventory: InventoryConfig{
+			LowStockThreshold: 3,
+			CacheTTL:          30 * time.Second,
+		},
+		BackInStock: BackInStockConfig{
+			EmailNotifier:    "log",
+			WebhookNotifier:  "http",
+			SMTPPort:         587,
+			SubscriptionTTL:  90 * 24 * time.Hour,
+			TargetDailyLimit: 5,
+		},
+		Analytics: AnalyticsConfig{
+			Sink:                 "redis",
+			Dir:                  "analytics",
+			Retention:            90 * 24 * time.Hour,
+			PromptTokenPrice:     0.075,
+			CompletionTokenPrice: 0.30,
+		},
+	}
+}
+
+// LoadOptions names the optional files to read. Empty paths fall back to
+// the ENV_FILE and CONFIG_FILE environment variables.
+type LoadOptions struct {
+	EnvFile  string
+	YAMLFile string
+}
+
+// defaultEnvFiles are tried in order when no .env path is given; the second
+// keeps `go run .` from cmd/app working
+var defaultEnvFiles = []string{".env", "../../.env"}
+
+// Load resolves the configuration from every source and validates it
+func Load(opts LoadOptions) (*Config, error) {
+	if err := loadEnvFile(opts.EnvFile); err != nil {
+		return nil, err
+	}
+
+	cfg := Defaults()
+
+	yamlFile := opts.YAMLFile
+	if yamlFile == "" {
+		yamlFile = os.Getenv("CONFIG_FILE")
+	}
+	if yamlFile != "" {
+		data, err := os.ReadFile(yamlFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %v", yamlFile, err)
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem()); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// loadEnvFile loads path, or the first default .env file that exists.
+// An explicitly named file must exist.
+func loadEnvFile(path string) error {
+	if path == "" {
+		path = os.Getenv("ENV_FILE")
+	}
+	if path != "" {
+		if err := godotenv.Load(path); err != nil {
+			return fmt.Errorf("failed to load env file %s: %v", path, err)
+		}
+		return nil
+	}
+
+	for _, candidate := range defaultEnvFiles {
+		if _, err := os.Stat(candidate); err == nil {
+			if err := godotenv.Load(candidate); err != nil {
+				return fmt.Errorf("failed to load env file %s: %v", candidate, err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// applyEnv overrides fields with the environment variables named in their
+// env tags, recursing into nested structs
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct && field.Type() != reflect.TypeOf(time.Duration(0)) {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		key := t.Field(i).Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		raw, ok := os.LookupEnv(key)
+		if !ok || strings.TrimSpace(raw) == "" {
+			continue
+		}
+		if err := setField(field, raw); err != nil {
+			return fmt.Errorf("invalid %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		field.Set(reflect.ValueOf(splitList(raw)))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// splitList parses a comma-separated list, dropping empty entries
+func splitList(raw string) []string {
+	var values []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Validate reports every invalid or missing setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "server.port (PORT) must be a TCP port, got %q", c.Server.Port)
+	check(len(c.Server.AllowedOrigins) > 0, "server.allowed_origins (ALLOWED_ORIGINS) is required")
+	check(len(c.Server.OriginTenants()) == len(c.Server.TenantOrigins), "server.tenant_origins (TENANT_ORIGINS) entries must be origin=tenant")
+	check(c.Server.ReadHeaderTimeout > 0 && c.Server.ReadTimeout > 0 && c.Server.WriteTimeout > 0 && c.Server.IdleTimeout > 0,
+		"server timeouts must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay (SHUTDOWN_DRAIN_DELAY) must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout (SHUTDOWN_TIMEOUT) must be positive")
+
+	check(c.Shopify.StoreName != "", "shopify.store_name (SHOPIFY_STORE_NAME) is required")
+	check(c.Shopify.APIVersion != "", "shopify.api_version (SHOPIFY_API_VERSION) is required")
+	check(c.Shopify.StorefrontAccessToken != "", "shopify.storefront_access_token (SHOPIFY_STOREFRONT_ACCESS_TOKEN) is required")
+
+	check(c.LLM.Model != "", "llm.model (GEMINI_MODEL) is required")
+	check(oneOf(c.LLM.SafetyThreshold, "low", "medium", "high", "none"),
+		"llm.safety_threshold (GEMINI_SAFETY_THRESHOLD) must be low, medium, high or none, got %q", c.LLM.SafetyThreshold)
+	check(c.LLM.DailyTokenBudget >= 0, "llm.daily_token_budget (LLM_DAILY_TOKEN_BUDGET) must not be negative")
+
+	redisOpts, err := redis.ParseURL(c.Redis.URL.Value())
+	check(err == nil, "redis.url (REDIS_URL) is invalid: %v", err)
+	check(oneOf(c.Redis.Mode, RedisStandalone, RedisSentinel, RedisCluster),
+		"redis.mode (REDIS_MODE) must be standalone, sentinel or cluster, got %q", c.Redis.Mode)
+	if strings.EqualFold(c.Redis.Mode, RedisSentinel) {
+		check(c.Redis.MasterName != "", "redis.master_name (REDIS_SENTINEL_MASTER) is required in sentinel mode")
+		check(len(c.Redis.Addrs) > 0, "redis.addrs (REDIS_ADDRS) must list the sentinels in sentinel mode")
+	}
+	if strings.EqualFold(c.Redis.Mode, RedisCluster) && redisOpts != nil {
+		check(redisOpts.DB == 0, "redis.url (REDIS_URL) must use database 0 in cluster mode")
+	}
+	check(c.Redis.PoolSize >= 0 && c.Redis.MinIdleConns >= 0, "redis pool sizes must not be negative")
+	check(c.Redis.DialTimeout > 0 && c.Redis.ReadTimeout > 0 && c.Redis.WriteTimeout > 0 && c.Redis.PoolTimeout > 0,
+		"redis timeouts must be positive")
+	check(c.Redis.StartupRetries >= 0 && c.Redis.StartupRetryDelay >= 0, "redis startup retries must not be negative")
+
+	check(c.RateLimit.ChatPerMinute > 0, "rate_limit.chat_per_minute (RATE_LIMIT_CHAT_PER_MINUTE) must be positive")
+	check(c.RateLimit.CatalogPerMinute > 0, "rate_limit.catalog_per_minute (RATE_LIMIT_CATALOG_PER_MINUTE) must be positive")
+
+	check(oneOf(c.Observability.LogLevel, "debug", "info", "warn", "error"),
+		"observability.log_level (LOG_LEVEL) must be debug, info, warn or error, got %q", c.Observability.LogLevel)
+	check(oneOf(c.Observability.TracesExporter, "none", "stdout", "otlp"),
+		"observability.traces_exporter (OTEL_TRACES_EXPORTER) must be none, stdout or otlp, got %q", c.Observability.TracesExporter)
+
+	check(oneOf(c.Analytics.Sink, "redis", "file", "none"),
+		"analytics.sink (ANALYTICS_SINK) must be redis, file or none, got %q", c.Analytics.Sink)
+	if strings.EqualFold(c.Analytics.Sink, "file") {
+		check(c.Analytics.Dir != "", "analytics.dir (ANALYTICS_DIR) is required for the file sink")
+	}
+	check(c.Analytics.Retention >= 0, "analytics.retention (ANALYTICS_RETENTION) must not be negative")
+	check(c.Analytics.PromptTokenPrice >= 0 && c.Analytics.CompletionTokenPrice >= 0, "analytics token prices must not be negative")
+
+	check(c.Returns.WindowDays >= 0, "returns.window_days (RETURNS_WINDOW_DAYS) must not be negative")
+	check(c.Promotions.CacheTTL > 0, "promotions.cache_ttl (PROMOTIONS_CACHE_TTL) must be positive")
+	check(c.Inventory.LowStockThreshold >= 0, "inventory.low_stock_threshold (INVENTORY_LOW_STOCK_THRESHOLD) must not be negative")
+	check(c.Inventory.CacheTTL > 0, "inventory.cache_ttl (INVENTORY_CACHE_TTL) must be positive")
+
+	check(oneOf(c.BackInStock.EmailNotifier, "smtp", "log"),
+		"back_in_stock.email_notifier (BACK_IN_STOCK_EMAIL_NOTIFIER) must be smtp or log, got %q", c.BackInStock.EmailNotifier)
+	if strings.EqualFold(c.BackInStock.EmailNotifier, "smtp") {
+		check(c.BackInStock.SMTPHost != "" && c.BackInStock.SMTPFrom != "", "back_in_stock.smtp_host (SMTP_HOST) and smtp_from (SMTP_FROM) are required for the smtp notifier")
+		check(c.BackInStock.SMTPPort > 0 && c.BackInStock.SMTPPort < 65536, "back_in_stock.smtp_port (SMTP_PORT) must be a TCP port, got %d", c.BackInStock.SMTPPort)
+	}
+	check(oneOf(c.BackInStock.WebhookNotifier, "http", "log"),
+		"back_in_stock.webhook_notifier (BACK_IN_STOCK_WEBHOOK_NOTIFIER) must be http or log, got %q", c.BackInStock.WebhookNotifier)
+	check(c.BackInStock.PollInterval >= 0, "back_in_stock.poll_interval (BACK_IN_STOCK_POLL_INTERVAL) must not be negative")
+	check(c.BackInStock.SubscriptionTTL > 0, "back_in_stock.subscription_ttl (BACK_IN_STOCK_SUBSCRIPTION_TTL) must be positive")
+	check(c.BackInStock.TargetDailyLimit > 0, "back_in_stock.target_daily_limit (BACK_IN_STOCK_TARGET_DAILY_LIMIT) must be positive")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(value, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dump renders the configuration as YAML with every secret masked
+func (c *Config) Dump() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+const mask = "********"
+
+// Secret is a credential that is masked whenever it is printed, logged or
+// dumped. Use Value for the real string.
+type Secret string
+
+// Value returns the unmasked secret
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return mask
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(s.String())), nil
+}
+
+// SecretURL is a URL whose password is masked when printed
+type SecretURL string
+
+// Value returns the unmasked URL
+func (s SecretURL) Value() string {
+	return string(s)
+}
+
+func (s SecretURL) String() string {
+	u, err := url.Parse(string(s))
+	if err != nil {
+		return mask
+	}
+	return u.Redacted()
+}
+
+func (s SecretURL) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+func (s SecretURL) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(s.String())), nil
+}
@@ -0,0 +1,267 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv blanks every variable Load reads, so the host environment cannot
+// leak into a test. applyEnv skips empty values.
+func clearEnv(t *testing.T) {
+	t.Helper()
+
+	var walk func(reflect.Type)
+	walk = func(typ reflect.Type) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.Type.Kind() == reflect.Struct {
+				walk(field.Type)
+				continue
+			}
+			if key := field.Tag.Get("env"); key != "" {
+				t.Setenv(key, "")
+			}
+		}
+	}
+	walk(reflect.TypeOf(Config{}))
+	t.Setenv("ENV_FILE", "")
+	t.Setenv("CONFIG_FILE", "")
+}
+
+// unsetEnv removes keys for the test, restoring them afterwards, so an env
+// file can set them
+func unsetEnv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+// requiredEnv are the settings without a usable default
+var requiredEnv = map[string]string{
+	"SHOPIFY_STORE_NAME":              "doodad.myshopify.com",
+	"SHOPIFY_STOREFRONT_ACCESS_TOKEN": "storefront-token",
+	"ALLOWED_ORIGINS":                 "https://doodad.example.com",
+}
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     map[string]string
+		envFile string
+		yaml    string
+		check   func(t *testing.T, cfg *Config)
+		wantErr string
+	}{
+		{
+			name: "defaults with the required settings",
+			env:  requiredEnv,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Server.Port != "4000" || cfg.Redis.Mode != RedisStandalone || cfg.RateLimit.ChatPerMinute != 20 {
+					t.Errorf("cfg = %+v, want the defaults", cfg)
+				}
+			},
+		},
+		{
+			name: "environment types",
+			env: map[string]string{
+				"SHOPIFY_STORE_NAME":              "doodad.myshopify.com",
+				"SHOPIFY_STOREFRONT_ACCESS_TOKEN": "storefront-token",
+				"ALLOWED_ORIGINS":                 " https://a.example.com, ,https://b.example.com ",
+				"HTTP_READ_TIMEOUT":               "20s",
+				"TRUST_PROXY_HEADERS":             "true",
+				"LLM_DAILY_TOKEN_BUDGET":          "50000",
+				"ANALYTICS_PROMPT_TOKEN_PRICE":    "0.5",
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if got := cfg.Server.AllowedOrigins; !reflect.DeepEqual(got, []string{"https://a.example.com", "https://b.example.com"}) {
+					t.Errorf("allowed origins = %q", got)
+				}
+				if cfg.Server.ReadTimeout != 20*time.Second || !cfg.Server.TrustProxyHeaders || cfg.LLM.DailyTokenBudget != 50000 || cfg.Analytics.PromptTokenPrice != 0.5 {
+					t.Errorf("cfg = %+v, want the environment values", cfg)
+				}
+			},
+		},
+		{
+			name: "environment overrides the YAML file",
+			env:  map[string]string{"SHOPIFY_STOREFRONT_ACCESS_TOKEN": "storefront-token", "PORT": "8080"},
+			yaml: "server:\n  port: \"9090\"\n  allowed_origins: [\"https://yaml.example.com\"]\nshopify:\n  store_name: yaml.myshopify.com\n",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Server.Port != "8080" || cfg.Shopify.StoreName != "yaml.myshopify.com" || len(cfg.Server.AllowedOrigins) != 1 {
+					t.Errorf("cfg = %+v, want the YAML values with PORT from the environment", cfg)
+				}
+			},
+		},
+		{
+			name:    "env file",
+			env:     map[string]string{"SHOPIFY_STOREFRONT_ACCESS_TOKEN": "storefront-token", "ALLOWED_ORIGINS": "https://doodad.example.com"},
+			envFile: "SHOPIFY_STORE_NAME=file.myshopify.com\n",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Shopify.StoreName != "file.myshopify.com" {
+					t.Errorf("store name = %q, want the env file's", cfg.Shopify.StoreName)
+				}
+			},
+		},
+		{
+			name:    "unparsable environment value",
+			env:     map[string]string{"HTTP_READ_TIMEOUT": "soon"},
+			wantErr: "invalid HTTP_READ_TIMEOUT",
+		},
+		{
+			name:    "malformed YAML",
+			yaml:    "server: [",
+			wantErr: "failed to parse config file",
+		},
+		{
+			name:    "missing required settings",
+			wantErr: "shopify.store_name (SHOPIFY_STORE_NAME) is required",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clearEnv(t)
+			unsetEnv(t, "SHOPIFY_STORE_NAME")
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			opts := LoadOptions{EnvFile: writeFile(t, ".env", tc.envFile)}
+			if tc.yaml != "" {
+				opts.YAMLFile = writeFile(t, "config.yaml", tc.yaml)
+			}
+
+			cfg, err := Load(opts)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			tc.check(t, cfg)
+		})
+	}
+}
+
+// validConfig is the defaults with the required settings filled in
+func validConfig() Config {
+	cfg := Defaults()
+	cfg.Server.AllowedOrigins = []string{"https://doodad.example.com"}
+	cfg.Shopify.StoreName = "doodad.myshopify.com"
+	cfg.Shopify.StorefrontAccessToken = "storefront-token"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		mutate   func(cfg *Config)
+		wantErrs []string
+	}{
+		{name: "valid", mutate: func(cfg *Config) {}},
+		{
+			name:     "server port",
+			mutate:   func(cfg *Config) { cfg.Server.Port = "70000" },
+			wantErrs: []string{"server.port (PORT) must be a TCP port"},
+		},
+		{
+			name:     "tenant origin without tenant",
+			mutate:   func(cfg *Config) { cfg.Server.TenantOrigins = []string{"https://doodad.example.com"} },
+			wantErrs: []string{"server.tenant_origins"},
+		},
+		{
+			name:     "unknown redis mode",
+			mutate:   func(cfg *Config) { cfg.Redis.Mode = "replica" },
+			wantErrs: []string{"redis.mode (REDIS_MODE) must be standalone, sentinel or cluster"},
+		},
+		{
+			name:     "sentinel without master or sentinels",
+			mutate:   func(cfg *Config) { cfg.Redis.Mode = RedisSentinel },
+			wantErrs: []string{"redis.master_name (REDIS_SENTINEL_MASTER) is required", "redis.addrs (REDIS_ADDRS) must list the sentinels"},
+		},
+		{
+			name: "sentinel",
+			mutate: func(cfg *Config) {
+				cfg.Redis.Mode = RedisSentinel
+				cfg.Redis.MasterName = "primary"
+				cfg.Redis.Addrs = []string{"sentinel-1:26379"}
+			},
+		},
+		{
+			name: "cluster on a non-zero database",
+			mutate: func(cfg *Config) {
+				cfg.Redis.Mode = RedisCluster
+				cfg.Redis.URL = "redis://localhost:6379/2"
+			},
+			wantErrs: []string{"must use database 0 in cluster mode"},
+		},
+		{
+			name:     "invalid redis URL",
+			mutate:   func(cfg *Config) { cfg.Redis.URL = "http://localhost" },
+			wantErrs: []string{"redis.url (REDIS_URL) is invalid"},
+		},
+		{
+			name:     "file analytics without a directory",
+			mutate:   func(cfg *Config) { cfg.Analytics.Sink = "file"; cfg.Analytics.Dir = "" },
+			wantErrs: []string{"analytics.dir (ANALYTICS_DIR) is required"},
+		},
+		{
+			name:     "smtp without a host",
+			mutate:   func(cfg *Config) { cfg.BackInStock.EmailNotifier = "smtp" },
+			wantErrs: []string{"smtp_host (SMTP_HOST) and smtp_from (SMTP_FROM) are required"},
+		},
+		{
+			name: "every error at once",
+			mutate: func(cfg *Config) {
+				cfg.Shopify.StoreName = ""
+				cfg.RateLimit.ChatPerMinute = 0
+				cfg.Observability.LogLevel = "verbose"
+			},
+			wantErrs: []string{
+				"shopify.store_name (SHOPIFY_STORE_NAME) is required",
+				"rate_limit.chat_per_minute (RATE_LIMIT_CHAT_PER_MINUTE) must be positive",
+				"observability.log_level (LOG_LEVEL) must be debug, info, warn or error",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := validConfig()
+			tc.mutate(&cfg)
+
+			err := cfg.Validate()
+			if len(tc.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate passed, want %q", tc.wantErrs)
+			}
+			for _, want := range tc.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("err = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
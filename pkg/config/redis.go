@@ -1,25 +1,195 @@
 package config
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-var RedisClient *redis.Client
+// Redis deployment modes
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
+
+// maxRetryDelay caps the startup backoff
+const maxRetryDelay = 10 * time.Second
+
+// InitRedis connects to the configured deployment and pings it, retrying
+// with backoff, so the server does not start against a Redis it cannot
+// reach. The client is an interface so the same code runs against a single
+// node, a Sentinel failover group or a Cluster, and so tests can pass a
+// client backed by an in-memory server.
+func InitRedis(ctx context.Context, cfg RedisConfig) (redis.UniversalClient, error) {
+	client, err := NewRedisClient(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-// InitRedis connects RedisClient using REDIS_URL, defaulting to a local instance
-func InitRedis() {
-	redisURL := os.Getenv("REDIS_URL")
-	if redisURL == "" {
-		redisURL = "redis://localhost:6379/0"
+	if err := pingWithRetry(ctx, client, cfg.StartupRetries, cfg.StartupRetryDelay); err != nil {
+		client.Close()
+		return nil, err
 	}
+	return client, nil
+}
 
-	opts, err := redis.ParseURL(redisURL)
+// NewRedisClient builds a client for the configured mode without connecting
+func NewRedisClient(cfg RedisConfig) (redis.UniversalClient, error) {
+	opts, err := redis.ParseURL(cfg.URL.Value())
 	if err != nil {
-		log.Fatalf("Error parsing REDIS_URL: %v", err)
+		return nil, fmt.Errorf("failed to parse REDIS_URL: %v", err)
+	}
+
+	tlsConfig, err := redisTLSConfig(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := cfg.Addrs
+	if len(addrs) == 0 {
+		addrs = []string{opts.Addr}
+	}
+
+	switch strings.ToLower(cfg.Mode) {
+	case "", RedisStandalone:
+		opts.TLSConfig = tlsConfig
+		opts.PoolSize = cfg.PoolSize
+		opts.MinIdleConns = cfg.MinIdleConns
+		opts.DialTimeout = cfg.DialTimeout
+		opts.ReadTimeout = cfg.ReadTimeout
+		opts.WriteTimeout = cfg.WriteTimeout
+		opts.PoolTimeout = cfg.PoolTimeout
+		return redis.NewClient(opts), nil
+
+	case RedisSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    addrs,
+			SentinelPassword: cfg.SentinelPassword.Value(),
+			Username:         opts.Username,
+			Password:         opts.Password,
+			DB:               opts.DB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.MinIdleConns,
+			DialTimeout:      cfg.DialTimeout,
+			ReadTimeout:      cfg.ReadTimeout,
+			WriteTimeout:     cfg.WriteTimeout,
+			PoolTimeout:      cfg.PoolTimeout,
+		}), nil
+
+	case RedisCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        addrs,
+			Username:     opts.Username,
+			Password:     opts.Password,
+			TLSConfig:    tlsConfig,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			PoolTimeout:  cfg.PoolTimeout,
+		}), nil
+	}
+
+	return nil, fmt.Errorf("unknown REDIS_MODE %q", cfg.Mode)
+}
+
+// redisTLSConfig enables TLS for rediss:// URLs or when REDIS_TLS is set,
+// optionally trusting a private CA. The server name is only set when
+// REDIS_TLS_SERVER_NAME is; otherwise each connection verifies the host it
+// dials, which is what Sentinel and Cluster nodes need.
+func redisTLSConfig(cfg RedisConfig, opts *redis.Options) (*tls.Config, error) {
+	if opts.TLSConfig == nil && !cfg.TLS {
+		return nil, nil
 	}
 
-	RedisClient = redis.NewClient(opts)
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: cfg.TLSServerName}
+	tlsConfig.InsecureSkipVerify = cfg.TLSInsecureSkipVerify
+
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read REDIS_TLS_CA_FILE: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("REDIS_TLS_CA_FILE contains no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// pingWithRetry pings until Redis answers, doubling the delay between
+// attempts up to maxRetryDelay
+func pingWithRetry(ctx context.Context, client redis.UniversalClient, retries int, delay time.Duration) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = client.Ping(ctx).Err(); err == nil {
+			return nil
+		}
+		if attempt >= retries {
+			return fmt.Errorf("Redis not reachable after %d attempts: %v", attempt+1, err)
+		}
+
+		slog.WarnContext(ctx, "redis not reachable, retrying",
+			slog.Int("attempt", attempt+1),
+			slog.String("retry_in", delay.String()),
+			slog.Any("error", err),
+		)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Redis not reachable: %v", ctx.Err())
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// DeleteKeys deletes keys with one command per key, which unlike a single
+// multi-key DEL also works when the keys live in different Cluster slots.
+// It returns how many keys existed.
+func DeleteKeys(ctx context.Context, client redis.UniversalClient, keys ...string) (int64, error) {
+	return perKeyCount(ctx, client, keys, func(pipe redis.Pipeliner, key string) *redis.IntCmd {
+		return pipe.Del(ctx, key)
+	})
+}
+
+// CountExisting returns how many of keys exist, one command per key for the
+// same Cluster reason as DeleteKeys
+func CountExisting(ctx context.Context, client redis.UniversalClient, keys ...string) (int64, error) {
+	return perKeyCount(ctx, client, keys, func(pipe redis.Pipeliner, key string) *redis.IntCmd {
+		return pipe.Exists(ctx, key)
+	})
+}
+
+func perKeyCount(ctx context.Context, client redis.UniversalClient, keys []string, cmd func(redis.Pipeliner, string) *redis.IntCmd) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	pipe := client.Pipeline()
+	cmds := make([]*redis.IntCmd, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, cmd(pipe, key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, c := range cmds {
+		total += c.Val()
+	}
+	return total, nil
 }
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+// APIKeyController serves the admin API key endpoints
+type APIKeyController struct {
+	store *models.Store
+}
+
+// NewAPIKeyController builds an APIKeyController over store
+func NewAPIKeyController(store *models.Store) *APIKeyController {
+	return &APIKeyController{store: store}
+}
+
+// ListAPIKeys returns the metadata of the caller's tenant's API keys
+func (c *APIKeyController) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := c.store.ListAPIKeys(r.Context(), middleware.TenantFromRequest(r))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// RevokeAPIKey deletes an API key of the caller's tenant by ID
+func (c *APIKeyController) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	revoked, err := c.store.RevokeAPIKey(r.Context(), middleware.TenantFromRequest(r), mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if !revoked {
+		utils.WriteError(w, http.StatusNotFound, "not_found", "API key not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+const (
+	defaultAnalyticsDays = 30
+	maxAnalyticsDays     = 366
+	defaultAnalyticsTop  = 10
+	maxAnalyticsEvents   = 1000
+)
+
+// chatTurn collects what happened during one chat turn for analytics: the
+// intent, the products tools returned and whether it escalated. A nil
+// *chatTurn ignores everything.
+type chatTurn struct {
+	mu        sync.Mutex
+	intent    string
+	products  []string
+	escalated bool
+}
+
+// setIntent records the classified intent of the turn
+func (t *chatTurn) setIntent(intent string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.intent = intent
+}
+
+// Intent returns the classified intent, empty before classification
+func (t *chatTurn) Intent() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.intent
+}
+
+// escalate marks the turn as handed over to human support
+func (t *chatTurn) escalate() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.escalated = true
+}
+
+// recordTool notes the products a tool returned, and escalates the turn
+// when a tool failed since the model is then told to offer human support
+func (t *chatTurn) recordTool(response genai.FunctionResponse) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if errorCode, _ := response.Response["error"].(string); errorCode != "" && errorCode != "verified_identity_required" {
+		t.escalated = true
+	}
+	// Fresh results are []map[string]any, cached ones decode as []any
+	switch products := response.Response["products"].(type) {
+	case []map[string]any:
+		for _, product := range products {
+			t.addProduct(product)
+		}
+	case []any:
+		for _, product := range products {
+			if product, ok := product.(map[string]any); ok {
+				t.addProduct(product)
+			}
+		}
+	}
+}
+
+func (t *chatTurn) addProduct(product map[string]any) {
+	if handle, _ := product["handle"].(string); handle != "" {
+		t.products = append(t.products, handle)
+	}
+}
+
+// recordChatEvent appends the analytics event of a chat turn, logging
+// rather than failing the request
+func (c *ChatController) recordChatEvent(ctx context.Context, tenant, sessionID string, start time.Time, turn *chatTurn, result LLMResult, blocked bool, chatErr error) {
+	if c.analytics == nil {
+		return
+	}
+
+	event := models.ChatEvent{
+		At:          start.UTC(),
+		Tenant:      tenant,
+		SessionHash: models.HashIdentifier(sessionID),
+		ToolCalls:   result.ToolCalls,
+		LatencyMs:   time.Since(start).Milliseconds(),
+		Outcome:     models.OutcomeAnswered,
+		TotalTokens: result.TotalTokens(),
+	}
+	if result.Usage != nil {
+		event.PromptTokens = int64(result.Usage.PromptTokenCount)
+		event.CompletionTokens = int64(result.Usage.CandidatesTokenCount)
+	}
+	switch {
+	case chatErr != nil:
+		event.Outcome = models.OutcomeError
+	case blocked:
+		event.Outcome = models.OutcomeBlocked
+	}
+
+	turn.mu.Lock()
+	event.Intent = turn.intent
+	event.Products = turn.products
+	event.Escalated = turn.escalated
+	turn.mu.Unlock()
+
+	if err := c.analytics.Append(ctx, event); err != nil {
+		slog.ErrorContext(ctx, "error recording chat event", slog.String("tenant", tenant), slog.Any("error", err))
+	}
+}
+
+// AnalyticsController serves the conversation analytics of the caller's
+// tenant
+type AnalyticsController struct {
+	cfg   *config.Config
+	store models.AnalyticsStore
+}
+
+// NewAnalyticsController returns an AnalyticsController reading from store
+func NewAnalyticsController(cfg *config.Config, store models.AnalyticsStore) *AnalyticsController {
+	return &AnalyticsController{cfg: cfg, store: store}
+}
+
+// analyticsRange parses ?from=YYYY-MM-DD&to=YYYY-MM-DD as whole UTC days,
+// both inclusive. It defaults to the last 30 days.
+func analyticsRange(r *http.Request) (time.Time, time.Time, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to := today
+	if raw := r.URL.Query().Get("to"); raw != "" {
+		day, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("to must be a date formatted YYYY-MM-DD")
+		}
+		to = day
+	}
+	from := to.AddDate(0, 0, -(defaultAnalyticsDays - 1))
+	if raw := r.URL.Query().Get("from"); raw != "" {
+		day, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("from must be a date formatted YYYY-MM-DD")
+		}
+		from = day
+	}
+
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, errors.New("from must not be after to")
+	}
+	if to.Sub(from) >= maxAnalyticsDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("the range must not exceed %d days", maxAnalyticsDays)
+	}
+	return from, to.Add(24 * time.Hour), nil
+}
+
+// loadEvents validates the request and reads the tenant's events in range
+func (c *AnalyticsController) loadEvents(w http.ResponseWriter, r *http.Request) (string, time.Time, time.Time, []models.ChatEvent, bool) {
+	if c.store == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "analytics_disabled", "Analytics storage is not configured")
+		return "", time.Time{}, time.Time{}, nil, false
+	}
+
+	from, to, err := analyticsRange(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return "", time.Time{}, time.Time{}, nil, false
+	}
+
+	tenant := middleware.TenantFromRequest(r)
+	events, err := c.store.Range(r.Context(), tenant, from, to)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return "", time.Time{}, time.Time{}, nil, false
+	}
+	return tenant, from, to, events, true
+}
+
+// GetAnalyticsSummary aggregates the tenant's chat events over
+// ?from=YYYY-MM-DD&to=YYYY-MM-DD: top intents and products, resolution
+// rate, latency, tokens and cost per conversation. ?top= bounds the ranked
+// lists.
+func (c *AnalyticsController) GetAnalyticsSummary(w http.ResponseWriter, r *http.Request) {
+	top := defaultAnalyticsTop
+	if raw := r.URL.Query().Get("top"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 100 {
+			utils.WriteError(w, http.StatusBadRequest, "invalid_request", "top must be an integer between 1 and 100")
+			return
+		}
+		top = n
+	}
+
+	tenant, from, to, events, ok := c.loadEvents(w, r)
+	if !ok {
+		return
+	}
+
+	report := models.AggregateChatEvents(events, top, c.cfg.Analytics)
+	report.Tenant = tenant
+	report.From = from
+	report.To = to
+	utils.WriteJSON(w, http.StatusOK, report)
+}
+
+// ListAnalyticsEvents returns the tenant's most recent raw chat events in
+// the range, newest first, at most ?limit= (default 100)
+func (c *AnalyticsController) ListAnalyticsEvents(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxAnalyticsEvents {
+			utils.WriteError(w, http.StatusBadRequest, "invalid_request",
+				fmt.Sprintf("limit must be an integer between 1 and %d", maxAnalyticsEvents))
+			return
+		}
+		limit = n
+	}
+
+	_, _, _, events, ok := c.loadEvents(w, r)
+	if !ok {
+		return
+	}
+
+	newest := make([]models.ChatEvent, 0, limit)
+	for i := len(events) - 1; i >= 0 && len(newest) < limit; i-- {
+		newest = append(newest, events[i])
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"events": newest, "total": len(events)})
+}
@@ -0,0 +1,504 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/notify"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+var (
+	errVariantNotFound    = errors.New("variant not found")
+	errVariantInStock     = errors.New("variant is in stock")
+	errVariantUntracked   = errors.New("variant stock is not tracked")
+	errTooManyBackInStock = errors.New("too many registrations for this target")
+)
+
+// BackInStockController registers shoppers for restock notifications and
+// notifies them when inventory webhooks or the poller see stock return
+type BackInStockController struct {
+	cfg      *config.Config
+	shopify  ShopifyClient
+	store    *models.Store
+	notifier notify.Notifier
+}
+
+// NewBackInStockController returns a BackInStockController delivering
+// through notifier and keeping subscriptions in store; a nil notifier only
+// logs notifications
+func NewBackInStockController(cfg *config.Config, shopify ShopifyClient, store *models.Store, notifier notify.Notifier) *BackInStockController {
+	if notifier == nil {
+		notifier = notify.NewLog()
+	}
+	return &BackInStockController{cfg: cfg, shopify: shopify, store: store, notifier: notifier}
+}
+
+// BackInStockRequest registers an email or an HTTPS webhook for a variant
+type BackInStockRequest struct {
+	VariantID  string `json:"variantId"`
+	Email      string `json:"email"`
+	WebhookURL string `json:"webhookUrl"`
+}
+
+// backInStockTarget validates the request's channel and target
+func backInStockTarget(req BackInStockRequest) (string, string, error) {
+	email := strings.TrimSpace(req.Email)
+	webhookURL := strings.TrimSpace(req.WebhookURL)
+	switch {
+	case email != "" && webhookURL != "":
+		return "", "", fmt.Errorf("set either email or webhookUrl, not both")
+	case email != "":
+		address, err := mail.ParseAddress(email)
+		if err != nil || address.Address != email {
+			return "", "", fmt.Errorf("email is not a valid address")
+		}
+		return notify.ChannelEmail, strings.ToLower(email), nil
+	case webhookURL != "":
+		// Webhooks are called from the server, so plain HTTP and internal
+		// hosts are refused; the notifier checks resolved addresses again
+		u, err := url.Parse(webhookURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+			return "", "", fmt.Errorf("webhookUrl must be an https URL")
+		}
+		if !notify.PublicHost(u.Hostname()) {
+			return "", "", fmt.Errorf("webhookUrl must be a public host")
+		}
+		return notify.ChannelWebhook, u.String(), nil
+	}
+	return "", "", fmt.Errorf("email or webhookUrl is required")
+}
+
+// subscribe registers target for variantID on behalf of tenant. The
+// variant must be published, tracked and sold out, and each target may be
+// registered a few times a day.
+func (c *BackInStockController) subscribe(ctx context.Context, tenant, variantID, channel, target string) (models.BackInStockSubscription, bool, error) {
+	count, err := c.store.CountBackInStockRegistration(ctx, target)
+	if err != nil {
+		return models.BackInStockSubscription{}, false, err
+	}
+	if count > int64(c.cfg.BackInStock.TargetDailyLimit) {
+		return models.BackInStockSubscription{}, false, errTooManyBackInStock
+	}
+
+	// The Admin API also sees drafts and unpublished products, so the variant
+	// is first looked up through the Storefront API
+	variantGID := models.GIDFromID("ProductVariant", variantID)
+	published, err := c.variantPublished(ctx, variantGID)
+	if err != nil {
+		return models.BackInStockSubscription{}, false, err
+	}
+	if !published {
+		return models.BackInStockSubscription{}, false, errVariantNotFound
+	}
+
+	query := `query BackInStockVariant($id: ID!) {
+	productVariant(id: $id) {
+		id
+		title
+		inventoryQuantity
+		product { id handle title }
+		inventoryItem { id tracked }
+	}
+}`
+	var data struct {
+		ProductVariant *struct {
+			ID                string `json:"id"`
+			Title             string `json:"title"`
+			InventoryQuantity int    `json:"inventoryQuantity"`
+			Product           struct {
+				ID     string `json:"id"`
+				Handle string `json:"handle"`
+				Title  string `json:"title"`
+			} `json:"product"`
+			InventoryItem struct {
+				ID      string `json:"id"`
+				Tracked bool   `json:"tracked"`
+			} `json:"inventoryItem"`
+		} `json:"productVariant"`
+	}
+	if err := executeAdminQuery(ctx, c.shopify, query, map[string]interface{}{"id": variantGID}, &data); err != nil {
+		return models.BackInStockSubscription{}, false, err
+	}
+
+	variant := data.ProductVariant
+	switch {
+	case variant == nil:
+		return models.BackInStockSubscription{}, false, errVariantNotFound
+	case !variant.InventoryItem.Tracked:
+		return models.BackInStockSubscription{}, false, errVariantUntracked
+	case variant.InventoryQuantity > 0:
+		return models.BackInStockSubscription{}, false, errVariantInStock
+	}
+
+	return c.store.SaveBackInStockSubscription(ctx, models.BackInStockSubscription{
+		VariantID:       variant.ID,
+		InventoryItemID: variant.InventoryItem.ID,
+		ProductID:       variant.Product.ID,
+		ProductHandle:   variant.Product.Handle,
+		ProductTitle:    variant.Product.Title,
+		VariantTitle:    variant.Title,
+		Channel:         channel,
+		Target:          target,
+		Tenant:          tenant,
+	}, c.cfg.BackInStock.SubscriptionTTL)
+}
+
+// variantPublished reports whether the Storefront API sees the variant,
+// which it only does for products published to the storefront
+func (c *BackInStockController) variantPublished(ctx context.Context, variantGID string) (bool, error) {
+	query := `query PublishedVariant($id: ID!) {
+	node(id: $id) { ... on ProductVariant { id } }
+}`
+	var data struct {
+		Node *struct {
+			ID string `json:"id"`
+		} `json:"node"`
+	}
+	if err := executeStorefrontQuery(ctx, c.shopify, query, map[string]interface{}{"id": variantGID}, &data); err != nil {
+		return false, err
+	}
+	return data.Node != nil && data.Node.ID != "", nil
+}
+
+// unsubscribeURL is the link that cancels sub
+func (c *BackInStockController) unsubscribeURL(sub models.BackInStockSubscription) string {
+	path := fmt.Sprintf("/api/chatbot/back-in-stock/%s/unsubscribe?token=%s", sub.ID, sub.Token)
+	return strings.TrimRight(c.cfg.BackInStock.PublicURL, "/") + path
+}
+
+// subscriptionView is a subscription as returned to the shopper
+func (c *BackInStockController) subscriptionView(sub models.BackInStockSubscription) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             sub.ID,
+		"status":         sub.Status,
+		"variantId":      sub.VariantID,
+		"productTitle":   sub.ProductTitle,
+		"variantTitle":   sub.VariantTitle,
+		"channel":        sub.Channel,
+		"unsubscribeUrl": c.unsubscribeURL(sub),
+	}
+}
+
+// Subscribe registers an email or webhook to be notified once when a sold
+// out variant is back in stock. Repeating a registration returns the
+// existing subscription.
+func (c *BackInStockController) Subscribe(w http.ResponseWriter, r *http.Request) {
+	var req BackInStockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	variantID := models.NumericIDFromGID(strings.TrimSpace(req.VariantID))
+	if _, err := strconv.ParseUint(variantID, 10, 64); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "variantId must be a variant ID")
+		return
+	}
+	channel, target, err := backInStockTarget(req)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	sub, created, err := c.subscribe(r.Context(), middleware.TenantFromRequest(r), variantID, channel, target)
+	switch {
+	case errors.Is(err, errVariantNotFound):
+		utils.WriteError(w, http.StatusNotFound, "not_found", "Variant not found")
+		return
+	case errors.Is(err, errVariantInStock):
+		utils.WriteError(w, http.StatusConflict, "in_stock", "Variant is in stock")
+		return
+	case errors.Is(err, errVariantUntracked):
+		utils.WriteError(w, http.StatusConflict, "not_tracked", "Variant stock is not tracked")
+		return
+	case errors.Is(err, errTooManyBackInStock):
+		utils.WriteError(w, http.StatusTooManyRequests, "rate_limited", "Too many alerts requested for this address today")
+		return
+	case err != nil:
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	if !created {
+		// The unsubscribe link only goes to whoever registered first
+		view := c.subscriptionView(sub)
+		delete(view, "unsubscribeUrl")
+		utils.WriteJSON(w, http.StatusOK, view)
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, c.subscriptionView(sub))
+}
+
+// Unsubscribe cancels a subscription from its unsubscribe link. GET only
+// describes the subscription, since mail scanners follow links; POST
+// cancels it. Unknown subscriptions and wrong tokens are both reported as
+// not found.
+func (c *BackInStockController) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	sub, err := c.store.GetBackInStockSubscription(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error loading back-in-stock subscription", slog.Any("error", err))
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", "Error loading subscription")
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if sub == nil || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(sub.Token)) != 1 {
+		utils.WriteError(w, http.StatusNotFound, "not_found", "Subscription not found")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		view := c.subscriptionView(*sub)
+		view["confirm"] = "POST to unsubscribeUrl to cancel this alert"
+		utils.WriteJSON(w, http.StatusOK, view)
+		return
+	}
+
+	if err := c.store.DeleteBackInStockSubscription(r.Context(), *sub); err != nil {
+		slog.ErrorContext(r.Context(), "error deleting back-in-stock subscription", slog.Any("error", err))
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", "Error deleting subscription")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"unsubscribed": true})
+}
+
+// restockNotification is the message sent to sub
+func (c *BackInStockController) restockNotification(sub models.BackInStockSubscription, productURL string) notify.Notification {
+	name := sub.ProductTitle
+	if sub.VariantTitle != "" && sub.VariantTitle != "Default Title" {
+		name = fmt.Sprintf("%s (%s)", sub.ProductTitle, sub.VariantTitle)
+	}
+	unsubscribeURL := c.unsubscribeURL(sub)
+
+	body := fmt.Sprintf("Good news: %s is back in stock.\n", name)
+	if productURL != "" {
+		body += fmt.Sprintf("\nShop it here: %s\n", productURL)
+	}
+	body += fmt.Sprintf("\nYou asked to hear once when it returned. To cancel alerts you have not received yet: %s\n", unsubscribeURL)
+
+	return notify.Notification{
+		Channel: sub.Channel,
+		Target:  sub.Target,
+		Subject: name + " is back in stock",
+		Body:    body,
+		Data: map[string]any{
+			"event":          "back_in_stock",
+			"subscriptionId": sub.ID,
+			"variantId":      sub.VariantID,
+			"productId":      sub.ProductID,
+			"productHandle":  sub.ProductHandle,
+			"productTitle":   sub.ProductTitle,
+			"variantTitle":   sub.VariantTitle,
+			"productUrl":     productURL,
+			"unsubscribeUrl": unsubscribeURL,
+		},
+	}
+}
+
+// notifyRestock notifies every subscriber of a restocked inventory item
+// once. A delivery claim guards against the webhook and the poller racing;
+// failed deliveries release it and are retried on the next restock.
+func (c *BackInStockController) notifyRestock(ctx context.Context, itemID string) {
+	subs, err := c.store.ActiveBackInStockSubscriptions(ctx, itemID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading back-in-stock subscriptions", slog.String("inventory_item", itemID), slog.Any("error", err))
+		return
+	}
+
+	productURLs := map[string]string{}
+	for _, sub := range subs {
+		claimed, err := c.store.ClaimBackInStockDelivery(ctx, sub.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "error claiming back-in-stock delivery", slog.String("subscription", sub.ID), slog.Any("error", err))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		productURL, ok := productURLs[sub.ProductID]
+		if !ok {
+			productURL = c.productURL(ctx, sub.ProductID)
+			productURLs[sub.ProductID] = productURL
+		}
+
+		if err := c.notifier.Notify(ctx, c.restockNotification(sub, productURL)); err != nil {
+			slog.ErrorContext(ctx, "error sending back-in-stock notification", slog.String("subscription", sub.ID), slog.String("channel", sub.Channel), slog.Any("error", err))
+			if err := c.store.ReleaseBackInStockDelivery(ctx, sub.ID); err != nil {
+				slog.ErrorContext(ctx, "error releasing back-in-stock delivery", slog.String("subscription", sub.ID), slog.Any("error", err))
+			}
+			continue
+		}
+		if err := c.store.MarkBackInStockNotified(ctx, sub); err != nil {
+			slog.ErrorContext(ctx, "error marking back-in-stock subscription notified", slog.String("subscription", sub.ID), slog.Any("error", err))
+		}
+	}
+}
+
+// productURL returns the online store URL of a product, or "" when it is not
+// published or cannot be read
+func (c *BackInStockController) productURL(ctx context.Context, productID string) string {
+	query := `query BackInStockProductURL($id: ID!) {
+	product(id: $id) { onlineStoreUrl }
+}`
+	var data struct {
+		Product *struct {
+			OnlineStoreURL string `json:"onlineStoreUrl"`
+		} `json:"product"`
+	}
+	if err := executeAdminQuery(ctx, c.shopify, query, map[string]interface{}{"id": productID}, &data); err != nil {
+		slog.WarnContext(ctx, "error loading product URL", slog.String("product", productID), slog.Any("error", err))
+		return ""
+	}
+	if data.Product == nil {
+		return ""
+	}
+	return data.Product.OnlineStoreURL
+}
+
+// PollRestocks checks every inventory item with subscribers and notifies
+// those that are available again, for shops without inventory webhooks
+func (c *BackInStockController) PollRestocks(ctx context.Context) error {
+	items, err := c.store.BackInStockItems(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `query BackInStockLevels($id: ID!) {
+	inventoryItem(id: $id) {
+		inventoryLevels(first: 50) {
+			nodes { quantities(names: ["available"]) { name quantity } }
+		}
+	}
+}`
+	for _, itemID := range items {
+		var data struct {
+			InventoryItem *struct {
+				InventoryLevels struct {
+					Nodes []struct {
+						Quantities []struct {
+							Name     string `json:"name"`
+							Quantity int    `json:"quantity"`
+						} `json:"quantities"`
+					} `json:"nodes"`
+				} `json:"inventoryLevels"`
+			} `json:"inventoryItem"`
+		}
+		if err := executeAdminQuery(ctx, c.shopify, query, map[string]interface{}{"id": models.GIDFromID("InventoryItem", itemID)}, &data); err != nil {
+			slog.ErrorContext(ctx, "error polling inventory item", slog.String("inventory_item", itemID), slog.Any("error", err))
+			continue
+		}
+		if data.InventoryItem == nil {
+			continue
+		}
+
+		available := 0
+		for _, level := range data.InventoryItem.InventoryLevels.Nodes {
+			for _, quantity := range level.Quantities {
+				if quantity.Name == "available" && quantity.Quantity > 0 {
+					available += quantity.Quantity
+				}
+			}
+		}
+		if available > 0 {
+			c.notifyRestock(ctx, itemID)
+		}
+	}
+	return nil
+}
+
+// Poll runs PollRestocks every interval until ctx is cancelled
+func (c *BackInStockController) Poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.PollRestocks(ctx); err != nil {
+				slog.ErrorContext(ctx, "error polling back-in-stock subscriptions", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// runBackInStockTool registers the shopper's email for a sold out variant.
+// The email arrives as the redaction placeholder the model saw and is
+// restored here, so it never passes through the model.
+func (c *ChatController) runBackInStockTool(ctx context.Context, cc chatContext, args map[string]any) (map[string]any, error) {
+	inventoryController := &InventoryController{cfg: c.cfg, shopify: c.shopify, cache: c.cache}
+	backInStock := &BackInStockController{cfg: c.cfg, shopify: c.shopify, store: c.store}
+	handle, _ := args["productHandle"].(string)
+	email, _ := args["email"].(string)
+	var options []string
+	if raw, _ := args["options"].([]any); raw != nil {
+		for _, option := range raw {
+			if option, ok := option.(string); ok && option != "" {
+				options = append(options, option)
+			}
+		}
+	}
+
+	if cc.Redactor != nil {
+		email = cc.Redactor.Restore(email)
+	}
+	channel, target, err := backInStockTarget(BackInStockRequest{Email: email})
+	if err != nil {
+		return map[string]any{"refused": "invalid_email", "message": "Ask the shopper for the email address to notify."}, nil
+	}
+
+	productID, err := inventoryController.publishedProductID(ctx, handle)
+	if errors.Is(err, errInventoryProductNotFound) {
+		return map[string]any{"found": false, "message": "No product with that handle; search the catalog first."}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	inventory, err := inventoryController.productInventory(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []models.VariantInventory
+	for _, variant := range inventory.Variants {
+		if variantMatches(variant, options) {
+			matches = append(matches, variant)
+		}
+	}
+	if len(matches) != 1 {
+		titles := []string{}
+		for _, variant := range matches {
+			titles = append(titles, variant.Title)
+		}
+		return map[string]any{"refused": "ambiguous_variant", "message": "Ask which variant the shopper wants.", "variants": titles}, nil
+	}
+
+	sub, _, err := backInStock.subscribe(ctx, cc.Tenant, models.NumericIDFromGID(matches[0].VariantID), channel, target)
+	switch {
+	case errors.Is(err, errVariantInStock):
+		return map[string]any{"refused": "in_stock", "message": "This variant is in stock now; no alert is needed."}, nil
+	case errors.Is(err, errVariantUntracked):
+		return map[string]any{"refused": "not_tracked", "message": "This variant's stock is not tracked, so no alert can be sent."}, nil
+	case errors.Is(err, errVariantNotFound):
+		return map[string]any{"found": false, "message": "That variant no longer exists."}, nil
+	case errors.Is(err, errTooManyBackInStock):
+		return map[string]any{"refused": "rate_limited", "message": "Too many alerts were requested for this address today; ask the shopper to try tomorrow."}, nil
+	case err != nil:
+		return nil, err
+	}
+	return map[string]any{"subscribed": true, "product": sub.ProductTitle, "variant": sub.VariantTitle, "message": "The shopper will get one email when it is back in stock, with a link to unsubscribe."}, nil
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"strategy-fox-go-bd/pkg/metrics"
+)
+
+const productCacheTTL = 60 * time.Second
+
+// Cache stores serialized responses for a limited time. Get returns an
+// error for missing keys as well as failures; callers treat both as a miss.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// redisCache is the Cache backed by the shared Redis client
+type redisCache struct {
+	client redis.UniversalClient
+}
+
+// NewRedisCache returns a Cache storing entries in client, or nil when no
+// client is configured so lookups always miss
+func NewRedisCache(client redis.UniversalClient) Cache {
+	if client == nil {
+		return nil
+	}
+	return redisCache{client: client}
+}
+
+func (c redisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	return c.client.Get(ctx, key).Bytes()
+}
+
+func (c redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (c redisCache) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, key).Err()
+}
+
+// getCachedJSON loads a cached JSON value into out, reporting whether the
+// key was found. Cache failures are logged and treated as misses.
+func getCachedJSON(ctx context.Context, cache Cache, key string, out interface{}) bool {
+	if cache == nil {
+		return false
+	}
+
+	cachedData, err := cache.Get(ctx, key)
+	metrics.ObserveCacheLookup(ctx, key, err == nil)
+	if err != nil {
+		return false
+	}
+
+	if err := json.Unmarshal(cachedData, out); err != nil {
+		slog.WarnContext(ctx, "error decoding cached data", slog.String("key", key), slog.Any("error", err))
+		return false
+	}
+
+	return true
+}
+
+// setCachedJSON stores value as JSON under key for the given TTL
+func setCachedJSON(ctx context.Context, cache Cache, key string, value interface{}, ttl time.Duration) {
+	if cache == nil {
+		return
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		slog.WarnContext(ctx, "error encoding cache data", slog.String("key", key), slog.Any("error", err))
+		return
+	}
+
+	if err := cache.Set(ctx, key, data, ttl); err != nil {
+		slog.WarnContext(ctx, "error caching data in Redis", slog.String("key", key), slog.Any("error", err))
+	}
+}
+
+// deleteCached drops key so the next read fetches it again
+func deleteCached(ctx context.Context, cache Cache, key string) {
+	if cache == nil {
+		return
+	}
+
+	if err := cache.Delete(ctx, key); err != nil {
+		slog.WarnContext(ctx, "error deleting cached data in Redis", slog.String("key", key), slog.Any("error", err))
+	}
+}
@@ -0,0 +1,346 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// maxToolRounds bounds how many times the model may call tools per message
+const maxToolRounds = 5
+
+// chatTool is a function the model can call. Tools that touch account data
+// set RequiresIdentity and refuse to run unless the session has a verified
+// Shopify customer.
+type chatTool struct {
+	Declaration      *genai.FunctionDeclaration
+	RequiresIdentity bool
+	Run              func(c *ChatController, ctx context.Context, cc chatContext, args map[string]any) (map[string]any, error)
+}
+
+var chatTools = map[string]chatTool{
+	"search_products": {
+		Declaration: &genai.FunctionDeclaration{
+			Name:        "search_products",
+			Description: "Search the shop catalog. Use it before recommending or describing specific products, prices or availability.",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"query": {Type: genai.TypeString, Description: "Search terms, e.g. \"floral summer dress\""},
+					"limit": {Type: genai.TypeInteger, Description: "Maximum number of products, 1 to 10"},
+				},
+				Required: []string{"query"},
+			},
+		},
+		Run: (*ChatController).runSearchProductsTool,
+	},
+	"get_order_history": {
+		Declaration: &genai.FunctionDeclaration{
+			Name:        "get_order_history",
+			Description: "List the signed-in shopper's most recent orders with status and items. Requires a verified customer identity.",
+			Parameters:  &genai.Schema{Type: genai.TypeObject, Properties: map[string]*genai.Schema{}},
+		},
+		RequiresIdentity: true,
+		Run:              (*ChatController).runOrderHistoryTool,
+	},
+	"check_return_eligibility": {
+		Declaration: &genai.FunctionDeclaration{
+			Name:        "check_return_eligibility",
+			Description: "Check which items of one of the signed-in shopper's orders can be returned or exchanged, and until when. Requires a verified customer identity.",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"orderId": {Type: genai.TypeString, Description: "Order id from get_order_history"},
+				},
+				Required: []string{"orderId"},
+			},
+		},
+		RequiresIdentity: true,
+		Run:              (*ChatController).runReturnEligibilityTool,
+	},
+	"suggest_exchange": {
+		Declaration: &genai.FunctionDeclaration{
+			Name:        "suggest_exchange",
+			Description: "Suggest in-stock variants to exchange an order item for, e.g. the next size up when it was too small. Requires a verified customer identity.",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"orderId":    {Type: genai.TypeString, Description: "Order id from get_order_history"},
+					"lineItemId": {Type: genai.TypeString, Description: "Line item id from check_return_eligibility"},
+					"reason":     returnReasonSchema,
+				},
+				Required: []string{"orderId", "lineItemId", "reason"},
+			},
+		},
+		RequiresIdentity: true,
+		Run:              (*ChatController).runSuggestExchangeTool,
+	},
+	"request_return": {
+		Declaration: &genai.FunctionDeclaration{
+			Name:        "request_return",
+			Description: "Submit a return request for an eligible order item, optionally as an exchange. Only call it after the shopper confirmed the item, quantity and reason. Requires a verified customer identity.",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"orderId":           {Type: genai.TypeString, Description: "Order id from get_order_history"},
+					"lineItemId":        {Type: genai.TypeString, Description: "Line item id from check_return_eligibility"},
+					"quantity":          {Type: genai.TypeInteger, Description: "How many units to return"},
+					"reason":            returnReasonSchema,
+					"note":              {Type: genai.TypeString, Description: "Optional note from the shopper for the merchant"},
+					"exchangeVariantId": {Type: genai.TypeString, Description: "Variant id from suggest_exchange when the shopper wants an exchange"},
+				},
+				Required: []string{"orderId", "lineItemId", "quantity", "reason"},
+			},
+		},
+		RequiresIdentity: true,
+		Run:              (*ChatController).runRequestReturnTool,
+	},
+	"get_return_status": {
+		Declaration: &genai.FunctionDeclaration{
+			Name:        "get_return_status",
+			Description: "Get the status, reference and any shipping label of one of the signed-in shopper's returns. Requires a verified customer identity.",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"returnId": {Type: genai.TypeString, Description: "Return id from request_return"},
+				},
+				Required: []string{"returnId"},
+			},
+		},
+		RequiresIdentity: true,
+		Run:              (*ChatController).runReturnStatusTool,
+	},
+	"get_promotions": {
+		Declaration: &genai.FunctionDeclaration{
+			Name:        "get_promotions",
+			Description: "List the shop's current promotions and discount codes, optionally only those that apply to one product or collection. Never mention a code this tool did not return.",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"productHandle":    {Type: genai.TypeString, Description: "Handle of a product from search_products"},
+					"collectionHandle": {Type: genai.TypeString, Description: "Handle of a collection, e.g. \"summer\""},
+				},
+			},
+		},
+		Run: (*ChatController).runPromotionsTool,
+	},
+	"browse_collection": {
+		Declaration: &genai.FunctionDeclaration{
+			Name:        "browse_collection",
+			Description: "Show products of a collection such as new arrivals or a category. Without a handle, or with an unknown one, it lists the shop's collections.",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"handle": {Type: genai.TypeString, Description: "Collection handle, e.g. \"new-arrivals\""},
+					"sort":   {Type: genai.TypeString, Format: "enum", Enum: []string{"best-selling", "created", "price", "title"}, Description: "Product order"},
+					"limit":  {Type: genai.TypeInteger, Description: "Maximum number of products, 1 to 10"},
+				},
+			},
+		},
+		Run: (*ChatController).runBrowseCollectionTool,
+	},
+	"check_stock": {
+		Declaration: &genai.FunctionDeclaration{
+			Name:        "check_stock",
+			Description: "Check whether a product is in stock, optionally in specific option values such as a size or colour and near a place. Returns stock status per variant and location, with low-stock signals.",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"productHandle": {Type: genai.TypeString, Description: "Handle of a product from search_products"},
+					"options":       {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}, Description: "Option values the shopper wants, e.g. [\"M\", \"Black\"]"},
+					"near":          {Type: genai.TypeString, Description: "City, store name, region or country code the shopper is near"},
+				},
+				Required: []string{"productHandle"},
+			},
+		},
+		Run: (*ChatController).runCheckStockTool,
+	},
+	"notify_back_in_stock": {
+		Declaration: &genai.FunctionDeclaration{
+			Name:        "notify_back_in_stock",
+			Description: "Email the shopper once when a sold out product variant is back in stock. Use after check_stock shows the variant is out of stock and the shopper has shared their email.",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"productHandle": {Type: genai.TypeString, Description: "Handle of a product from search_products"},
+					"options":       {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}, Description: "Option values selecting exactly one variant, e.g. [\"M\", \"Black\"]"},
+					"email":         {Type: genai.TypeString, Description: "The shopper's email, or its placeholder such as [EMAIL_1]"},
+				},
+				Required: []string{"productHandle", "email"},
+			},
+		},
+		Run: (*ChatController).runBackInStockTool,
+	},
+}
+
+// chatToolDeclarations returns the declarations of the chat tools the
+// route allows, or nil when it allows none
+func chatToolDeclarations(route intentRoute) []*genai.Tool {
+	declarations := make([]*genai.FunctionDeclaration, 0, len(chatTools))
+	for name, tool := range chatTools {
+		if route.allows(name) {
+			declarations = append(declarations, tool.Declaration)
+		}
+	}
+	if len(declarations) == 0 {
+		return nil
+	}
+	return []*genai.Tool{{FunctionDeclarations: declarations}}
+}
+
+// runChatTool executes a tool call, enforcing the identity rule. Failures
+// are returned to the model as an error field so it can explain them.
+func (c *ChatController) runChatTool(ctx context.Context, cc chatContext, call genai.FunctionCall) genai.FunctionResponse {
+	tool, ok := chatTools[call.Name]
+	if !ok || !routeFor(cc.Intent).allows(call.Name) {
+		return genai.FunctionResponse{Name: call.Name, Response: map[string]any{"error": "unknown tool"}}
+	}
+
+	if tool.RequiresIdentity && cc.Identity == nil {
+		return genai.FunctionResponse{Name: call.Name, Response: map[string]any{
+			"error":   "verified_identity_required",
+			"message": "The shopper is not signed in. Ask them to sign in to their account in the chat widget; never ask for order details to look up someone else's account.",
+		}}
+	}
+
+	result, err := tool.Run(c, ctx, cc, call.Args)
+	if err != nil {
+		slog.WarnContext(ctx, "chat tool failed", slog.String("tool", call.Name), slog.Any("error", err))
+		return genai.FunctionResponse{Name: call.Name, Response: map[string]any{"error": "The lookup failed, apologise and offer to connect the shopper with support."}}
+	}
+	return genai.FunctionResponse{Name: call.Name, Response: result}
+}
+
+// identityInstruction tells the model whether account tools are available
+func identityInstruction(cc chatContext) string {
+	if cc.Identity != nil {
+		return "\nThe shopper is a signed-in, verified customer. You may use account tools such as get_order_history for their own account only.\n"
+	}
+	return "\nThe shopper is not signed in. For order status, order history or other account questions, ask them to sign in first; account tools will refuse until they do.\n"
+}
+
+// runSearchProductsTool searches the Storefront catalog. Results are cached
+// like product reads since shoppers often repeat the same searches.
+func (c *ChatController) runSearchProductsTool(ctx context.Context, cc chatContext, args map[string]any) (map[string]any, error) {
+	query, _ := args["query"].(string)
+	limit := 5
+	if n, ok := args["limit"].(float64); ok && n >= 1 && n <= 10 {
+		limit = int(n)
+	}
+
+	redisKey := fmt.Sprintf("products:search:%d:%s:%s", limit, query, cc.Locale.CacheKey())
+	var cached map[string]any
+	if getCachedJSON(ctx, c.cache, redisKey, &cached) {
+		return cached, nil
+	}
+
+	variables := map[string]interface{}{"query": query, "first": limit}
+	gql := withInContext("query SearchProducts($query: String!, $first: Int!)", cc.Locale, variables) + ` {
+	products(first: $first, query: $query) {
+		edges { node { ...ProductFields } }
+		pageInfo { hasNextPage endCursor }
+	}
+}
+` + productFieldsFragment
+
+	var data struct {
+		Products struct {
+			Edges []struct {
+				Node struct {
+					Handle     string `json:"handle"`
+					Title      string `json:"title"`
+					PriceRange struct {
+						MinVariantPrice struct {
+							Amount       string `json:"amount"`
+							CurrencyCode string `json:"currencyCode"`
+						} `json:"minVariantPrice"`
+					} `json:"priceRange"`
+					Options []struct {
+						Name   string   `json:"name"`
+						Values []string `json:"values"`
+					} `json:"options"`
+					Variants struct {
+						Edges []struct {
+							Node struct {
+								Title            string `json:"title"`
+								AvailableForSale bool   `json:"availableForSale"`
+							} `json:"node"`
+						} `json:"edges"`
+					} `json:"variants"`
+				} `json:"node"`
+			} `json:"edges"`
+		} `json:"products"`
+	}
+	if err := executeStorefrontQuery(ctx, c.shopify, gql, variables, &data); err != nil {
+		return nil, err
+	}
+
+	products := []map[string]any{}
+	for _, edge := range data.Products.Edges {
+		p := edge.Node
+		var available []string
+		for _, v := range p.Variants.Edges {
+			if v.Node.AvailableForSale {
+				available = append(available, v.Node.Title)
+			}
+		}
+		products = append(products, map[string]any{
+			"title":             p.Title,
+			"handle":            p.Handle,
+			"fromPrice":         fmt.Sprintf("%s %s", p.PriceRange.MinVariantPrice.Amount, p.PriceRange.MinVariantPrice.CurrencyCode),
+			"options":           p.Options,
+			"availableVariants": available,
+		})
+	}
+	result := map[string]any{"products": products}
+	setCachedJSON(ctx, c.cache, redisKey, result, productCacheTTL)
+	return result, nil
+}
+
+// runOrderHistoryTool lists the verified customer's recent orders through
+// the Admin API
+func (c *ChatController) runOrderHistoryTool(ctx context.Context, cc chatContext, args map[string]any) (map[string]any, error) {
+	query := `query CustomerOrders($id: ID!) {
+	customer(id: $id) {
+		orders(first: 5, sortKey: PROCESSED_AT, reverse: true) {
+			edges {
+				node {
+					id
+					name
+					processedAt
+					displayFinancialStatus
+					displayFulfillmentStatus
+					totalPriceSet { shopMoney { amount currencyCode } }
+					lineItems(first: 10) {
+						edges { node { id title variantTitle quantity } }
+					}
+				}
+			}
+		}
+	}
+}`
+
+	var data struct {
+		Customer *struct {
+			Orders struct {
+				Edges []struct {
+					Node map[string]any `json:"node"`
+				} `json:"edges"`
+			} `json:"orders"`
+		} `json:"customer"`
+	}
+	if err := executeAdminQuery(ctx, c.shopify, query, map[string]interface{}{"id": cc.Identity.CustomerID}, &data); err != nil {
+		return nil, err
+	}
+	if data.Customer == nil {
+		return map[string]any{"orders": []any{}}, nil
+	}
+
+	orders := []map[string]any{}
+	for _, edge := range data.Customer.Orders.Edges {
+		orders = append(orders, edge.Node)
+	}
+	return map[string]any{"orders": orders}, nil
+}
@@ -0,0 +1,446 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+const defaultCollectionPageSize = 20
+
+// errCollectionNotFound is returned for an unknown collection handle
+var errCollectionNotFound = errors.New("collection not found")
+
+// collectionSortKeys maps the ?sort= values to Storefront
+// ProductCollectionSortKeys
+var collectionSortKeys = map[string]string{
+	"collection-default": "COLLECTION_DEFAULT",
+	"manual":             "MANUAL",
+	"best-selling":       "BEST_SELLING",
+	"created":            "CREATED",
+	"price":              "PRICE",
+	"title":              "TITLE",
+	"relevance":          "RELEVANCE",
+}
+
+// shopifyCollection is a collection in the Storefront response shape
+type shopifyCollection struct {
+	ID          string                  `json:"id"`
+	Handle      string                  `json:"handle"`
+	Title       string                  `json:"title"`
+	Description string                  `json:"description"`
+	UpdatedAt   string                  `json:"updatedAt"`
+	Image       *models.CollectionImage `json:"image"`
+}
+
+func (c shopifyCollection) toCollection() models.Collection {
+	return models.Collection{
+		ID:          c.ID,
+		NumericID:   models.NumericIDFromGID(c.ID),
+		Handle:      c.Handle,
+		Title:       c.Title,
+		Description: c.Description,
+		Image:       c.Image,
+		UpdatedAt:   c.UpdatedAt,
+	}
+}
+
+// parsePageSize reads ?first= between 1 and maxProductPageSize
+func parsePageSize(r *http.Request, fallback int) (int, error) {
+	raw := r.URL.Query().Get("first")
+	if raw == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > maxProductPageSize {
+		return 0, fmt.Errorf("first must be an integer between 1 and %d", maxProductPageSize)
+	}
+	return n, nil
+}
+
+// collectionProductsQuery is the sort order, filters and page of a
+// collection's products
+type collectionProductsQuery struct {
+	First   int
+	After   string
+	SortKey string
+	Reverse bool
+	Filters []map[string]interface{}
+}
+
+// parseCollectionProductsQuery reads ?first=, ?after=, ?sort=, ?reverse=
+// and the filters: ?available=, ?minPrice=, ?maxPrice=, ?productType=,
+// ?vendor=, repeated ?tag= and ?option=Name:Value, and repeated ?filter=
+// holding the input of a facet value
+func parseCollectionProductsQuery(r *http.Request) (collectionProductsQuery, error) {
+	q := r.URL.Query()
+	first, err := parsePageSize(r, defaultProductPageSize)
+	if err != nil {
+		return collectionProductsQuery{}, err
+	}
+	query := collectionProductsQuery{First: first, After: q.Get("after"), Filters: []map[string]interface{}{}}
+
+	if raw := q.Get("sort"); raw != "" {
+		sortKey, ok := collectionSortKeys[strings.ToLower(raw)]
+		if !ok {
+			return collectionProductsQuery{}, fmt.Errorf("unknown sort %q", raw)
+		}
+		query.SortKey = sortKey
+	}
+	if raw := q.Get("reverse"); raw != "" {
+		if query.Reverse, err = strconv.ParseBool(raw); err != nil {
+			return collectionProductsQuery{}, errors.New("reverse must be true or false")
+		}
+	}
+
+	if raw := q.Get("available"); raw != "" {
+		available, err := strconv.ParseBool(raw)
+		if err != nil {
+			return collectionProductsQuery{}, errors.New("available must be true or false")
+		}
+		query.Filters = append(query.Filters, map[string]interface{}{"available": available})
+	}
+	price := map[string]interface{}{}
+	for param, bound := range map[string]string{"minPrice": "min", "maxPrice": "max"} {
+		if raw := q.Get(param); raw != "" {
+			amount, err := strconv.ParseFloat(raw, 64)
+			if err != nil || amount < 0 {
+				return collectionProductsQuery{}, fmt.Errorf("%s must be a non-negative number", param)
+			}
+			price[bound] = amount
+		}
+	}
+	if len(price) > 0 {
+		query.Filters = append(query.Filters, map[string]interface{}{"price": price})
+	}
+	if productType := q.Get("productType"); productType != "" {
+		query.Filters = append(query.Filters, map[string]interface{}{"productType": productType})
+	}
+	if vendor := q.Get("vendor"); vendor != "" {
+		query.Filters = append(query.Filters, map[string]interface{}{"productVendor": vendor})
+	}
+	for _, tag := range q["tag"] {
+		query.Filters = append(query.Filters, map[string]interface{}{"tag": tag})
+	}
+	for _, option := range q["option"] {
+		name, value, ok := strings.Cut(option, ":")
+		if !ok || name == "" || value == "" {
+			return collectionProductsQuery{}, errors.New("option must be formatted Name:Value")
+		}
+		query.Filters = append(query.Filters, map[string]interface{}{"variantOption": map[string]interface{}{"name": name, "value": value}})
+	}
+	for _, raw := range q["filter"] {
+		var filter map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &filter); err != nil || len(filter) == 0 {
+			return collectionProductsQuery{}, errors.New("filter must be the JSON input of a facet value")
+		}
+		query.Filters = append(query.Filters, filter)
+	}
+	return query, nil
+}
+
+// listCollections returns a page of collections, sorted by title
+func (c *ShopifyController) listCollections(ctx context.Context, first int, after string, locale models.Locale) (models.CollectionList, error) {
+	redisKey := fmt.Sprintf("collections:list:%d:%s:%s", first, after, locale.CacheKey())
+	var list models.CollectionList
+	if getCachedJSON(ctx, c.cache, redisKey, &list) {
+		return list, nil
+	}
+
+	variables := map[string]interface{}{"first": first}
+	if after != "" {
+		variables["after"] = after
+	}
+	query := withInContext("query Collections($first: Int!, $after: String)", locale, variables) + ` {
+	collections(first: $first, after: $after, sortKey: TITLE) {
+		edges { node { id handle title description updatedAt image { url altText } } }
+		pageInfo { hasNextPage endCursor }
+	}
+}`
+
+	var data struct {
+		Collections struct {
+			Edges []struct {
+				Node shopifyCollection `json:"node"`
+			} `json:"edges"`
+			PageInfo models.ShopifyPageInfo `json:"pageInfo"`
+		} `json:"collections"`
+	}
+	if err := executeStorefrontQuery(ctx, c.shopify, query, variables, &data); err != nil {
+		return models.CollectionList{}, err
+	}
+
+	list = models.CollectionList{
+		Collections: []models.Collection{},
+		PageInfo:    models.PageInfo{HasNextPage: data.Collections.PageInfo.HasNextPage, EndCursor: data.Collections.PageInfo.EndCursor},
+	}
+	for _, edge := range data.Collections.Edges {
+		list.Collections = append(list.Collections, edge.Node.toCollection())
+	}
+	setCachedJSON(ctx, c.cache, redisKey, list, productCacheTTL)
+	return list, nil
+}
+
+// collectionPage returns a page of a collection's products with its facets
+func (c *ShopifyController) collectionPage(ctx context.Context, handle string, params collectionProductsQuery, locale models.Locale) (models.CollectionPage, error) {
+	variables := map[string]interface{}{"handle": handle, "first": params.First, "filters": params.Filters}
+	if params.After != "" {
+		variables["after"] = params.After
+	}
+	if params.SortKey != "" {
+		variables["sortKey"] = params.SortKey
+	}
+	if params.Reverse {
+		variables["reverse"] = true
+	}
+
+	// The variables are the canonical form of the request; encoding a map
+	// sorts its keys
+	canonical, _ := json.Marshal(variables)
+	redisKey := fmt.Sprintf("collections:handle:%s:%s", canonical, locale.CacheKey())
+	var page models.CollectionPage
+	if getCachedJSON(ctx, c.cache, redisKey, &page) {
+		return page, nil
+	}
+
+	query := withInContext("query CollectionByHandle($handle: String!, $first: Int!, $after: String, $sortKey: ProductCollectionSortKeys, $reverse: Boolean, $filters: [ProductFilter!])", locale, variables) + ` {
+	collection(handle: $handle) {
+		id
+		handle
+		title
+		description
+		updatedAt
+		image { url altText }
+		products(first: $first, after: $after, sortKey: $sortKey, reverse: $reverse, filters: $filters) {
+			edges { node { ...ProductGQFields } }
+			pageInfo { hasNextPage endCursor }
+			filters { id label type values { id label count input } }
+		}
+	}
+}
+` + productGQFieldsFragment
+
+	var data struct {
+		Collection *struct {
+			shopifyCollection
+			Products struct {
+				Edges    json.RawMessage           `json:"edges"`
+				PageInfo json.RawMessage           `json:"pageInfo"`
+				Filters  []models.CollectionFilter `json:"filters"`
+			} `json:"products"`
+		} `json:"collection"`
+	}
+	if err := executeStorefrontQuery(ctx, c.shopify, query, variables, &data); err != nil {
+		return models.CollectionPage{}, err
+	}
+	if data.Collection == nil {
+		return models.CollectionPage{}, errCollectionNotFound
+	}
+
+	products, err := json.Marshal(map[string]json.RawMessage{"edges": data.Collection.Products.Edges, "pageInfo": data.Collection.Products.PageInfo})
+	if err != nil {
+		return models.CollectionPage{}, err
+	}
+	page = models.CollectionPage{
+		Collection: data.Collection.toCollection(),
+		Products:   products,
+		Filters:    data.Collection.Products.Filters,
+	}
+	if page.Filters == nil {
+		page.Filters = []models.CollectionFilter{}
+	}
+	setCachedJSON(ctx, c.cache, redisKey, page, productCacheTTL)
+	return page, nil
+}
+
+// GetCollections returns a page of collections, ?first= (default 20) after
+// the ?after= cursor
+func (c *ShopifyController) GetCollections(w http.ResponseWriter, r *http.Request) {
+	first, err := parsePageSize(r, defaultCollectionPageSize)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	w.Header().Set("Vary", "Accept-Language")
+
+	list, err := c.listCollections(r.Context(), first, r.URL.Query().Get("after"), utils.ParseLocale(r))
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, list)
+}
+
+// GetCollectionByHandle returns a collection with a sorted, filtered page
+// of its products and the facets available to narrow them
+func (c *ShopifyController) GetCollectionByHandle(w http.ResponseWriter, r *http.Request) {
+	params, err := parseCollectionProductsQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	w.Header().Set("Vary", "Accept-Language")
+
+	page, err := c.collectionPage(r.Context(), mux.Vars(r)["handle"], params, utils.ParseLocale(r))
+	if errors.Is(err, errCollectionNotFound) {
+		utils.WriteError(w, http.StatusNotFound, "not_found", "Collection not found")
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+// publishedCollectionID resolves a collection handle through the
+// Storefront API, which only sees collections published to the storefront
+func (c *ShopifyController) publishedCollectionID(ctx context.Context, handle string) (string, error) {
+	query := `query PublishedCollection($handle: String!) {
+	collection(handle: $handle) { id }
+}`
+	var data struct {
+		Collection *struct {
+			ID string `json:"id"`
+		} `json:"collection"`
+	}
+	if err := executeStorefrontQuery(ctx, c.shopify, query, map[string]interface{}{"handle": handle}, &data); err != nil {
+		return "", err
+	}
+	if data.Collection == nil {
+		return "", errCollectionNotFound
+	}
+	return data.Collection.ID, nil
+}
+
+// GetCollectionRules returns the rules of a smart collection. Rules are
+// only exposed by the Admin API, so the collection is first resolved
+// through the Storefront API and unpublished collections are not found.
+func (c *ShopifyController) GetCollectionRules(w http.ResponseWriter, r *http.Request) {
+	collectionID, err := c.publishedCollectionID(r.Context(), mux.Vars(r)["handle"])
+	if errors.Is(err, errCollectionNotFound) {
+		utils.WriteError(w, http.StatusNotFound, "not_found", "Collection not found")
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	redisKey := "collections:rules:" + models.NumericIDFromGID(collectionID)
+	var rules models.CollectionRules
+	if getCachedJSON(r.Context(), c.cache, redisKey, &rules) {
+		utils.WriteJSON(w, http.StatusOK, rules)
+		return
+	}
+
+	query := `query CollectionRules($id: ID!) {
+	collection(id: $id) {
+		handle
+		ruleSet {
+			appliedDisjunctively
+			rules { column relation condition }
+		}
+	}
+}`
+	var data struct {
+		Collection *struct {
+			Handle  string `json:"handle"`
+			RuleSet *struct {
+				AppliedDisjunctively bool                    `json:"appliedDisjunctively"`
+				Rules                []models.CollectionRule `json:"rules"`
+			} `json:"ruleSet"`
+		} `json:"collection"`
+	}
+	if err := executeAdminQuery(r.Context(), c.shopify, query, map[string]interface{}{"id": collectionID}, &data); err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	if data.Collection == nil {
+		utils.WriteError(w, http.StatusNotFound, "not_found", "Collection not found")
+		return
+	}
+
+	rules = models.CollectionRules{Handle: data.Collection.Handle, Rules: []models.CollectionRule{}}
+	if ruleSet := data.Collection.RuleSet; ruleSet != nil {
+		rules.Smart = true
+		rules.AppliedDisjunctively = ruleSet.AppliedDisjunctively
+		rules.Rules = append(rules.Rules, ruleSet.Rules...)
+	}
+	setCachedJSON(r.Context(), c.cache, redisKey, rules, productCacheTTL)
+	utils.WriteJSON(w, http.StatusOK, rules)
+}
+
+// runBrowseCollectionTool lists a page of a collection's products for the
+// model. An unknown handle returns the shop's collections so the model can
+// pick the right one.
+func (c *ChatController) runBrowseCollectionTool(ctx context.Context, cc chatContext, args map[string]any) (map[string]any, error) {
+	shop := &ShopifyController{shopify: c.shopify, cache: c.cache}
+	handle, _ := args["handle"].(string)
+	params := collectionProductsQuery{First: 5, Filters: []map[string]interface{}{}}
+	if n, ok := args["limit"].(float64); ok && n >= 1 && n <= 10 {
+		params.First = int(n)
+	}
+	if sort, _ := args["sort"].(string); sort != "" {
+		params.SortKey = collectionSortKeys[sort]
+	}
+
+	page, err := shop.collectionPage(ctx, handle, params, cc.Locale)
+	if errors.Is(err, errCollectionNotFound) || (err == nil && handle == "") {
+		list, err := shop.listCollections(ctx, defaultCollectionPageSize, "", cc.Locale)
+		if err != nil {
+			return nil, err
+		}
+		collections := []map[string]any{}
+		for _, collection := range list.Collections {
+			collections = append(collections, map[string]any{"title": collection.Title, "handle": collection.Handle})
+		}
+		return map[string]any{"found": false, "collections": collections}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var connection struct {
+		Edges []struct {
+			Node struct {
+				Handle   string `json:"handle"`
+				Title    string `json:"title"`
+				Variants struct {
+					Edges []struct {
+						Node models.ShopifyVariantNode `json:"node"`
+					} `json:"edges"`
+				} `json:"variants"`
+			} `json:"node"`
+		} `json:"edges"`
+	}
+	if err := json.Unmarshal(page.Products, &connection); err != nil {
+		return nil, err
+	}
+
+	products := []map[string]any{}
+	for _, edge := range connection.Edges {
+		p := edge.Node
+		product := map[string]any{"title": p.Title, "handle": p.Handle}
+		var available []string
+		for _, v := range p.Variants.Edges {
+			if _, ok := product["price"]; !ok {
+				product["price"] = fmt.Sprintf("%s %s", v.Node.Price.Amount, v.Node.Price.CurrencyCode)
+			}
+			if v.Node.AvailableForSale {
+				available = append(available, v.Node.Title)
+			}
+		}
+		product["availableVariants"] = available
+		products = append(products, product)
+	}
+	return map[string]any{"found": true, "collection": page.Collection.Title, "products": products}, nil
+}
@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+// GDPRRequest identifies the data subject of an erasure request
+type GDPRRequest struct {
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Confirm bool   `json:"confirm"`
+}
+
+// GDPRController serves data subject requests. Besides the registered
+// shopper data sources it covers the chat events in the analytics store.
+type GDPRController struct {
+	store     *models.Store
+	analytics models.AnalyticsStore
+}
+
+// NewGDPRController returns a GDPRController over the shopper data in
+// store, also exporting and erasing the events in analytics
+func NewGDPRController(store *models.Store, analytics models.AnalyticsStore) *GDPRController {
+	return &GDPRController{store: store, analytics: analytics}
+}
+
+// sources returns the shopper data sources bound to the controller's stores
+func (c *GDPRController) sources() []models.ShopperDataSource {
+	if c.analytics == nil {
+		return nil
+	}
+	return []models.ShopperDataSource{models.AnalyticsDataSource(c.analytics)}
+}
+
+// gdprActor names the admin performing a data subject request
+func gdprActor(r *http.Request) string {
+	if principal, ok := middleware.PrincipalFromContext(r.Context()); ok {
+		return principal.Subject
+	}
+	return "unknown"
+}
+
+// auditGDPR stores an audit record in the caller's tenant's trail, logging
+// rather than failing the request
+func (c *GDPRController) auditGDPR(r *http.Request, record models.GDPRAuditRecord) {
+	record.Tenant = middleware.TenantFromRequest(r)
+	record.Actor = gdprActor(r)
+	if err := c.store.AppendGDPRAudit(r.Context(), record); err != nil {
+		slog.ErrorContext(r.Context(), "error writing GDPR audit record", slog.Any("error", err))
+	}
+}
+
+// ExportShopperData returns every record the caller's tenant stores about a
+// shopper as a JSON archive. The shopper is identified by
+// ?type=session|email|customer&value=...
+func (c *GDPRController) ExportShopperData(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("type")
+	value := r.URL.Query().Get("value")
+
+	identity, err := c.store.ResolveShopperIdentity(r.Context(), middleware.TenantFromRequest(r), kind, value)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	record := models.GDPRAuditRecord{
+		Action:         "export",
+		IdentifierType: kind,
+		IdentifierHash: models.HashIdentifier(value),
+		Sessions:       len(identity.SessionIDs),
+	}
+
+	archive, err := c.store.ExportShopperData(r.Context(), identity, c.sources()...)
+	if err != nil {
+		record.Status = "failed"
+		c.auditGDPR(r, record)
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	record.Status = "completed"
+	c.auditGDPR(r, record)
+
+	filename := fmt.Sprintf("shopper-export-%s.json", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"generatedAt": time.Now().UTC(),
+		"identifier":  map[string]string{"type": kind},
+		"data":        archive,
+	})
+}
+
+// EraseShopperData deletes every record the caller's tenant stores about a
+// shopper and verifies the deletion. The body must set "confirm": true.
+func (c *GDPRController) EraseShopperData(w http.ResponseWriter, r *http.Request) {
+	var req GDPRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON request body")
+		return
+	}
+	if !req.Confirm {
+		utils.WriteError(w, http.StatusBadRequest, "confirmation_required", "Set \"confirm\": true to erase shopper data")
+		return
+	}
+
+	identity, err := c.store.ResolveShopperIdentity(r.Context(), middleware.TenantFromRequest(r), req.Type, req.Value)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	record := models.GDPRAuditRecord{
+		Action:         "erase",
+		IdentifierType: req.Type,
+		IdentifierHash: models.HashIdentifier(req.Value),
+		Sessions:       len(identity.SessionIDs),
+	}
+
+	report, err := c.store.EraseShopperData(r.Context(), identity, c.sources()...)
+	record.Erasure = &report
+	if err != nil {
+		record.Status = "failed"
+		c.auditGDPR(r, record)
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	record.Status = "completed"
+	if !report.Verified {
+		record.Status = "unverified"
+	}
+	c.auditGDPR(r, record)
+
+	status := http.StatusOK
+	if !report.Verified {
+		status = http.StatusInternalServerError
+	}
+	utils.WriteJSON(w, status, report)
+}
+
+// ListGDPRAudit returns the most recent data subject request audit records
+// of the caller's tenant
+func (c *GDPRController) ListGDPRAudit(w http.ResponseWriter, r *http.Request) {
+	limit := int64(100)
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+	}
+
+	records, err := c.store.ListGDPRAudit(r.Context(), middleware.TenantFromRequest(r), limit)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"records": records})
+}
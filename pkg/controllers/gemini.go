@@ -0,0 +1,299 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/option"
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/guardrails"
+	"strategy-fox-go-bd/pkg/logging"
+	"strategy-fox-go-bd/pkg/metrics"
+	"strategy-fox-go-bd/pkg/tracing"
+)
+
+// LLMProvider generates the assistant's replies. Chat runs a conversation
+// turn with tool calling; GenerateJSON returns a single completion that
+// follows a response schema.
+type LLMProvider interface {
+	Chat(ctx context.Context, req LLMChatRequest) (LLMResult, error)
+	GenerateJSON(ctx context.Context, req LLMJSONRequest) (LLMResult, error)
+}
+
+// LLMChatRequest is one shopper turn sent to the model. RunTool answers
+// every function call the model makes with one of Tools.
+type LLMChatRequest struct {
+	System  []genai.Part
+	History []*genai.Content
+	Message string
+	Tools   []*genai.Tool
+	RunTool func(ctx context.Context, call genai.FunctionCall) genai.FunctionResponse
+}
+
+// LLMJSONRequest asks for a structured completion of Message. Call names
+// the request in logs, spans and metrics.
+type LLMJSONRequest struct {
+	Call    string
+	System  string
+	Schema  *genai.Schema
+	Message string
+}
+
+// LLMResult is the model reply together with its token usage
+type LLMResult struct {
+	Text         string
+	Usage        *genai.UsageMetadata
+	ToolCalls    []string
+	FinishReason genai.FinishReason
+}
+
+// addUsage accumulates the token usage of another model response
+func (g *LLMResult) addUsage(usage *genai.UsageMetadata) {
+	if usage == nil {
+		return
+	}
+	if g.Usage == nil {
+		g.Usage = &genai.UsageMetadata{}
+	}
+	g.Usage.PromptTokenCount += usage.PromptTokenCount
+	g.Usage.CandidatesTokenCount += usage.CandidatesTokenCount
+	g.Usage.TotalTokenCount += usage.TotalTokenCount
+}
+
+// TotalTokens returns the prompt and completion tokens billed for the reply
+func (g LLMResult) TotalTokens() int64 {
+	if g.Usage == nil {
+		return 0
+	}
+	return int64(g.Usage.TotalTokenCount)
+}
+
+// geminiProvider is the LLMProvider backed by the Gemini API
+type geminiProvider struct {
+	cfg config.LLMConfig
+}
+
+// NewGeminiProvider returns an LLMProvider calling the configured Gemini model
+func NewGeminiProvider(cfg config.LLMConfig) LLMProvider {
+	return geminiProvider{cfg: cfg}
+}
+
+// newClient creates a Gemini client with the configured API key
+func (p geminiProvider) newClient(ctx context.Context) (*genai.Client, error) {
+	apiKey := p.cfg.GeminiAPIKey.Value()
+	if apiKey == "" {
+		return nil, fmt.Errorf("Gemini API key not set")
+	}
+
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("error creating Gemini client: %v", err)
+	}
+	return client, nil
+}
+
+func (p geminiProvider) Chat(ctx context.Context, req LLMChatRequest) (LLMResult, error) {
+	ctx, span := p.startSpan(ctx, "chat")
+	defer span.End()
+
+	client, err := p.newClient(ctx)
+	if err != nil {
+		return LLMResult{}, err
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel(p.cfg.Model)
+	model.SetTemperature(1)
+	model.SetTopK(64)
+	model.SetTopP(0.95)
+	model.SetMaxOutputTokens(8192)
+	model.ResponseMIMEType = "text/plain"
+	model.SafetySettings = guardrails.SafetySettings(p.cfg.SafetyThreshold)
+	model.Tools = req.Tools
+	model.SystemInstruction = &genai.Content{Parts: req.System}
+
+	session := model.StartChat()
+	session.History = req.History
+
+	var result LLMResult
+	start := time.Now()
+	resp, err := session.SendMessage(ctx, genai.Text(req.Message))
+	for round := 1; err == nil; round++ {
+		result.addUsage(resp.UsageMetadata)
+		if len(resp.Candidates) == 0 {
+			break
+		}
+
+		calls := resp.Candidates[0].FunctionCalls()
+		if len(calls) == 0 || round > maxToolRounds {
+			break
+		}
+
+		// Answer every tool call, then let the model continue
+		responses := make([]genai.Part, 0, len(calls))
+		for _, call := range calls {
+			result.ToolCalls = append(result.ToolCalls, call.Name)
+			responses = append(responses, req.RunTool(ctx, call))
+		}
+		if round == maxToolRounds {
+			// Out of tool rounds: the model has to answer with what it has
+			model.ToolConfig = &genai.ToolConfig{
+				FunctionCallingConfig: &genai.FunctionCallingConfig{Mode: genai.FunctionCallingNone},
+			}
+		}
+		resp, err = session.SendMessage(ctx, responses...)
+	}
+	if err == nil && len(resp.Candidates) > 0 {
+		result.FinishReason = resp.Candidates[0].FinishReason
+	}
+	logGeminiCall(ctx, "chat", start, result, err)
+	if err != nil {
+		return result, fmt.Errorf("error sending message to Gemini: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return result, fmt.Errorf("Gemini returned no candidates")
+	}
+
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if text, ok := part.(genai.Text); ok {
+			result.Text += string(text)
+		}
+	}
+
+	return result, nil
+}
+
+func (p geminiProvider) GenerateJSON(ctx context.Context, req LLMJSONRequest) (LLMResult, error) {
+	ctx, span := p.startSpan(ctx, req.Call)
+	defer span.End()
+
+	client, err := p.newClient(ctx)
+	if err != nil {
+		return LLMResult{}, err
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel(p.cfg.Model)
+	model.SetTemperature(0)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = req.Schema
+	model.SystemInstruction = &genai.Content{Parts: []genai.Part{genai.Text(req.System)}}
+
+	start := time.Now()
+	resp, err := model.GenerateContent(ctx, genai.Text(req.Message))
+	var result LLMResult
+	if resp != nil {
+		result.addUsage(resp.UsageMetadata)
+		if len(resp.Candidates) > 0 {
+			result.FinishReason = resp.Candidates[0].FinishReason
+		}
+	}
+	logGeminiCall(ctx, req.Call, start, result, err)
+	if err != nil {
+		return result, err
+	}
+
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return result, nil
+	}
+
+	text, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	if !ok {
+		return result, fmt.Errorf("unexpected %s response part %T", req.Call, resp.Candidates[0].Content.Parts[0])
+	}
+	result.Text = string(text)
+	return result, nil
+}
+
+// startSpan starts the span wrapping a Gemini call; logGeminiCall fills in
+// its usage attributes
+func (p geminiProvider) startSpan(ctx context.Context, call string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "gemini."+call,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gen_ai.system", "gemini"),
+			attribute.String("gen_ai.operation.name", call),
+			attribute.String("gen_ai.request.model", p.cfg.Model),
+		),
+	)
+}
+
+// logGeminiCall logs the latency and token usage of a Gemini call, adds its
+// tokens to the request's upstream cost and records it in the metrics and
+// the current span
+func logGeminiCall(ctx context.Context, call string, start time.Time, result LLMResult, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.StringSlice("gen_ai.tool_calls", result.ToolCalls))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	if fields := logging.Fields(ctx); fields != nil {
+		fields.AddLLMTokens(result.TotalTokens())
+	}
+	if result.Usage != nil {
+		span.SetAttributes(
+			attribute.Int("gen_ai.usage.input_tokens", int(result.Usage.PromptTokenCount)),
+			attribute.Int("gen_ai.usage.output_tokens", int(result.Usage.CandidatesTokenCount)),
+		)
+		metrics.ObserveGeminiTokens(ctx, call, int64(result.Usage.PromptTokenCount), int64(result.Usage.CandidatesTokenCount), int64(result.Usage.TotalTokenCount))
+	}
+	if reason := geminiFailureReason(result, err); reason != "" {
+		span.SetAttributes(attribute.String("gen_ai.response.finish_reason", reason))
+		metrics.ObserveGeminiError(ctx, call, reason)
+	}
+
+	attrs := []any{
+		slog.String("call", call),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.Int64("tokens", result.TotalTokens()),
+		slog.Any("tool_calls", result.ToolCalls),
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "gemini request failed", append(attrs, slog.Any("error", err))...)
+		return
+	}
+	slog.InfoContext(ctx, "gemini request", attrs...)
+}
+
+// geminiFailureReason names why a Gemini call failed or stopped early, or
+// returns "" for a natural stop
+func geminiFailureReason(result LLMResult, err error) string {
+	var blockedErr *genai.BlockedError
+	switch {
+	case errors.As(err, &blockedErr):
+		if blockedErr.Candidate != nil {
+			return finishReasonLabel(blockedErr.Candidate.FinishReason)
+		}
+		return "prompt_blocked"
+	case err != nil:
+		return "error"
+	case result.FinishReason == genai.FinishReasonStop, result.FinishReason == genai.FinishReasonUnspecified:
+		return ""
+	}
+	return finishReasonLabel(result.FinishReason)
+}
+
+// finishReasonLabel turns a finish reason into a metric label
+func finishReasonLabel(reason genai.FinishReason) string {
+	switch reason {
+	case genai.FinishReasonMaxTokens:
+		return "max_tokens"
+	case genai.FinishReasonSafety:
+		return "safety"
+	case genai.FinishReasonRecitation:
+		return "recitation"
+	case genai.FinishReasonStop:
+		return "stop"
+	}
+	return "other"
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"strategy-fox-go-bd/pkg/logging"
+	"strategy-fox-go-bd/pkg/metrics"
+	"strategy-fox-go-bd/pkg/tracing"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+// graphQLError is a single entry of the "errors" array of a GraphQL response
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+// graphQLResponse is the top-level GraphQL response envelope
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []graphQLError  `json:"errors"`
+}
+
+// graphQLCost is the query cost Shopify reports under "extensions", along
+// with any error codes used to detect throttling
+type graphQLCost struct {
+	Errors []struct {
+		Extensions struct {
+			Code string `json:"code"`
+		} `json:"extensions"`
+	} `json:"errors"`
+	Extensions struct {
+		Cost struct {
+			RequestedQueryCost float64 `json:"requestedQueryCost"`
+			ActualQueryCost    float64 `json:"actualQueryCost"`
+		} `json:"cost"`
+	} `json:"extensions"`
+}
+
+// graphQLOperationPattern matches the type and optional name of an operation
+var graphQLOperationPattern = regexp.MustCompile(`^\s*(query|mutation)\b\s*([A-Za-z_][A-Za-z0-9_]*)?`)
+
+// graphQLOperation returns the operation type and name of a GraphQL
+// document, naming anonymous operations "anonymous"
+func graphQLOperation(query string) (string, string) {
+	match := graphQLOperationPattern.FindStringSubmatch(query)
+	if match == nil {
+		return "query", "anonymous"
+	}
+	if match[2] == "" {
+		return match[1], "anonymous"
+	}
+	return match[1], match[2]
+}
+
+// sendGraphQLRequest posts a GraphQL operation to a Shopify API and returns
+// the raw response body. The request ID is forwarded as X-Request-ID and
+// the call's latency and query cost are logged against the request.
+func sendGraphQLRequest(ctx context.Context, client *http.Client, api, url, tokenHeader, accessToken, query string, variables map[string]interface{}) (_ []byte, err error) {
+	operationType, operationName := graphQLOperation(query)
+	ctx, span := tracing.Tracer().Start(ctx, "shopify.graphql "+operationName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("shopify.api", api),
+			attribute.String("graphql.operation.type", operationType),
+			attribute.String("graphql.operation.name", operationName),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	// Prepare request body
+	payload := map[string]interface{}{"query": query}
+	if len(variables) > 0 {
+		payload["variables"] = variables
+	}
+	requestBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %v", err)
+	}
+
+	// Create the HTTP POST request
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	// Set headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(tokenHeader, accessToken)
+	if requestID := logging.RequestID(ctx); requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	tracing.InjectHeaders(ctx, propagation.HeaderCarrier(req.Header))
+
+	// Send the request
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		metrics.ObserveShopifyRequest(ctx, api, "error", time.Since(start), 0)
+		slog.ErrorContext(ctx, "shopify request failed", slog.String("api", api), slog.Any("error", err))
+		return nil, fmt.Errorf("failed to execute request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	var cost graphQLCost
+	json.Unmarshal(body, &cost)
+	if fields := logging.Fields(ctx); fields != nil {
+		fields.AddShopifyCost(cost.Extensions.Cost.ActualQueryCost)
+	}
+	outcome := shopifyOutcome(resp.StatusCode, cost)
+	metrics.ObserveShopifyRequest(ctx, api, outcome, time.Since(start), cost.Extensions.Cost.ActualQueryCost)
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", resp.StatusCode),
+		attribute.String("shopify.outcome", outcome),
+		attribute.Float64("shopify.query_cost.requested", cost.Extensions.Cost.RequestedQueryCost),
+		attribute.Float64("shopify.query_cost.actual", cost.Extensions.Cost.ActualQueryCost),
+	)
+	slog.InfoContext(ctx, "shopify request",
+		slog.String("api", api),
+		slog.String("operation", operationName),
+		slog.Int("status", resp.StatusCode),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.Float64("query_cost", cost.Extensions.Cost.ActualQueryCost),
+		slog.String("shopify_request_id", resp.Header.Get("X-Request-Id")),
+	)
+
+	// Check for non-200 status codes
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GraphQL query failed with status: %d, response: %s", resp.StatusCode, body)
+	}
+
+	return body, nil
+}
+
+// executeStorefrontQuery runs a Storefront API query and decodes its "data"
+// object into out
+func executeStorefrontQuery(ctx context.Context, shopify ShopifyClient, query string, variables map[string]interface{}, out interface{}) error {
+	body, err := shopify.Storefront(ctx, query, variables)
+	if err != nil {
+		return err
+	}
+
+	return decodeGraphQLResponse(body, out)
+}
+
+// executeAdminQuery runs a server-side Admin API query and decodes its
+// "data" object into out. It must never back an anonymous public read; see
+// executeStorefrontQuery for those.
+func executeAdminQuery(ctx context.Context, shopify ShopifyClient, query string, variables map[string]interface{}, out interface{}) error {
+	body, err := shopify.Admin(ctx, query, variables)
+	if err != nil {
+		return err
+	}
+
+	return decodeGraphQLResponse(body, out)
+}
+
+// writeUpstreamError logs a failed Shopify call and answers 502 with a fixed
+// message. Upstream errors can carry Shopify response bodies, so clients
+// only get the request ID to quote when reporting the failure.
+func writeUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "upstream request failed", slog.String("path", r.URL.Path), slog.Any("error", err))
+
+	message := "The shop could not be reached, please try again later"
+	if requestID := logging.RequestID(r.Context()); requestID != "" {
+		message += " (request ID " + requestID + ")"
+	}
+	utils.WriteError(w, http.StatusBadGateway, "upstream_error", message)
+}
+
+// decodeGraphQLResponse decodes the "data" object of a raw GraphQL response
+// into out, turning a non-empty "errors" array into a Go error
+func decodeGraphQLResponse(body []byte, out interface{}) error {
+	var resp graphQLResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("failed to parse GraphQL response: %v", err)
+	}
+
+	if len(resp.Errors) > 0 {
+		messages := make([]string, 0, len(resp.Errors))
+		for _, e := range resp.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("GraphQL query returned errors: %s", strings.Join(messages, "; "))
+	}
+
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return fmt.Errorf("failed to decode GraphQL data: %v", err)
+	}
+
+	return nil
+}
+
+// shopifyOutcome classifies a Shopify response for metrics: "throttled" for
+// HTTP 429 or a THROTTLED GraphQL error, "ok" for 200, else the status code
+func shopifyOutcome(status int, cost graphQLCost) string {
+	if status == http.StatusTooManyRequests {
+		return "throttled"
+	}
+	for _, e := range cost.Errors {
+		if e.Extensions.Code == "THROTTLED" {
+			return "throttled"
+		}
+	}
+	if status == http.StatusOK {
+		return "ok"
+	}
+	return strconv.Itoa(status)
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/lifecycle"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+// shopifyCheckTTL spaces out Shopify token checks so frequent probes do not
+// spend API quota
+const shopifyCheckTTL = 30 * time.Second
+
+// ReadinessCheck is the outcome of one dependency check
+type ReadinessCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReadinessResponse is returned by /readyz
+type ReadinessResponse struct {
+	Status string                    `json:"status"`
+	Checks map[string]ReadinessCheck `json:"checks,omitempty"`
+}
+
+// HealthController serves the liveness and readiness probes
+type HealthController struct {
+	cfg     *config.Config
+	shopify ShopifyClient
+	redis   redis.UniversalClient
+
+	shopifyCheckMu     sync.Mutex
+	shopifyCheckResult ReadinessCheck
+	shopifyCheckedAt   time.Time
+}
+
+// NewHealthController returns a HealthController checking the given Shopify
+// client and Redis connection
+func NewHealthController(cfg *config.Config, shopify ShopifyClient, redisClient redis.UniversalClient) *HealthController {
+	return &HealthController{cfg: cfg, shopify: shopify, redis: redisClient}
+}
+
+// Healthz reports that the process is alive. It checks no dependencies so a
+// Redis or Shopify outage never gets the pod restarted.
+func (c *HealthController) Healthz(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether the server can take traffic: Redis reachable,
+// Shopify tokens accepted and the LLM provider configured. It fails as soon
+// as shutdown starts so load balancers stop routing new requests.
+func (c *HealthController) Readyz(w http.ResponseWriter, r *http.Request) {
+	if lifecycle.Draining() {
+		utils.WriteJSON(w, http.StatusServiceUnavailable, ReadinessResponse{Status: "draining"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	checks := map[string]ReadinessCheck{
+		"redis":   c.checkRedis(ctx),
+		"shopify": c.checkShopify(ctx),
+		"llm":     c.checkLLM(),
+	}
+
+	status := http.StatusOK
+	response := ReadinessResponse{Status: "ready", Checks: checks}
+	for name, check := range checks {
+		if !check.OK {
+			slog.WarnContext(r.Context(), "readiness check failed", slog.String("check", name), slog.String("error", check.Error))
+			status = http.StatusServiceUnavailable
+			response.Status = "unavailable"
+		}
+	}
+
+	utils.WriteJSON(w, status, response)
+}
+
+func (c *HealthController) checkRedis(ctx context.Context) ReadinessCheck {
+	if c.redis == nil {
+		return ReadinessCheck{Error: "not configured"}
+	}
+	if err := c.redis.Ping(ctx).Err(); err != nil {
+		return ReadinessCheck{Error: "unreachable"}
+	}
+	return ReadinessCheck{OK: true}
+}
+
+// checkShopify verifies the Storefront token, and the Admin token when set,
+// with a minimal shop query. Results are cached for shopifyCheckTTL.
+func (c *HealthController) checkShopify(ctx context.Context) ReadinessCheck {
+	c.shopifyCheckMu.Lock()
+	defer c.shopifyCheckMu.Unlock()
+
+	if time.Since(c.shopifyCheckedAt) < shopifyCheckTTL {
+		return c.shopifyCheckResult
+	}
+
+	c.shopifyCheckResult = ReadinessCheck{OK: true}
+	if err := c.verifyShopifyTokens(ctx); err != nil {
+		slog.WarnContext(ctx, "shopify token check failed", slog.Any("error", err))
+		c.shopifyCheckResult = ReadinessCheck{Error: "token rejected or Shopify unreachable"}
+	}
+	c.shopifyCheckedAt = time.Now()
+	return c.shopifyCheckResult
+}
+
+func (c *HealthController) verifyShopifyTokens(ctx context.Context) error {
+	query := `query ReadinessCheck { shop { name } }`
+
+	var data struct {
+		Shop *struct {
+			Name string `json:"name"`
+		} `json:"shop"`
+	}
+	if err := executeStorefrontQuery(ctx, c.shopify, query, nil, &data); err != nil {
+		return fmt.Errorf("storefront: %v", err)
+	}
+	if data.Shop == nil {
+		return fmt.Errorf("storefront: shop not returned")
+	}
+
+	if c.cfg.Shopify.AdminAccessToken == "" {
+		return nil
+	}
+	data.Shop = nil
+	if err := executeAdminQuery(ctx, c.shopify, query, nil, &data); err != nil {
+		return fmt.Errorf("admin: %v", err)
+	}
+	if data.Shop == nil {
+		return fmt.Errorf("admin: shop not returned")
+	}
+	return nil
+}
+
+// checkLLM only verifies configuration; calling Gemini on every probe would
+// cost tokens
+func (c *HealthController) checkLLM() ReadinessCheck {
+	if c.cfg.LLM.GeminiAPIKey == "" {
+		return ReadinessCheck{Error: "GEMINI_API_KEY not set"}
+	}
+	return ReadinessCheck{OK: true}
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+// IdentityRequest signs a Shopify customer in to the chat. Exactly one of
+// CustomerAccessToken or SignedSession must be set.
+type IdentityRequest struct {
+	CustomerAccessToken string `json:"customerAccessToken,omitempty"`
+	SignedSession       string `json:"signedSession,omitempty"`
+}
+
+// LinkedIdentity is a verified customer and the session issued for them.
+// The session ID is a bearer credential for the customer's data.
+type LinkedIdentity struct {
+	SessionID string `json:"sessionId"`
+	models.CustomerIdentity
+}
+
+// sessionHeader returns the chat session of a request. Sessions carrying an
+// identity are bearer credentials, so they are only read from the
+// X-Session-ID header, never from query strings that end up in logs.
+func sessionHeader(r *http.Request) string {
+	return r.Header.Get("X-Session-ID")
+}
+
+// signedSessionPayload is the body of a storefront-signed session. The shop
+// theme or app proxy signs it with STOREFRONT_SESSION_SECRET.
+type signedSessionPayload struct {
+	CustomerID string `json:"customerId"`
+	Exp        int64  `json:"exp"`
+}
+
+// verifyCustomerAccessToken checks a Storefront customer access token
+// against Shopify and returns the customer GID and token expiry
+func (c *ChatController) verifyCustomerAccessToken(ctx context.Context, token string) (string, time.Time, error) {
+	query := `query VerifyCustomer($token: String!) {
+	customer(customerAccessToken: $token) { id }
+}`
+
+	var data struct {
+		Customer *struct {
+			ID string `json:"id"`
+		} `json:"customer"`
+	}
+	if err := executeStorefrontQuery(ctx, c.shopify, query, map[string]interface{}{"token": token}, &data); err != nil {
+		return "", time.Time{}, err
+	}
+	if data.Customer == nil {
+		return "", time.Time{}, fmt.Errorf("customer access token is invalid or expired")
+	}
+
+	// Storefront tokens are long lived; the session link is capped separately
+	return data.Customer.ID, time.Now().Add(24 * time.Hour), nil
+}
+
+// verifySignedSession checks a "<payload>.<signature>" token where both parts
+// are base64url encoded and the signature is HMAC-SHA256 of the payload
+func (c *ChatController) verifySignedSession(token string) (string, time.Time, error) {
+	secret := c.cfg.Shopify.StorefrontSessionSecret.Value()
+	if secret == "" {
+		return "", time.Time{}, fmt.Errorf("STOREFRONT_SESSION_SECRET not set")
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", time.Time{}, fmt.Errorf("malformed signed session")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("malformed signed session signature")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", time.Time{}, fmt.Errorf("signed session signature mismatch")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("malformed signed session payload")
+	}
+	var payload signedSessionPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return "", time.Time{}, fmt.Errorf("malformed signed session payload")
+	}
+
+	expiresAt := time.Unix(payload.Exp, 0)
+	if time.Now().After(expiresAt) {
+		return "", time.Time{}, fmt.Errorf("signed session expired")
+	}
+	if !strings.HasPrefix(payload.CustomerID, "gid://shopify/Customer/") {
+		return "", time.Time{}, fmt.Errorf("signed session has no customer GID")
+	}
+
+	return payload.CustomerID, expiresAt, nil
+}
+
+// LinkIdentity verifies a customer credential and issues a new chat session
+// for the customer, unlocking tools that need a verified identity. Session
+// IDs chosen by clients never carry an identity.
+func (c *ChatController) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	var req IdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON request body")
+		return
+	}
+
+	var (
+		customerID string
+		expiresAt  time.Time
+		method     string
+		err        error
+	)
+	switch {
+	case req.CustomerAccessToken != "" && req.SignedSession == "":
+		method = "customer_access_token"
+		customerID, expiresAt, err = c.verifyCustomerAccessToken(r.Context(), req.CustomerAccessToken)
+	case req.SignedSession != "" && req.CustomerAccessToken == "":
+		method = "signed_session"
+		customerID, expiresAt, err = c.verifySignedSession(req.SignedSession)
+	default:
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Provide either customerAccessToken or signedSession")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, "identity_not_verified", err.Error())
+		return
+	}
+
+	identity := models.CustomerIdentity{
+		CustomerID: customerID,
+		Tenant:     middleware.TenantFromRequest(r),
+		Method:     method,
+		VerifiedAt: time.Now().UTC(),
+		ExpiresAt:  expiresAt.UTC(),
+	}
+	sessionID, err := models.NewSessionID()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := c.store.SetSessionIdentity(r.Context(), sessionID, identity); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, LinkedIdentity{SessionID: sessionID, CustomerIdentity: identity})
+}
+
+// GetIdentity returns the verified customer of the X-Session-ID session, if
+// any
+func (c *ChatController) GetIdentity(w http.ResponseWriter, r *http.Request) {
+	sessionID := sessionHeader(r)
+	if sessionID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "X-Session-ID header is required")
+		return
+	}
+
+	identity, err := c.store.GetSessionIdentity(r.Context(), sessionID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if identity == nil {
+		utils.WriteError(w, http.StatusNotFound, "not_found", "No verified customer for this session")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, identity)
+}
+
+// UnlinkIdentity signs the customer out of the X-Session-ID session
+func (c *ChatController) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	sessionID := sessionHeader(r)
+	if sessionID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "X-Session-ID header is required")
+		return
+	}
+
+	if err := c.store.ClearSessionIdentity(r.Context(), sessionID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Shopper intents. IntentGeneral is used when a message cannot be
+// classified and keeps the unrestricted assistant.
+const (
+	IntentProductSearch = "product_search"
+	IntentOrderStatus   = "order_status"
+	IntentSizeHelp      = "size_help"
+	IntentReturns       = "returns"
+	IntentPromotions    = "promotions"
+	IntentSmallTalk     = "small_talk"
+	IntentEscalate      = "escalate"
+	IntentGeneral       = "general"
+)
+
+// classifiedIntents are the intents the classifier may return
+var classifiedIntents = []string{
+	IntentProductSearch, IntentOrderStatus, IntentSizeHelp, IntentReturns,
+	IntentPromotions, IntentSmallTalk, IntentEscalate,
+}
+
+// intentRoute is how a turn of one intent is answered. Handle, when set,
+// may answer without calling the model; otherwise the model runs with the
+// route's tools and the Prompt variant appended to the system prompt.
+type intentRoute struct {
+	Tools    []string
+	AllTools bool
+	Prompt   string
+	Handle   func(c *ChatController, ctx context.Context, cc chatContext) (LLMResult, bool)
+}
+
+var intentRoutes = map[string]intentRoute{
+	IntentProductSearch: {
+		Tools:  []string{"search_products", "browse_collection", "check_stock", "notify_back_in_stock"},
+		Prompt: "\nThe shopper is looking for products. Search the catalog or browse a collection before naming any product or price, check stock with check_stock before promising availability, offer notify_back_in_stock when the wanted variant is sold out, and ask one short follow-up question when the request is vague.\n",
+	},
+	IntentOrderStatus: {
+		Tools:  []string{"get_order_history", "get_return_status"},
+		Prompt: "\nThe shopper is asking about an order. Use get_order_history for their own orders and summarise status and delivery clearly.\n",
+	},
+	IntentSizeHelp: {
+		Tools:  []string{"search_products", "check_stock", "notify_back_in_stock"},
+		Prompt: "\nThe shopper needs help with sizing. Use the sizes you remember about them and the product's options and fit notes; ask for measurements when you cannot recommend a size confidently.\n",
+	},
+	IntentReturns: {
+		Tools:  []string{"get_order_history", "check_return_eligibility", "suggest_exchange", "request_return", "get_return_status"},
+		Prompt: "\nThe shopper is asking about a return or exchange. Find the order with get_order_history and check it with check_return_eligibility before saying whether an item can be returned. Offer an exchange with suggest_exchange when the problem was the size or colour. Confirm the item, quantity and reason with the shopper before calling request_return, then give them the return reference. Never promise a refund.\n",
+	},
+	IntentPromotions: {
+		Tools:  []string{"search_products", "get_promotions"},
+		Prompt: "\nThe shopper is asking about promotions. Check get_promotions, for a specific product or collection when they name one, and only mention the promotions and codes it returns; never invent codes or percentages. When there are none, say so kindly.\n",
+	},
+	IntentSmallTalk: {
+		Prompt: "\nThe shopper is making small talk. Answer in one or two friendly sentences and offer to help them shop.\n",
+	},
+	IntentEscalate: {
+		Handle: (*ChatController).handleEscalation,
+	},
+	IntentGeneral: {
+		AllTools: true,
+	},
+}
+
+// escalationReply is sent when the shopper asks for a person. There is no
+// live handoff, so it points to the store's own contact options rather than
+// promising a follow-up.
+const escalationReply = "I'm an automated assistant, so I can't pass you to a person from this chat, sorry! 🙏 Our customer care team can be reached through the contact options on the store's website. Is there anything I can help with in the meantime?"
+
+// handleEscalation answers a request for a person without a model call,
+// marking the turn escalated so admins can see the demand
+func (c *ChatController) handleEscalation(ctx context.Context, cc chatContext) (LLMResult, bool) {
+	cc.Turn.escalate()
+	slog.InfoContext(ctx, "chat escalated to support", slog.String("tenant", cc.Tenant))
+	return LLMResult{Text: escalationReply}, true
+}
+
+// intentRules match common phrasings so most messages skip the classifier
+// call. They are English only; other languages go to the model.
+var (
+	escalateRule      = regexp.MustCompile(`(?i)(\b(speak|talk|chat) (to|with) (a |an |the |your )?(human|real person|person|someone|somebody|live agent|agent|representative|customer (service|care|support))|\b(connect|transfer|put) me (to|with|through to) (a |an |the |your )?(human|real person|person|someone|somebody|agent|representative|customer (service|care|support))|\b(want|need) (a |an )?(human|real person|live agent|representative)( please| now)?\s*([.!?,]|$)|\blive agent\b)`)
+	orderStatusRule   = regexp.MustCompile(`(?i)\b(where('s| is) my (order|package|parcel|delivery)|order status|track(ing)?( my)? (order|package|parcel)|has my order (shipped|been sent)|when will my (order|package|parcel) (arrive|ship))\b`)
+	returnsRule       = regexp.MustCompile(`(?i)\b(returns?|returning|refunds?|exchanges?|exchanging|send (it|this|them) back|money back)\b`)
+	sizeHelpRule      = regexp.MustCompile(`(?i)\b(what size|which size|size (guide|chart)|sizing|(fit|fits|run|runs) (small|large|big|true to size)|measurements?|too (small|big|tight|loose))\b`)
+	promotionsRule    = regexp.MustCompile(`(?i)(\b(discounts?|promos?|promotions?|coupons?|vouchers?|on sale|sales|deals?|special offers?|percent off)\b|% off)`)
+	smallTalkRule     = regexp.MustCompile(`(?i)^\s*(hi|hello|hey|hiya|good (morning|afternoon|evening)|thanks|thank you|cheers|bye|goodbye|how are you|who are you|what('s| is) your name)[\s!.,?]*$`)
+	productSearchRule = regexp.MustCompile(`(?i)\b(show me|looking for|do you (have|sell|stock)|search|find|recommend|suggest|in stock|dress(es)?|shirts?|jackets?|coats?|jeans|trousers|pants|skirts?|tops?|shoes|sneakers|boots|outfits?|accessor(y|ies)|bags?)\b`)
+)
+
+// classifyByRules returns the intent the rules agree on, or "" when none or
+// several specific intents match
+func classifyByRules(message string) string {
+	if escalateRule.MatchString(message) {
+		return IntentEscalate
+	}
+
+	var matched []string
+	for _, rule := range []struct {
+		intent string
+		re     *regexp.Regexp
+	}{
+		{IntentOrderStatus, orderStatusRule},
+		{IntentReturns, returnsRule},
+		{IntentSizeHelp, sizeHelpRule},
+		{IntentPromotions, promotionsRule},
+	} {
+		if rule.re.MatchString(message) {
+			matched = append(matched, rule.intent)
+		}
+	}
+	switch {
+	case len(matched) == 1:
+		return matched[0]
+	case len(matched) > 1:
+		return ""
+	case smallTalkRule.MatchString(message):
+		return IntentSmallTalk
+	case productSearchRule.MatchString(message):
+		return IntentProductSearch
+	}
+	return ""
+}
+
+const intentClassificationPrompt = `Classify the intent of a shopper's message to a fashion store assistant.
+product_search: finding, comparing or asking about products, styles or availability.
+order_status: where an existing order is, its status or delivery.
+size_help: which size to choose, fit or measurements.
+returns: returning, exchanging or getting a refund for an item.
+promotions: discounts, codes, sales or offers.
+small_talk: greetings, thanks or chit-chat with no shopping request.
+escalate: the shopper explicitly asks to talk to a person. Questions about customer service, such as opening hours, are not escalations.`
+
+// intentSchema is the structured output of the classification call
+var intentSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"intent": {Type: genai.TypeString, Format: "enum", Enum: classifiedIntents},
+	},
+	Required: []string{"intent"},
+}
+
+// classifyIntent returns the intent of a redacted shopper message, trying
+// the rules before a classification call. The result carries the tokens the
+// call cost; any failure falls back to IntentGeneral.
+func (c *ChatController) classifyIntent(ctx context.Context, message string) (string, LLMResult) {
+	if intent := classifyByRules(message); intent != "" {
+		return intent, LLMResult{}
+	}
+
+	result, err := c.llm.GenerateJSON(ctx, LLMJSONRequest{
+		Call:    "intent_classification",
+		System:  intentClassificationPrompt,
+		Schema:  intentSchema,
+		Message: message,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "error classifying intent", slog.Any("error", err))
+		return IntentGeneral, result
+	}
+
+	var classified struct {
+		Intent string `json:"intent"`
+	}
+	json.Unmarshal([]byte(result.Text), &classified)
+	if _, ok := intentRoutes[classified.Intent]; !ok {
+		return IntentGeneral, result
+	}
+	return classified.Intent, result
+}
+
+// routeFor returns the route of an intent, falling back to IntentGeneral
+func routeFor(intent string) intentRoute {
+	if route, ok := intentRoutes[intent]; ok {
+		return route
+	}
+	return intentRoutes[IntentGeneral]
+}
+
+// allows reports whether the route lets the model call tool
+func (r intentRoute) allows(tool string) bool {
+	if r.AllTools {
+		return true
+	}
+	for _, name := range r.Tools {
+		if name == tool {
+			return true
+		}
+	}
+	return false
+}
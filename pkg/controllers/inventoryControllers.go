@@ -0,0 +1,391 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+// inventoryItemTTL keeps the inventory item to product mapping long enough
+// for webhooks to find products cached since
+const inventoryItemTTL = 24 * time.Hour
+
+// errInventoryProductNotFound is returned for an unknown product
+var errInventoryProductNotFound = errors.New("product not found")
+
+// InventoryController reads stock per variant and location from the Admin
+// API and keeps cached stock current from inventory webhooks
+type InventoryController struct {
+	cfg     *config.Config
+	shopify ShopifyClient
+	cache   Cache
+}
+
+// NewInventoryController returns an InventoryController reading from
+// shopify and caching stock in cache
+func NewInventoryController(cfg *config.Config, shopify ShopifyClient, cache Cache) *InventoryController {
+	return &InventoryController{cfg: cfg, shopify: shopify, cache: cache}
+}
+
+func inventoryProductKey(productID string) string {
+	return "inventory:product:" + models.NumericIDFromGID(productID)
+}
+
+func inventoryItemKey(itemID string) string {
+	return "inventory:item:" + models.NumericIDFromGID(itemID)
+}
+
+// productInventory returns the stock of every variant of a product
+func (c *InventoryController) productInventory(ctx context.Context, productID string) (models.ProductInventory, error) {
+	var inventory models.ProductInventory
+	if getCachedJSON(ctx, c.cache, inventoryProductKey(productID), &inventory) {
+		return inventory, nil
+	}
+
+	query := `query ProductInventory($id: ID!) {
+	product(id: $id) {
+		id
+		handle
+		title
+		variants(first: 100) {
+			nodes {
+				id
+				title
+				sku
+				selectedOptions { name value }
+				inventoryItem {
+					id
+					tracked
+					inventoryLevels(first: 20) {
+						nodes {
+							location { id name address { city provinceCode countryCode } }
+							quantities(names: ["available"]) { name quantity }
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+	var data struct {
+		Product *struct {
+			ID       string `json:"id"`
+			Handle   string `json:"handle"`
+			Title    string `json:"title"`
+			Variants struct {
+				Nodes []struct {
+					ID              string                  `json:"id"`
+					Title           string                  `json:"title"`
+					SKU             string                  `json:"sku"`
+					SelectedOptions []models.SelectedOption `json:"selectedOptions"`
+					InventoryItem   struct {
+						ID              string `json:"id"`
+						Tracked         bool   `json:"tracked"`
+						InventoryLevels struct {
+							Nodes []struct {
+								Location struct {
+									ID      string `json:"id"`
+									Name    string `json:"name"`
+									Address struct {
+										City         string `json:"city"`
+										ProvinceCode string `json:"provinceCode"`
+										CountryCode  string `json:"countryCode"`
+									} `json:"address"`
+								} `json:"location"`
+								Quantities []struct {
+									Name     string `json:"name"`
+									Quantity int    `json:"quantity"`
+								} `json:"quantities"`
+							} `json:"nodes"`
+						} `json:"inventoryLevels"`
+					} `json:"inventoryItem"`
+				} `json:"nodes"`
+			} `json:"variants"`
+		} `json:"product"`
+	}
+	if err := executeAdminQuery(ctx, c.shopify, query, map[string]interface{}{"id": models.GIDFromID("Product", productID)}, &data); err != nil {
+		return models.ProductInventory{}, err
+	}
+	if data.Product == nil {
+		return models.ProductInventory{}, errInventoryProductNotFound
+	}
+
+	p := data.Product
+	inventory = models.ProductInventory{ProductID: p.ID, Handle: p.Handle, Title: p.Title, Variants: []models.VariantInventory{}, UpdatedAt: time.Now().UTC()}
+	for _, v := range p.Variants.Nodes {
+		variant := models.VariantInventory{
+			VariantID:       v.ID,
+			InventoryItemID: v.InventoryItem.ID,
+			Title:           v.Title,
+			SKU:             v.SKU,
+			SelectedOptions: v.SelectedOptions,
+			Tracked:         v.InventoryItem.Tracked,
+			Locations:       []models.LocationInventory{},
+		}
+		for _, level := range v.InventoryItem.InventoryLevels.Nodes {
+			location := models.LocationInventory{
+				LocationID: level.Location.ID,
+				Name:       level.Location.Name,
+				City:       level.Location.Address.City,
+				Province:   level.Location.Address.ProvinceCode,
+				Country:    level.Location.Address.CountryCode,
+			}
+			for _, quantity := range level.Quantities {
+				if quantity.Name == "available" {
+					location.Available = quantity.Quantity
+				}
+			}
+			variant.Locations = append(variant.Locations, location)
+		}
+		variant.Summarize(c.cfg.Inventory.LowStockThreshold)
+		inventory.Variants = append(inventory.Variants, variant)
+
+		if variant.InventoryItemID != "" {
+			setCachedJSON(ctx, c.cache, inventoryItemKey(variant.InventoryItemID), p.ID, inventoryItemTTL)
+		}
+	}
+
+	setCachedJSON(ctx, c.cache, inventoryProductKey(p.ID), inventory, c.cfg.Inventory.CacheTTL)
+	return inventory, nil
+}
+
+// publishedProductID resolves a product handle or GID through the
+// Storefront API, which only sees products published to the storefront
+func (c *InventoryController) publishedProductID(ctx context.Context, ref string) (string, error) {
+	query := `query InventoryProduct($id: ID, $handle: String) {
+	product(id: $id, handle: $handle) { id }
+}`
+	variables := map[string]interface{}{}
+	if strings.HasPrefix(ref, "gid://") {
+		variables["id"] = ref
+	} else {
+		variables["handle"] = ref
+	}
+
+	var data struct {
+		Product *struct {
+			ID string `json:"id"`
+		} `json:"product"`
+	}
+	if err := executeStorefrontQuery(ctx, c.shopify, query, variables, &data); err != nil {
+		return "", err
+	}
+	if data.Product == nil {
+		return "", errInventoryProductNotFound
+	}
+	return data.Product.ID, nil
+}
+
+// InventoryLevelUpdate is the payload of the inventory_levels/update webhook
+type InventoryLevelUpdate struct {
+	InventoryItemID int64 `json:"inventory_item_id"`
+	LocationID      int64 `json:"location_id"`
+	Available       *int  `json:"available"`
+}
+
+// invalidateLevel drops the cached stock of the item's product so the next
+// read fetches it from Shopify. Patching the cached entry instead would race
+// with concurrent reads and apply out-of-order webhooks.
+func (c *InventoryController) invalidateLevel(ctx context.Context, update InventoryLevelUpdate) {
+	itemID := strconv.FormatInt(update.InventoryItemID, 10)
+
+	var productID string
+	if !getCachedJSON(ctx, c.cache, inventoryItemKey(itemID), &productID) {
+		return
+	}
+	deleteCached(ctx, c.cache, inventoryProductKey(productID))
+	slog.DebugContext(ctx, "inventory cache invalidated", slog.String("product", productID), slog.String("inventory_item", itemID))
+}
+
+// shopperInventory is a product's stock as shoppers see it: statuses and
+// low-stock signals, without quantities, SKUs or inventory item IDs
+type shopperInventory struct {
+	ProductID string                    `json:"productId"`
+	Handle    string                    `json:"handle"`
+	Title     string                    `json:"title"`
+	Variants  []shopperVariantInventory `json:"variants"`
+	UpdatedAt time.Time                 `json:"updatedAt"`
+}
+
+type shopperVariantInventory struct {
+	VariantID       string                     `json:"variantId"`
+	Title           string                     `json:"title"`
+	SelectedOptions []models.SelectedOption    `json:"selectedOptions"`
+	Status          string                     `json:"status"`
+	Signal          string                     `json:"signal,omitempty"`
+	Locations       []shopperLocationInventory `json:"locations"`
+}
+
+type shopperLocationInventory struct {
+	Name    string `json:"name"`
+	City    string `json:"city,omitempty"`
+	Country string `json:"country,omitempty"`
+	Status  string `json:"status"`
+}
+
+// shopperView strips what only admins may see from inventory
+func shopperView(inventory models.ProductInventory) shopperInventory {
+	view := shopperInventory{ProductID: inventory.ProductID, Handle: inventory.Handle, Title: inventory.Title, Variants: []shopperVariantInventory{}, UpdatedAt: inventory.UpdatedAt}
+	for _, v := range inventory.Variants {
+		variant := shopperVariantInventory{VariantID: v.VariantID, Title: v.Title, SelectedOptions: v.SelectedOptions, Status: v.Status, Signal: v.Signal, Locations: []shopperLocationInventory{}}
+		for _, location := range v.Locations {
+			variant.Locations = append(variant.Locations, shopperLocationInventory{Name: location.Name, City: location.City, Country: location.Country, Status: location.Status})
+		}
+		view.Variants = append(view.Variants, variant)
+	}
+	return view
+}
+
+// callerIsAdmin reports whether the request carries an admin credential
+func callerIsAdmin(r *http.Request) bool {
+	principal, ok := middleware.PrincipalFromContext(r.Context())
+	return ok && models.RoleSatisfies(principal.Role, models.RoleAdmin)
+}
+
+// writeInventory serves the stock of a product handle or GID. Admins get
+// quantities per location for any product; everyone else only sees the
+// shopper view of published products.
+func (c *InventoryController) writeInventory(w http.ResponseWriter, r *http.Request, ref string) {
+	admin := callerIsAdmin(r)
+	productID := ref
+	var err error
+	if !admin || !strings.HasPrefix(ref, "gid://") {
+		productID, err = c.publishedProductID(r.Context(), ref)
+	}
+	var inventory models.ProductInventory
+	if err == nil {
+		inventory, err = c.productInventory(r.Context(), productID)
+	}
+	if errors.Is(err, errInventoryProductNotFound) {
+		utils.WriteError(w, http.StatusNotFound, "not_found", "Product not found")
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	if admin {
+		utils.WriteJSON(w, http.StatusOK, inventory)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, shopperView(inventory))
+}
+
+// GetInventoryById returns the stock status of every variant of a product,
+// per location, with low-stock signals
+func (c *InventoryController) GetInventoryById(w http.ResponseWriter, r *http.Request) {
+	productID := mux.Vars(r)["id"]
+	if _, err := strconv.ParseUint(productID, 10, 64); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Product ID must be numeric")
+		return
+	}
+	c.writeInventory(w, r, models.GIDFromID("Product", productID))
+}
+
+// GetInventoryByName returns the stock status of a product found by handle
+func (c *InventoryController) GetInventoryByName(w http.ResponseWriter, r *http.Request) {
+	c.writeInventory(w, r, mux.Vars(r)["name"])
+}
+
+// locationNear reports whether a location matches the place the shopper
+// named, by location name, city, province or country code
+func locationNear(location models.LocationInventory, near string) bool {
+	near = strings.ToLower(strings.TrimSpace(near))
+	for _, field := range []string{location.Name, location.City} {
+		if field != "" && strings.Contains(strings.ToLower(field), near) {
+			return true
+		}
+	}
+	return strings.EqualFold(location.Province, near) || strings.EqualFold(location.Country, near)
+}
+
+// variantMatches reports whether every option value the shopper asked for,
+// such as "M" or "black", is one of the variant's
+func variantMatches(variant models.VariantInventory, options []string) bool {
+	for _, wanted := range options {
+		found := false
+		for _, selected := range variant.SelectedOptions {
+			if strings.EqualFold(selected.Value, wanted) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// runCheckStockTool answers whether a product is in stock, optionally in
+// given option values and near a place. Without a place, locations in the
+// shopper's country are preferred. Exact quantities are only revealed
+// through the low-stock signal.
+func (c *ChatController) runCheckStockTool(ctx context.Context, cc chatContext, args map[string]any) (map[string]any, error) {
+	inventoryController := &InventoryController{cfg: c.cfg, shopify: c.shopify, cache: c.cache}
+	handle, _ := args["productHandle"].(string)
+	near, _ := args["near"].(string)
+	var options []string
+	if raw, _ := args["options"].([]any); raw != nil {
+		for _, option := range raw {
+			if option, ok := option.(string); ok && option != "" {
+				options = append(options, option)
+			}
+		}
+	}
+
+	productID, err := inventoryController.publishedProductID(ctx, handle)
+	if errors.Is(err, errInventoryProductNotFound) {
+		return map[string]any{"found": false, "message": "No product with that handle; search the catalog first."}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	inventory, err := inventoryController.productInventory(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	variants := []map[string]any{}
+	for _, variant := range inventory.Variants {
+		if !variantMatches(variant, options) {
+			continue
+		}
+
+		locations := []models.LocationInventory{}
+		for _, location := range variant.Locations {
+			switch {
+			case near != "" && !locationNear(location, near):
+			case near == "" && cc.Locale.Country != "" && !strings.EqualFold(location.Country, cc.Locale.Country):
+			default:
+				locations = append(locations, location)
+			}
+		}
+		if near == "" && len(locations) == 0 {
+			// Nothing in the shopper's country, so show every location
+			locations = variant.Locations
+		}
+
+		listed := []map[string]any{}
+		for _, location := range locations {
+			listed = append(listed, map[string]any{"name": location.Name, "city": location.City, "status": location.Status})
+		}
+		result := map[string]any{"title": variant.Title, "status": variant.Status, "locations": listed}
+		if variant.Signal != "" {
+			result["signal"] = variant.Signal
+		}
+		variants = append(variants, result)
+	}
+	return map[string]any{"found": true, "product": inventory.Title, "variants": variants}, nil
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"strategy-fox-go-bd/pkg/models"
+)
+
+const preferenceExtractionPrompt = `Extract the shopper's lasting fashion preferences from their message.
+Only include preferences the shopper states about themselves: clothing sizes per category (e.g. tops, dresses, shoes), favourite colors, favourite styles (e.g. floral, minimalist, streetwear) and budget.
+Ignore one-off requests that are not preferences. Return empty fields when nothing applies.`
+
+// preferenceCuePattern matches messages that may state a size, color,
+// style or budget preference
+var preferenceCuePattern = regexp.MustCompile(`(?i)\b(?:sizes?|sized|fits?|wear|wearing|prefer|like|love|hate|favou?rites?|colou?rs?|styles?|budget|spend|under|petite|tall|plus)\b|[$€£]`)
+
+// mentionsPreference reports whether message is worth a preference
+// extraction call
+func mentionsPreference(message string) bool {
+	return preferenceCuePattern.MatchString(message)
+}
+
+// preferenceSchema is the structured output the extraction call must follow
+var preferenceSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"sizes": {
+			Type: genai.TypeArray,
+			Items: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"category": {Type: genai.TypeString},
+					"size":     {Type: genai.TypeString},
+				},
+				Required: []string{"category", "size"},
+			},
+		},
+		"favouriteColors": {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+		"favouriteStyles": {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+		"budget": {
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"min":      {Type: genai.TypeNumber},
+				"max":      {Type: genai.TypeNumber},
+				"currency": {Type: genai.TypeString},
+			},
+			Nullable: true,
+		},
+	},
+}
+
+// extractedPreferences mirrors preferenceSchema
+type extractedPreferences struct {
+	Sizes []struct {
+		Category string `json:"category"`
+		Size     string `json:"size"`
+	} `json:"sizes"`
+	FavouriteColors []string       `json:"favouriteColors"`
+	FavouriteStyles []string       `json:"favouriteStyles"`
+	Budget          *models.Budget `json:"budget"`
+}
+
+// extractPreferences asks the LLM for the preferences stated in a redacted
+// shopper message, returning the update and the tokens it cost
+func (c *ChatController) extractPreferences(ctx context.Context, message string) (models.PreferenceUpdate, int64, error) {
+	result, err := c.llm.GenerateJSON(ctx, LLMJSONRequest{
+		Call:    "preference_extraction",
+		System:  preferenceExtractionPrompt,
+		Schema:  preferenceSchema,
+		Message: message,
+	})
+	tokens := result.TotalTokens()
+	if err != nil {
+		return models.PreferenceUpdate{}, tokens, fmt.Errorf("error extracting preferences: %v", err)
+	}
+	if result.Text == "" {
+		return models.PreferenceUpdate{}, tokens, nil
+	}
+
+	var extracted extractedPreferences
+	if err := json.Unmarshal([]byte(result.Text), &extracted); err != nil {
+		return models.PreferenceUpdate{}, tokens, fmt.Errorf("error decoding preferences: %v", err)
+	}
+
+	update := models.PreferenceUpdate{
+		Sizes:           map[string]string{},
+		FavouriteColors: extracted.FavouriteColors,
+		FavouriteStyles: extracted.FavouriteStyles,
+	}
+	for _, s := range extracted.Sizes {
+		update.Sizes[s.Category] = s.Size
+	}
+	if extracted.Budget != nil && (extracted.Budget.Min > 0 || extracted.Budget.Max > 0) {
+		update.Budget = extracted.Budget
+	}
+	return update, tokens, nil
+}
+
+// learnPreferences extracts preferences from a message and merges them into
+// the shopper's profile. It runs after the reply has been sent.
+func (c *ChatController) learnPreferences(ctx context.Context, tenant, profileID, message string) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	update, tokens, err := c.extractPreferences(ctx, message)
+	if recordErr := c.store.RecordTokenUsage(ctx, tenant, tokens); recordErr != nil {
+		slog.ErrorContext(ctx, "error recording token usage", slog.Any("error", recordErr))
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "error learning preferences", slog.String("profile", profileID), slog.Any("error", err))
+		return
+	}
+	if update.IsEmpty() {
+		return
+	}
+
+	profile, err := c.store.GetProfile(ctx, profileID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading profile", slog.String("profile", profileID), slog.Any("error", err))
+		return
+	}
+	profile.Merge(update)
+	if err := c.store.SaveProfile(ctx, profile); err != nil {
+		slog.ErrorContext(ctx, "error saving profile", slog.String("profile", profileID), slog.Any("error", err))
+	}
+}
@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+const (
+	defaultProductPageSize = 34
+	maxProductPageSize     = 100
+)
+
+// productFieldsFragment selects every field the public Product schema needs
+// from the Storefront API
+const productFieldsFragment = `
+fragment ProductFields on Product {
+	id
+	handle
+	title
+	descriptionHtml
+	vendor
+	productType
+	tags
+	createdAt
+	updatedAt
+	priceRange {
+		minVariantPrice { amount currencyCode }
+		maxVariantPrice { amount currencyCode }
+	}
+	options {
+		name
+		values
+	}
+	media(first: 10) {
+		edges {
+			node {
+				mediaContentType
+				alt
+				... on Model3d {
+					sources {
+						url
+						format
+						mimeType
+					}
+				}
+				... on MediaImage {
+					image {
+						url
+					}
+				}
+			}
+		}
+	}
+	variants(first: 10) {
+		edges {
+			node {
+				id
+				title
+				sku
+				price { amount currencyCode }
+				compareAtPrice { amount currencyCode }
+				availableForSale
+				selectedOptions {
+					name
+					value
+				}
+			}
+		}
+	}
+}
+`
+
+// GetProductsV3 returns a page of products in the public Product schema,
+// priced and translated for the requested locale
+func (c *ShopifyController) GetProductsV3(w http.ResponseWriter, r *http.Request) {
+	first := defaultProductPageSize
+	if raw := r.URL.Query().Get("first"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxProductPageSize {
+			utils.WriteError(w, http.StatusBadRequest, "invalid_request",
+				fmt.Sprintf("first must be an integer between 1 and %d", maxProductPageSize))
+			return
+		}
+		first = n
+	}
+	after := r.URL.Query().Get("after")
+	locale := utils.ParseLocale(r)
+	w.Header().Set("Vary", "Accept-Language")
+
+	redisKey := fmt.Sprintf("products:v3:list:%d:%s:%s", first, after, locale.CacheKey())
+
+	var list models.ProductList
+	if getCachedJSON(r.Context(), c.cache, redisKey, &list) {
+		utils.WriteJSON(w, http.StatusOK, list)
+		return
+	}
+
+	variables := map[string]interface{}{"first": first}
+	if after != "" {
+		variables["after"] = after
+	}
+	query := withInContext("query Products($first: Int!, $after: String)", locale, variables) + ` {
+	products(first: $first, after: $after) {
+		edges { node { ...ProductFields } }
+		pageInfo { hasNextPage endCursor }
+	}
+}
+` + productFieldsFragment
+
+	var data struct {
+		Products models.ShopifyProductConnection `json:"products"`
+	}
+	if err := executeStorefrontQuery(r.Context(), c.shopify, query, variables, &data); err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	list = data.Products.ToProductList()
+	setCachedJSON(r.Context(), c.cache, redisKey, list, productCacheTTL)
+
+	utils.WriteJSON(w, http.StatusOK, list)
+}
+
+// GetProductByIdV3 returns a single product by its numeric ID
+func (c *ShopifyController) GetProductByIdV3(w http.ResponseWriter, r *http.Request) {
+	productID := mux.Vars(r)["id"]
+	if _, err := strconv.ParseUint(productID, 10, 64); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Product ID must be numeric")
+		return
+	}
+
+	locale := utils.ParseLocale(r)
+	variables := map[string]interface{}{"id": "gid://shopify/Product/" + productID}
+	query := withInContext("query ProductById($id: ID!)", locale, variables) + ` {
+	product(id: $id) { ...ProductFields }
+}
+` + productFieldsFragment
+
+	redisKey := fmt.Sprintf("products:v3:id:%s:%s", productID, locale.CacheKey())
+	c.writeSingleProduct(w, r, redisKey, query, variables)
+}
+
+// GetProductByNameV3 returns a single product by its handle
+func (c *ShopifyController) GetProductByNameV3(w http.ResponseWriter, r *http.Request) {
+	productHandle := mux.Vars(r)["name"]
+
+	locale := utils.ParseLocale(r)
+	variables := map[string]interface{}{"handle": productHandle}
+	query := withInContext("query ProductByHandle($handle: String!)", locale, variables) + ` {
+	product(handle: $handle) { ...ProductFields }
+}
+` + productFieldsFragment
+
+	redisKey := fmt.Sprintf("products:v3:handle:%s:%s", productHandle, locale.CacheKey())
+	c.writeSingleProduct(w, r, redisKey, query, variables)
+}
+
+// writeSingleProduct serves a single-product query from cache or Storefront
+// and writes the mapped product, or a not_found error when Shopify returns null
+func (c *ShopifyController) writeSingleProduct(w http.ResponseWriter, r *http.Request, redisKey, query string, variables map[string]interface{}) {
+	w.Header().Set("Vary", "Accept-Language")
+
+	var product models.Product
+	if getCachedJSON(r.Context(), c.cache, redisKey, &product) {
+		utils.WriteJSON(w, http.StatusOK, product)
+		return
+	}
+
+	var data struct {
+		Product *models.ShopifyProduct `json:"product"`
+	}
+	if err := executeStorefrontQuery(r.Context(), c.shopify, query, variables, &data); err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	if data.Product == nil {
+		utils.WriteError(w, http.StatusNotFound, "not_found", "Product not found")
+		return
+	}
+
+	product = data.Product.ToProduct()
+	setCachedJSON(r.Context(), c.cache, redisKey, product, productCacheTTL)
+
+	utils.WriteJSON(w, http.StatusOK, product)
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+// ProfileProductEvent records a product the shopper viewed or purchased
+type ProfileProductEvent struct {
+	Event     string `json:"event"`
+	ProductID string `json:"productId"`
+}
+
+// requestProfileID resolves the profile of the caller: the verified
+// customer of the X-Session-ID session when signed in, otherwise the visitor
+// of the X-Visitor-ID header or the visitorId query parameter. It writes an
+// error when neither is present.
+func (c *ChatController) requestProfileID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	visitorID := r.Header.Get("X-Visitor-ID")
+	if visitorID == "" {
+		visitorID = r.URL.Query().Get("visitorId")
+	}
+
+	customerID := ""
+	if sessionID := sessionHeader(r); sessionID != "" {
+		identity, err := c.store.GetSessionIdentity(r.Context(), sessionID)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+			return "", false
+		}
+		if identity != nil {
+			customerID = identity.CustomerID
+		}
+	}
+
+	profileID := models.ProfileID(customerID, visitorID)
+	if profileID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "X-Visitor-ID header is required")
+		return "", false
+	}
+	return profileID, true
+}
+
+// GetProfile returns the caller's stored preferences
+func (c *ChatController) GetProfile(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := c.requestProfileID(w, r)
+	if !ok {
+		return
+	}
+
+	profile, err := c.store.GetProfile(r.Context(), profileID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, profile)
+}
+
+// UpdateProfile replaces the caller's editable preferences
+func (c *ChatController) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := c.requestProfileID(w, r)
+	if !ok {
+		return
+	}
+
+	var update models.PreferenceUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON request body")
+		return
+	}
+
+	profile, err := c.store.GetProfile(r.Context(), profileID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	profile.Replace(update)
+	if err := c.store.SaveProfile(r.Context(), profile); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, profile)
+}
+
+// DeleteProfile forgets everything remembered about the caller
+func (c *ChatController) DeleteProfile(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := c.requestProfileID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.store.DeleteProfile(r.Context(), profileID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RecordProfileProduct adds a viewed or purchased product to the caller's history
+func (c *ChatController) RecordProfileProduct(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := c.requestProfileID(w, r)
+	if !ok {
+		return
+	}
+
+	var event ProfileProductEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil || event.ProductID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "event and productId are required")
+		return
+	}
+
+	profile, err := c.store.GetProfile(r.Context(), profileID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	if err := profile.RecordProduct(event.Event, event.ProductID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if err := c.store.SaveProfile(r.Context(), profile); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, profile)
+}
@@ -0,0 +1,532 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+// promotionsCacheKey holds the active discounts of the shop; overrides are
+// applied after the cache so admin changes take effect immediately
+const promotionsCacheKey = "promotions:active"
+
+// errPromotionTargetNotFound is returned for an unknown product or
+// collection
+var errPromotionTargetNotFound = errors.New("product or collection not found")
+
+// Promotion is an active discount. Type is automatic or code; only code
+// promotions carry Codes. A promotion applies to every product when
+// AllItems is set, otherwise to the listed products and collections.
+// AllCustomers is false for codes limited to customer segments or named
+// customers, which are never shown to shoppers.
+type Promotion struct {
+	ID            string     `json:"id"`
+	Type          string     `json:"type"`
+	Title         string     `json:"title"`
+	Summary       string     `json:"summary,omitempty"`
+	Codes         []string   `json:"codes,omitempty"`
+	Value         string     `json:"value,omitempty"`
+	StartsAt      time.Time  `json:"startsAt"`
+	EndsAt        *time.Time `json:"endsAt,omitempty"`
+	AllItems      bool       `json:"allItems"`
+	AllCustomers  bool       `json:"allCustomers"`
+	ProductIDs    []string   `json:"productIds,omitempty"`
+	CollectionIDs []string   `json:"collectionIds,omitempty"`
+}
+
+// appliesTo reports whether the promotion covers a product in
+// collectionIDs, or a collection when productID is empty
+func (p Promotion) appliesTo(productID string, collectionIDs []string) bool {
+	if p.AllItems {
+		return true
+	}
+	for _, id := range p.ProductIDs {
+		if productID != "" && id == productID {
+			return true
+		}
+	}
+	for _, id := range p.CollectionIDs {
+		for _, collectionID := range collectionIDs {
+			if id == collectionID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PromotionsController reads the shop's active discounts for shoppers, the
+// chatbot and admins
+type PromotionsController struct {
+	cfg     *config.Config
+	shopify ShopifyClient
+	cache   Cache
+	store   *models.Store
+}
+
+// NewPromotionsController returns a PromotionsController reading from
+// shopify, caching discounts in cache and keeping overrides in store
+func NewPromotionsController(cfg *config.Config, shopify ShopifyClient, cache Cache, store *models.Store) *PromotionsController {
+	return &PromotionsController{cfg: cfg, shopify: shopify, cache: cache, store: store}
+}
+
+// discountFieldsFragment selects what every discount type shares. Fields
+// missing from a type, such as codes on automatic discounts, decode empty.
+const discountFieldsFragment = `
+fragment DiscountItems on DiscountItems {
+	... on AllDiscountItems { allItems }
+	... on DiscountProducts {
+		products(first: 50) { nodes { id } }
+		productVariants(first: 50) { nodes { product { id } } }
+	}
+	... on DiscountCollections { collections(first: 50) { nodes { id } } }
+}
+
+fragment CustomerGets on DiscountCustomerGets {
+	value {
+		... on DiscountPercentage { percentage }
+		... on DiscountAmount { amount { amount currencyCode } }
+		... on DiscountOnQuantity { effect { ... on DiscountPercentage { percentage } } }
+	}
+	items { ...DiscountItems }
+}
+`
+
+// discountFields is one discount of any type
+type discountFields struct {
+	Typename string     `json:"__typename"`
+	Title    string     `json:"title"`
+	Summary  string     `json:"summary"`
+	StartsAt time.Time  `json:"startsAt"`
+	EndsAt   *time.Time `json:"endsAt"`
+	Codes    struct {
+		Nodes []struct {
+			Code string `json:"code"`
+		} `json:"nodes"`
+	} `json:"codes"`
+	CustomerSelection *struct {
+		Typename string `json:"__typename"`
+	} `json:"customerSelection"`
+	CustomerGets *struct {
+		Value struct {
+			Percentage *float64 `json:"percentage"`
+			Amount     *struct {
+				Amount       string `json:"amount"`
+				CurrencyCode string `json:"currencyCode"`
+			} `json:"amount"`
+			Effect *struct {
+				Percentage *float64 `json:"percentage"`
+			} `json:"effect"`
+		} `json:"value"`
+		Items discountItems `json:"items"`
+	} `json:"customerGets"`
+	CustomerBuys *struct {
+		Items discountItems `json:"items"`
+	} `json:"customerBuys"`
+}
+
+type discountItems struct {
+	AllItems bool `json:"allItems"`
+	Products struct {
+		Nodes []struct {
+			ID string `json:"id"`
+		} `json:"nodes"`
+	} `json:"products"`
+	ProductVariants struct {
+		Nodes []struct {
+			Product struct {
+				ID string `json:"id"`
+			} `json:"product"`
+		} `json:"nodes"`
+	} `json:"productVariants"`
+	Collections struct {
+		Nodes []struct {
+			ID string `json:"id"`
+		} `json:"nodes"`
+	} `json:"collections"`
+}
+
+// toPromotion converts a discount node. Buy X get Y discounts apply to what
+// the shopper buys; free shipping applies to everything. Automatic
+// discounts carry no customer selection and apply to all customers.
+func (d discountFields) toPromotion(id, kind string) Promotion {
+	promotion := Promotion{
+		ID:           id,
+		Type:         kind,
+		Title:        d.Title,
+		Summary:      d.Summary,
+		StartsAt:     d.StartsAt,
+		EndsAt:       d.EndsAt,
+		AllCustomers: d.CustomerSelection == nil || d.CustomerSelection.Typename == "DiscountCustomerAll",
+	}
+	for _, node := range d.Codes.Nodes {
+		promotion.Codes = append(promotion.Codes, node.Code)
+	}
+
+	var items *discountItems
+	switch {
+	case d.CustomerBuys != nil:
+		items = &d.CustomerBuys.Items
+	case d.CustomerGets != nil:
+		items = &d.CustomerGets.Items
+	default:
+		promotion.AllItems = true
+	}
+	if items != nil {
+		promotion.AllItems = items.AllItems
+		for _, node := range items.Products.Nodes {
+			promotion.ProductIDs = append(promotion.ProductIDs, node.ID)
+		}
+		for _, node := range items.ProductVariants.Nodes {
+			promotion.ProductIDs = append(promotion.ProductIDs, node.Product.ID)
+		}
+		for _, node := range items.Collections.Nodes {
+			promotion.CollectionIDs = append(promotion.CollectionIDs, node.ID)
+		}
+	}
+
+	if gets := d.CustomerGets; gets != nil {
+		switch value := gets.Value; {
+		case value.Percentage != nil:
+			promotion.Value = strconv.FormatFloat(*value.Percentage*100, 'f', -1, 64) + "%"
+		case value.Effect != nil && value.Effect.Percentage != nil:
+			promotion.Value = strconv.FormatFloat(*value.Effect.Percentage*100, 'f', -1, 64) + "%"
+		case value.Amount != nil:
+			promotion.Value = value.Amount.Amount + " " + value.Amount.CurrencyCode
+		}
+	}
+	return promotion
+}
+
+// activePromotions returns the shop's active automatic and code discounts.
+// Price rules are read as code discounts, which is how the Admin GraphQL
+// API exposes them.
+func (c *PromotionsController) activePromotions(ctx context.Context) ([]Promotion, error) {
+	var cached []Promotion
+	if getCachedJSON(ctx, c.cache, promotionsCacheKey, &cached) {
+		return cached, nil
+	}
+
+	query := `query ActivePromotions {
+	automaticDiscountNodes(first: 50, query: "status:active") {
+		nodes {
+			id
+			automaticDiscount {
+				__typename
+				... on DiscountAutomaticBasic { title summary startsAt endsAt customerGets { ...CustomerGets } }
+				... on DiscountAutomaticBxgy { title summary startsAt endsAt customerBuys { items { ...DiscountItems } } customerGets { ...CustomerGets } }
+				... on DiscountAutomaticFreeShipping { title summary startsAt endsAt }
+			}
+		}
+	}
+	codeDiscountNodes(first: 50, query: "status:active") {
+		nodes {
+			id
+			codeDiscount {
+				__typename
+				... on DiscountCodeBasic { title summary startsAt endsAt codes(first: 10) { nodes { code } } customerSelection { __typename } customerGets { ...CustomerGets } }
+				... on DiscountCodeBxgy { title summary startsAt endsAt codes(first: 10) { nodes { code } } customerSelection { __typename } customerBuys { items { ...DiscountItems } } customerGets { ...CustomerGets } }
+				... on DiscountCodeFreeShipping { title summary startsAt endsAt codes(first: 10) { nodes { code } } customerSelection { __typename } }
+			}
+		}
+	}
+}
+` + discountFieldsFragment
+
+	var data struct {
+		AutomaticDiscountNodes struct {
+			Nodes []struct {
+				ID                string          `json:"id"`
+				AutomaticDiscount *discountFields `json:"automaticDiscount"`
+			} `json:"nodes"`
+		} `json:"automaticDiscountNodes"`
+		CodeDiscountNodes struct {
+			Nodes []struct {
+				ID           string          `json:"id"`
+				CodeDiscount *discountFields `json:"codeDiscount"`
+			} `json:"nodes"`
+		} `json:"codeDiscountNodes"`
+	}
+	if err := executeAdminQuery(ctx, c.shopify, query, nil, &data); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	live := func(d *discountFields) bool {
+		// Unsupported discount types come back with only __typename
+		return d != nil && d.Title != "" && !d.StartsAt.After(now) && (d.EndsAt == nil || d.EndsAt.After(now))
+	}
+	promotions := []Promotion{}
+	for _, node := range data.AutomaticDiscountNodes.Nodes {
+		if live(node.AutomaticDiscount) {
+			promotions = append(promotions, node.AutomaticDiscount.toPromotion(node.ID, "automatic"))
+		}
+	}
+	for _, node := range data.CodeDiscountNodes.Nodes {
+		if live(node.CodeDiscount) && len(node.CodeDiscount.Codes.Nodes) > 0 {
+			promotions = append(promotions, node.CodeDiscount.toPromotion(node.ID, "code"))
+		}
+	}
+
+	setCachedJSON(ctx, c.cache, promotionsCacheKey, promotions, c.cfg.Promotions.CacheTTL)
+	return promotions, nil
+}
+
+// mentionable reports whether shoppers may be told about promotion
+func mentionable(promotion Promotion, overrides models.PromotionOverrides) bool {
+	return promotion.AllCustomers && overrides.Mentionable(promotion.ID, promotion.Codes)
+}
+
+// mentionablePromotions returns the active promotions tenant's overrides
+// let shoppers see. tenant must be resolved server-side.
+func (c *PromotionsController) mentionablePromotions(ctx context.Context, tenant string) ([]Promotion, error) {
+	promotions, err := c.activePromotions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := c.store.GetPromotionOverrides(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := []Promotion{}
+	for _, promotion := range promotions {
+		if mentionable(promotion, overrides) {
+			listed = append(listed, promotion)
+		}
+	}
+	return listed, nil
+}
+
+// promotionTarget resolves a product or collection handle or GID to its ID
+// and the collections it belongs to, through the Storefront API
+func (c *PromotionsController) promotionTarget(ctx context.Context, product, collection string) (string, []string, error) {
+	ref, resource := product, "product"
+	if product == "" {
+		ref, resource = collection, "collection"
+	}
+	variables := map[string]interface{}{}
+	if strings.HasPrefix(ref, "gid://") {
+		variables["id"] = ref
+	} else {
+		variables["handle"] = ref
+	}
+
+	var query string
+	if resource == "product" {
+		query = `query PromotionProduct($id: ID, $handle: String) {
+	product(id: $id, handle: $handle) {
+		id
+		collections(first: 50) { nodes { id } }
+	}
+}`
+	} else {
+		query = `query PromotionCollection($id: ID, $handle: String) {
+	collection(id: $id, handle: $handle) { id }
+}`
+	}
+
+	var data struct {
+		Product *struct {
+			ID          string `json:"id"`
+			Collections struct {
+				Nodes []struct {
+					ID string `json:"id"`
+				} `json:"nodes"`
+			} `json:"collections"`
+		} `json:"product"`
+		Collection *struct {
+			ID string `json:"id"`
+		} `json:"collection"`
+	}
+	if err := executeStorefrontQuery(ctx, c.shopify, query, variables, &data); err != nil {
+		return "", nil, err
+	}
+
+	switch {
+	case data.Product != nil:
+		var collections []string
+		for _, node := range data.Product.Collections.Nodes {
+			collections = append(collections, node.ID)
+		}
+		return data.Product.ID, collections, nil
+	case data.Collection != nil:
+		return "", []string{data.Collection.ID}, nil
+	}
+	return "", nil, errPromotionTargetNotFound
+}
+
+// promotionsFor returns tenant's mentionable promotions, narrowed to those
+// applying to product or collection when either is given
+func (c *PromotionsController) promotionsFor(ctx context.Context, tenant, product, collection string) ([]Promotion, error) {
+	promotions, err := c.mentionablePromotions(ctx, tenant)
+	if err != nil || (product == "" && collection == "") {
+		return promotions, err
+	}
+
+	productID, collectionIDs, err := c.promotionTarget(ctx, product, collection)
+	if err != nil {
+		return nil, err
+	}
+	applicable := []Promotion{}
+	for _, promotion := range promotions {
+		if promotion.appliesTo(productID, collectionIDs) {
+			applicable = append(applicable, promotion)
+		}
+	}
+	return applicable, nil
+}
+
+// mentionableCodes returns the codes of tenant's mentionable promotions,
+// logging failures so the guardrails fall back to the configured codes
+func (c *PromotionsController) mentionableCodes(ctx context.Context, tenant string) []string {
+	promotions, err := c.mentionablePromotions(ctx, tenant)
+	if err != nil {
+		slog.WarnContext(ctx, "error loading promotion codes", slog.String("tenant", tenant), slog.Any("error", err))
+		return nil
+	}
+
+	var codes []string
+	for _, promotion := range promotions {
+		codes = append(codes, promotion.Codes...)
+	}
+	return codes
+}
+
+// GetPromotions lists the active promotions shoppers may see, narrowed by
+// ?product= or ?collection= (a handle or GID) to those that apply
+func (c *PromotionsController) GetPromotions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("product") != "" && q.Get("collection") != "" {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Pass either product or collection, not both")
+		return
+	}
+
+	promotions, err := c.promotionsFor(r.Context(), middleware.TenantFromRequest(r), q.Get("product"), q.Get("collection"))
+	if errors.Is(err, errPromotionTargetNotFound) {
+		utils.WriteError(w, http.StatusNotFound, "not_found", "Product or collection not found")
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"promotions": promotions})
+}
+
+// adminPromotion is an active promotion with whether the overrides let the
+// chatbot mention it
+type adminPromotion struct {
+	Promotion
+	Mentionable bool `json:"mentionable"`
+}
+
+// ListAdminPromotions lists every active promotion, flagging the ones
+// hidden from shoppers by the tenant's overrides or a customer selection
+func (c *PromotionsController) ListAdminPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := c.activePromotions(r.Context())
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	overrides, err := c.store.GetPromotionOverrides(r.Context(), middleware.TenantFromRequest(r))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	listed := make([]adminPromotion, 0, len(promotions))
+	for _, promotion := range promotions {
+		listed = append(listed, adminPromotion{Promotion: promotion, Mentionable: mentionable(promotion, overrides)})
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"promotions": listed, "overrides": overrides})
+}
+
+// GetPromotionOverrides returns the caller's tenant promotion overrides
+func (c *PromotionsController) GetPromotionOverrides(w http.ResponseWriter, r *http.Request) {
+	overrides, err := c.store.GetPromotionOverrides(r.Context(), middleware.TenantFromRequest(r))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, overrides)
+}
+
+// UpdatePromotionOverrides replaces the caller's tenant promotion overrides
+func (c *PromotionsController) UpdatePromotionOverrides(w http.ResponseWriter, r *http.Request) {
+	var overrides models.PromotionOverrides
+	if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON request body")
+		return
+	}
+	if overrides.Allowed == nil {
+		overrides.Allowed = []string{}
+	}
+	if overrides.Blocked == nil {
+		overrides.Blocked = []string{}
+	}
+
+	tenant := middleware.TenantFromRequest(r)
+	if err := c.store.SavePromotionOverrides(r.Context(), tenant, overrides); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	saved, err := c.store.GetPromotionOverrides(r.Context(), tenant)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, saved)
+}
+
+func (c *ChatController) promotions() *PromotionsController {
+	return &PromotionsController{cfg: c.cfg, shopify: c.shopify, cache: c.cache, store: c.store}
+}
+
+// runPromotionsTool lists the promotions the shopper may be told about,
+// optionally for one product or collection. Only these codes pass the
+// output guardrail.
+func (c *ChatController) runPromotionsTool(ctx context.Context, cc chatContext, args map[string]any) (map[string]any, error) {
+	product, _ := args["productHandle"].(string)
+	collection, _ := args["collectionHandle"].(string)
+	if product != "" {
+		collection = ""
+	}
+
+	promotions, err := c.promotions().promotionsFor(ctx, cc.Tenant, product, collection)
+	if errors.Is(err, errPromotionTargetNotFound) {
+		return map[string]any{"promotions": []any{}, "message": "No such product or collection."}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	listed := []map[string]any{}
+	for _, p := range promotions {
+		promotion := map[string]any{"title": p.Title, "type": p.Type, "appliesToEverything": p.AllItems}
+		if p.Summary != "" {
+			promotion["summary"] = p.Summary
+		}
+		if p.Value != "" {
+			promotion["value"] = p.Value
+		}
+		if len(p.Codes) > 0 {
+			promotion["codes"] = p.Codes
+		}
+		if p.EndsAt != nil {
+			promotion["endsAt"] = p.EndsAt.Format(time.RFC3339)
+		}
+		listed = append(listed, promotion)
+	}
+	return map[string]any{"promotions": listed}, nil
+}
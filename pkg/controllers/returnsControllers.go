@@ -0,0 +1,905 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+// maxExchangeOptions bounds the variants suggested for one exchange
+const maxExchangeOptions = 5
+
+// returnReasons maps the reasons shoppers and the model send to Shopify's
+// ReturnReason values
+var returnReasons = map[string]string{
+	"size_too_small":   "SIZE_TOO_SMALL",
+	"size_too_large":   "SIZE_TOO_LARGE",
+	"unwanted":         "UNWANTED",
+	"not_as_described": "NOT_AS_DESCRIBED",
+	"wrong_item":       "WRONG_ITEM",
+	"defective":        "DEFECTIVE",
+	"style":            "STYLE",
+	"color":            "COLOR",
+	"other":            "OTHER",
+}
+
+// returnError is a request the returns flow refuses. Code is the error
+// envelope code: invalid_request, not_returnable or return_rejected.
+type returnError struct {
+	Code    string
+	Message string
+}
+
+func (e *returnError) Error() string {
+	return e.Message
+}
+
+// errReturnOrderNotFound hides whether an order exists when it belongs to
+// another customer
+var errReturnOrderNotFound = errors.New("order not found")
+
+// ReturnsController serves self-service returns and exchanges for verified
+// customers, and the per-tenant return policy for admins
+type ReturnsController struct {
+	cfg     *config.Config
+	shopify ShopifyClient
+	store   *models.Store
+}
+
+// NewReturnsController returns a ReturnsController using the given Shopify
+// client, with policies and session identities kept in store
+func NewReturnsController(cfg *config.Config, shopify ShopifyClient, store *models.Store) *ReturnsController {
+	return &ReturnsController{cfg: cfg, shopify: shopify, store: store}
+}
+
+// returnOrder is an order with the fulfilled lines that can be returned
+type returnOrder struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ProcessedAt time.Time `json:"processedAt"`
+	Customer    *struct {
+		ID string `json:"id"`
+	} `json:"customer"`
+	Fulfillments []struct {
+		CreatedAt            time.Time  `json:"createdAt"`
+		DeliveredAt          *time.Time `json:"deliveredAt"`
+		Status               string     `json:"status"`
+		FulfillmentLineItems struct {
+			Edges []struct {
+				Node returnFulfillmentLine `json:"node"`
+			} `json:"edges"`
+		} `json:"fulfillmentLineItems"`
+	} `json:"fulfillments"`
+}
+
+type returnFulfillmentLine struct {
+	ID       string `json:"id"`
+	Quantity int    `json:"quantity"`
+	LineItem struct {
+		ID           string `json:"id"`
+		Title        string `json:"title"`
+		VariantTitle string `json:"variantTitle"`
+		Variant      *struct {
+			ID      string `json:"id"`
+			Product struct {
+				ID   string   `json:"id"`
+				Tags []string `json:"tags"`
+			} `json:"product"`
+		} `json:"variant"`
+	} `json:"lineItem"`
+}
+
+// ReturnLineEligibility is whether one fulfilled order line can be returned.
+// Reason is final_sale, window_expired or returns_disabled when it cannot.
+type ReturnLineEligibility struct {
+	LineItemID            string     `json:"lineItemId"`
+	FulfillmentLineItemID string     `json:"fulfillmentLineItemId"`
+	Title                 string     `json:"title"`
+	VariantTitle          string     `json:"variantTitle,omitempty"`
+	VariantID             string     `json:"variantId,omitempty"`
+	ProductID             string     `json:"productId,omitempty"`
+	Quantity              int        `json:"quantity"`
+	Eligible              bool       `json:"eligible"`
+	Reason                string     `json:"reason,omitempty"`
+	ReturnBy              *time.Time `json:"returnBy,omitempty"`
+}
+
+// matches reports whether id names this line, as a line item or
+// fulfillment line item GID or numeric ID
+func (l ReturnLineEligibility) matches(id string) bool {
+	return id == l.LineItemID || id == l.FulfillmentLineItemID ||
+		id == models.NumericIDFromGID(l.LineItemID) || id == models.NumericIDFromGID(l.FulfillmentLineItemID)
+}
+
+// ReturnEligibility lists the returnable lines of an order
+type ReturnEligibility struct {
+	OrderID          string                  `json:"orderId"`
+	OrderName        string                  `json:"orderName"`
+	WindowDays       int                     `json:"windowDays"`
+	ExchangesEnabled bool                    `json:"exchangesEnabled"`
+	Lines            []ReturnLineEligibility `json:"lines"`
+}
+
+// ExchangeOption is an in-stock variant offered in exchange
+type ExchangeOption struct {
+	VariantID       string            `json:"variantId"`
+	Title           string            `json:"title"`
+	SelectedOptions map[string]string `json:"selectedOptions"`
+	Price           string            `json:"price,omitempty"`
+}
+
+// ReturnItemRequest asks to return a quantity of one order line, optionally
+// in exchange for another variant of the same product
+type ReturnItemRequest struct {
+	LineItemID        string `json:"lineItemId"`
+	Quantity          int    `json:"quantity"`
+	Reason            string `json:"reason"`
+	Note              string `json:"note,omitempty"`
+	ExchangeVariantID string `json:"exchangeVariantId,omitempty"`
+}
+
+// ReturnRequest is the body of a return request from a chat session
+type ReturnRequest struct {
+	OrderID string              `json:"orderId"`
+	Items   []ReturnItemRequest `json:"items"`
+}
+
+// ReturnLabel is a return shipping label with its tracking
+type ReturnLabel struct {
+	URL            string `json:"url,omitempty"`
+	Carrier        string `json:"carrier,omitempty"`
+	TrackingNumber string `json:"trackingNumber,omitempty"`
+	TrackingURL    string `json:"trackingUrl,omitempty"`
+}
+
+// ReturnStatus is a return as the shopper sees it. Reference is the return
+// name to quote to support; labels appear once the merchant approves it.
+type ReturnStatus struct {
+	ReturnID  string        `json:"returnId"`
+	Reference string        `json:"reference"`
+	Status    string        `json:"status"`
+	OrderName string        `json:"orderName,omitempty"`
+	Labels    []ReturnLabel `json:"labels"`
+}
+
+// policy returns tenant's return policy, falling back to the configured one
+func (c *ReturnsController) policy(ctx context.Context, tenant string) models.ReturnPolicy {
+	fallback := models.DefaultReturnPolicy(c.cfg.Returns)
+	policy, err := c.store.GetReturnPolicy(ctx, tenant, fallback)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading return policy", slog.String("tenant", tenant), slog.Any("error", err))
+	}
+	return policy
+}
+
+// loadOrder fetches an order of customerID with its fulfilled lines
+func (c *ReturnsController) loadOrder(ctx context.Context, customerID, orderID string) (*returnOrder, error) {
+	query := `query ReturnOrder($id: ID!) {
+	order(id: $id) {
+		id
+		name
+		processedAt
+		customer { id }
+		fulfillments(first: 10) {
+			createdAt
+			deliveredAt
+			status
+			fulfillmentLineItems(first: 50) {
+				edges {
+					node {
+						id
+						quantity
+						lineItem {
+							id
+							title
+							variantTitle
+							variant { id product { id tags } }
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+	var data struct {
+		Order *returnOrder `json:"order"`
+	}
+	if err := executeAdminQuery(ctx, c.shopify, query, map[string]interface{}{"id": models.GIDFromID("Order", orderID)}, &data); err != nil {
+		return nil, err
+	}
+	if data.Order == nil || data.Order.Customer == nil || data.Order.Customer.ID != customerID {
+		return nil, errReturnOrderNotFound
+	}
+	return data.Order, nil
+}
+
+// checkReturnEligibility applies policy to every successfully fulfilled
+// line of order
+func checkReturnEligibility(order *returnOrder, policy models.ReturnPolicy, now time.Time) ReturnEligibility {
+	eligibility := ReturnEligibility{
+		OrderID:          order.ID,
+		OrderName:        order.Name,
+		WindowDays:       policy.WindowDays,
+		ExchangesEnabled: policy.ExchangesEnabled,
+		Lines:            []ReturnLineEligibility{},
+	}
+
+	for _, fulfillment := range order.Fulfillments {
+		if fulfillment.Status != "SUCCESS" {
+			continue
+		}
+		start := fulfillment.CreatedAt
+		if fulfillment.DeliveredAt != nil {
+			start = *fulfillment.DeliveredAt
+		}
+		returnBy := start.AddDate(0, 0, policy.WindowDays).UTC()
+
+		for _, edge := range fulfillment.FulfillmentLineItems.Edges {
+			node := edge.Node
+			line := ReturnLineEligibility{
+				LineItemID:            node.LineItem.ID,
+				FulfillmentLineItemID: node.ID,
+				Title:                 node.LineItem.Title,
+				VariantTitle:          node.LineItem.VariantTitle,
+				Quantity:              node.Quantity,
+				Eligible:              true,
+			}
+			var tags []string
+			if variant := node.LineItem.Variant; variant != nil {
+				line.VariantID = variant.ID
+				line.ProductID = variant.Product.ID
+				tags = variant.Product.Tags
+			}
+
+			switch {
+			case policy.WindowDays == 0:
+				line.Eligible, line.Reason = false, "returns_disabled"
+			case policy.IsFinalSale(tags):
+				line.Eligible, line.Reason = false, "final_sale"
+			case now.After(returnBy):
+				line.Eligible, line.Reason = false, "window_expired"
+			default:
+				line.ReturnBy = &returnBy
+			}
+			eligibility.Lines = append(eligibility.Lines, line)
+		}
+	}
+	return eligibility
+}
+
+// eligibility checks which lines of a customer's order can be returned
+func (c *ReturnsController) eligibility(ctx context.Context, tenant, customerID, orderID string) (ReturnEligibility, error) {
+	order, err := c.loadOrder(ctx, customerID, orderID)
+	if err != nil {
+		return ReturnEligibility{}, err
+	}
+	return checkReturnEligibility(order, c.policy(ctx, tenant), time.Now()), nil
+}
+
+// findReturnLine returns the line of eligibility named by lineItemID
+func findReturnLine(eligibility ReturnEligibility, lineItemID string) (ReturnLineEligibility, error) {
+	for _, line := range eligibility.Lines {
+		if line.matches(lineItemID) {
+			return line, nil
+		}
+	}
+	return ReturnLineEligibility{}, &returnError{Code: "invalid_request", Message: fmt.Sprintf("order %s has no fulfilled line %s", eligibility.OrderName, lineItemID)}
+}
+
+// exchangeProduct is a product with the variants an exchange can pick from
+type exchangeProduct struct {
+	Options []struct {
+		Name   string   `json:"name"`
+		Values []string `json:"values"`
+	} `json:"options"`
+	Variants struct {
+		Edges []struct {
+			Node struct {
+				ID               string `json:"id"`
+				Title            string `json:"title"`
+				AvailableForSale bool   `json:"availableForSale"`
+				SelectedOptions  []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"selectedOptions"`
+				Price struct {
+					Amount       string `json:"amount"`
+					CurrencyCode string `json:"currencyCode"`
+				} `json:"price"`
+			} `json:"node"`
+		} `json:"edges"`
+	} `json:"variants"`
+}
+
+// loadExchangeProduct reads a product's variants from the Storefront API,
+// priced for locale
+func (c *ReturnsController) loadExchangeProduct(ctx context.Context, productID string, locale models.Locale) (*exchangeProduct, error) {
+	variables := map[string]interface{}{"id": productID}
+	query := withInContext("query ExchangeOptions($id: ID!)", locale, variables) + ` {
+	product(id: $id) {
+		options { name values }
+		variants(first: 100) {
+			edges {
+				node {
+					id
+					title
+					availableForSale
+					selectedOptions { name value }
+					price { amount currencyCode }
+				}
+			}
+		}
+	}
+}`
+
+	var data struct {
+		Product *exchangeProduct `json:"product"`
+	}
+	if err := executeStorefrontQuery(ctx, c.shopify, query, variables, &data); err != nil {
+		return nil, err
+	}
+	if data.Product == nil {
+		return nil, &returnError{Code: "not_returnable", Message: "the product is no longer sold, so it can only be returned"}
+	}
+	return data.Product, nil
+}
+
+// suggestExchanges picks in-stock variants to exchange currentVariantID for.
+// A size that was too small or too large moves one size up or down keeping
+// the other options; any other reason offers the other variants in the same
+// size.
+func suggestExchanges(product *exchangeProduct, currentVariantID, reason string) []ExchangeOption {
+	current := map[string]string{}
+	for _, edge := range product.Variants.Edges {
+		if edge.Node.ID == currentVariantID {
+			for _, so := range edge.Node.SelectedOptions {
+				current[so.Name] = so.Value
+			}
+		}
+	}
+
+	sizeOption := ""
+	var sizes []string
+	for _, option := range product.Options {
+		if strings.Contains(strings.ToLower(option.Name), "size") {
+			sizeOption, sizes = option.Name, option.Values
+		}
+	}
+
+	// wanted lists the acceptable sizes in order of preference
+	var wanted []string
+	if sizeOption != "" {
+		index := -1
+		for i, size := range sizes {
+			if size == current[sizeOption] {
+				index = i
+			}
+		}
+		switch {
+		case index < 0:
+		case reason == "SIZE_TOO_SMALL":
+			wanted = sizes[index+1:]
+		case reason == "SIZE_TOO_LARGE":
+			for i := index - 1; i >= 0; i-- {
+				wanted = append(wanted, sizes[i])
+			}
+		default:
+			wanted = []string{current[sizeOption]}
+		}
+	}
+	sizeChange := reason == "SIZE_TOO_SMALL" || reason == "SIZE_TOO_LARGE"
+
+	options := []ExchangeOption{}
+	for _, size := range append(wanted, "") {
+		for _, edge := range product.Variants.Edges {
+			v := edge.Node
+			if !v.AvailableForSale || v.ID == currentVariantID || len(options) >= maxExchangeOptions {
+				continue
+			}
+
+			selected := map[string]string{}
+			sameOthers := true
+			for _, so := range v.SelectedOptions {
+				selected[so.Name] = so.Value
+				if so.Name != sizeOption && so.Value != current[so.Name] {
+					sameOthers = false
+				}
+			}
+			switch {
+			case size == "" && (sizeOption != "" || sizeChange):
+				// Every preferred size has been tried
+				continue
+			case size != "" && selected[sizeOption] != size:
+				continue
+			case sizeChange && !sameOthers:
+				continue
+			}
+
+			options = append(options, ExchangeOption{
+				VariantID:       v.ID,
+				Title:           v.Title,
+				SelectedOptions: selected,
+				Price:           strings.TrimSpace(v.Price.Amount + " " + v.Price.CurrencyCode),
+			})
+		}
+		if sizeChange && len(options) > 0 {
+			// Offer the nearest size that is in stock
+			break
+		}
+	}
+	return options
+}
+
+// exchangeOptions suggests variants to exchange an eligible order line for
+func (c *ReturnsController) exchangeOptions(ctx context.Context, tenant, customerID, orderID, lineItemID, reason string, locale models.Locale) ([]ExchangeOption, error) {
+	shopifyReason, ok := returnReasons[strings.ToLower(reason)]
+	if !ok {
+		return nil, &returnError{Code: "invalid_request", Message: "unknown return reason " + reason}
+	}
+
+	policy := c.policy(ctx, tenant)
+	if !policy.ExchangesEnabled {
+		return nil, &returnError{Code: "not_returnable", Message: "exchanges are not offered, the item can only be returned"}
+	}
+
+	order, err := c.loadOrder(ctx, customerID, orderID)
+	if err != nil {
+		return nil, err
+	}
+	line, err := findReturnLine(checkReturnEligibility(order, policy, time.Now()), lineItemID)
+	if err != nil {
+		return nil, err
+	}
+	if !line.Eligible {
+		return nil, &returnError{Code: "not_returnable", Message: fmt.Sprintf("%s cannot be exchanged: %s", line.Title, line.Reason)}
+	}
+	if line.ProductID == "" {
+		return nil, &returnError{Code: "not_returnable", Message: "the product is no longer sold, so it can only be returned"}
+	}
+
+	product, err := c.loadExchangeProduct(ctx, line.ProductID, locale)
+	if err != nil {
+		return nil, err
+	}
+	return suggestExchanges(product, line.VariantID, shopifyReason), nil
+}
+
+// requestReturn validates every item against the policy and asks Shopify
+// for the return. Requested exchanges are passed to the merchant in the
+// customer note since returnRequest does not take exchange lines.
+func (c *ReturnsController) requestReturn(ctx context.Context, tenant, customerID string, req ReturnRequest, locale models.Locale) (ReturnStatus, error) {
+	if req.OrderID == "" || len(req.Items) == 0 {
+		return ReturnStatus{}, &returnError{Code: "invalid_request", Message: "orderId and at least one item are required"}
+	}
+
+	policy := c.policy(ctx, tenant)
+	order, err := c.loadOrder(ctx, customerID, req.OrderID)
+	if err != nil {
+		return ReturnStatus{}, err
+	}
+	eligibility := checkReturnEligibility(order, policy, time.Now())
+
+	lineItems := make([]map[string]interface{}, 0, len(req.Items))
+	for _, item := range req.Items {
+		line, err := findReturnLine(eligibility, item.LineItemID)
+		if err != nil {
+			return ReturnStatus{}, err
+		}
+		if !line.Eligible {
+			return ReturnStatus{}, &returnError{Code: "not_returnable", Message: fmt.Sprintf("%s cannot be returned: %s", line.Title, line.Reason)}
+		}
+		if item.Quantity < 1 || item.Quantity > line.Quantity {
+			return ReturnStatus{}, &returnError{Code: "invalid_request", Message: fmt.Sprintf("quantity for %s must be between 1 and %d", line.Title, line.Quantity)}
+		}
+		reason, ok := returnReasons[strings.ToLower(item.Reason)]
+		if !ok {
+			return ReturnStatus{}, &returnError{Code: "invalid_request", Message: "unknown return reason " + item.Reason}
+		}
+
+		note := strings.TrimSpace(item.Note)
+		if item.ExchangeVariantID != "" {
+			exchange, err := c.validateExchange(ctx, policy, line, item.ExchangeVariantID, locale)
+			if err != nil {
+				return ReturnStatus{}, err
+			}
+			note = strings.TrimSpace(fmt.Sprintf("%s\nExchange requested for %s (%s).", note, exchange.Title, exchange.VariantID))
+		}
+
+		lineItem := map[string]interface{}{
+			"fulfillmentLineItemId": line.FulfillmentLineItemID,
+			"quantity":              item.Quantity,
+			"returnReason":          reason,
+		}
+		if note != "" {
+			lineItem["customerNote"] = note
+		}
+		lineItems = append(lineItems, lineItem)
+	}
+
+	mutation := `mutation RequestReturn($input: ReturnRequestInput!) {
+	returnRequest(input: $input) {
+		return { id name status }
+		userErrors { field message }
+	}
+}`
+	var data struct {
+		ReturnRequest struct {
+			Return *struct {
+				ID     string `json:"id"`
+				Name   string `json:"name"`
+				Status string `json:"status"`
+			} `json:"return"`
+			UserErrors []struct {
+				Message string `json:"message"`
+			} `json:"userErrors"`
+		} `json:"returnRequest"`
+	}
+	variables := map[string]interface{}{"input": map[string]interface{}{"orderId": order.ID, "returnLineItems": lineItems}}
+	if err := executeAdminQuery(ctx, c.shopify, mutation, variables, &data); err != nil {
+		return ReturnStatus{}, err
+	}
+	if errs := data.ReturnRequest.UserErrors; len(errs) > 0 {
+		messages := make([]string, 0, len(errs))
+		for _, e := range errs {
+			messages = append(messages, e.Message)
+		}
+		return ReturnStatus{}, &returnError{Code: "return_rejected", Message: strings.Join(messages, "; ")}
+	}
+	if data.ReturnRequest.Return == nil {
+		return ReturnStatus{}, fmt.Errorf("returnRequest returned no return")
+	}
+
+	created := data.ReturnRequest.Return
+	slog.InfoContext(ctx, "return requested", slog.String("tenant", tenant), slog.String("order", order.Name), slog.String("return", created.Name))
+	return ReturnStatus{ReturnID: created.ID, Reference: created.Name, Status: created.Status, OrderName: order.Name, Labels: []ReturnLabel{}}, nil
+}
+
+// validateExchange checks that variantID is an in-stock variant of the
+// line's product
+func (c *ReturnsController) validateExchange(ctx context.Context, policy models.ReturnPolicy, line ReturnLineEligibility, variantID string, locale models.Locale) (ExchangeOption, error) {
+	if !policy.ExchangesEnabled {
+		return ExchangeOption{}, &returnError{Code: "not_returnable", Message: "exchanges are not offered, the item can only be returned"}
+	}
+	if line.ProductID == "" {
+		return ExchangeOption{}, &returnError{Code: "not_returnable", Message: "the product is no longer sold, so it can only be returned"}
+	}
+
+	product, err := c.loadExchangeProduct(ctx, line.ProductID, locale)
+	if err != nil {
+		return ExchangeOption{}, err
+	}
+	variantID = models.GIDFromID("ProductVariant", variantID)
+	for _, edge := range product.Variants.Edges {
+		if edge.Node.ID == variantID && edge.Node.AvailableForSale {
+			return ExchangeOption{VariantID: edge.Node.ID, Title: edge.Node.Title}, nil
+		}
+	}
+	return ExchangeOption{}, &returnError{Code: "invalid_request", Message: "the exchange variant is not an in-stock variant of " + line.Title}
+}
+
+// returnStatus reads a return of customerID with any shipping labels
+func (c *ReturnsController) returnStatus(ctx context.Context, customerID, returnID string) (ReturnStatus, error) {
+	query := `query ReturnStatus($id: ID!) {
+	return(id: $id) {
+		id
+		name
+		status
+		order { name customer { id } }
+		reverseFulfillmentOrders(first: 5) {
+			edges {
+				node {
+					reverseDeliveries(first: 5) {
+						edges {
+							node {
+								deliverable {
+									... on ReverseDeliveryShippingDeliverable {
+										label { publicFileUrl }
+										tracking { carrierName number url }
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+	var data struct {
+		Return *struct {
+			ID     string `json:"id"`
+			Name   string `json:"name"`
+			Status string `json:"status"`
+			Order  struct {
+				Name     string `json:"name"`
+				Customer *struct {
+					ID string `json:"id"`
+				} `json:"customer"`
+			} `json:"order"`
+			ReverseFulfillmentOrders struct {
+				Edges []struct {
+					Node struct {
+						ReverseDeliveries struct {
+							Edges []struct {
+								Node struct {
+									Deliverable *struct {
+										Label *struct {
+											PublicFileURL string `json:"publicFileUrl"`
+										} `json:"label"`
+										Tracking *struct {
+											CarrierName string `json:"carrierName"`
+											Number      string `json:"number"`
+											URL         string `json:"url"`
+										} `json:"tracking"`
+									} `json:"deliverable"`
+								} `json:"node"`
+							} `json:"edges"`
+						} `json:"reverseDeliveries"`
+					} `json:"node"`
+				} `json:"edges"`
+			} `json:"reverseFulfillmentOrders"`
+		} `json:"return"`
+	}
+	if err := executeAdminQuery(ctx, c.shopify, query, map[string]interface{}{"id": models.GIDFromID("Return", returnID)}, &data); err != nil {
+		return ReturnStatus{}, err
+	}
+	ret := data.Return
+	if ret == nil || ret.Order.Customer == nil || ret.Order.Customer.ID != customerID {
+		return ReturnStatus{}, errReturnOrderNotFound
+	}
+
+	status := ReturnStatus{ReturnID: ret.ID, Reference: ret.Name, Status: ret.Status, OrderName: ret.Order.Name, Labels: []ReturnLabel{}}
+	for _, rfo := range ret.ReverseFulfillmentOrders.Edges {
+		for _, delivery := range rfo.Node.ReverseDeliveries.Edges {
+			deliverable := delivery.Node.Deliverable
+			if deliverable == nil {
+				continue
+			}
+			var label ReturnLabel
+			if deliverable.Label != nil {
+				label.URL = deliverable.Label.PublicFileURL
+			}
+			if deliverable.Tracking != nil {
+				label.Carrier = deliverable.Tracking.CarrierName
+				label.TrackingNumber = deliverable.Tracking.Number
+				label.TrackingURL = deliverable.Tracking.URL
+			}
+			if label != (ReturnLabel{}) {
+				status.Labels = append(status.Labels, label)
+			}
+		}
+	}
+	return status, nil
+}
+
+// writeReturnError maps a returns flow error to the error envelope
+func writeReturnError(w http.ResponseWriter, r *http.Request, err error) {
+	var refused *returnError
+	switch {
+	case errors.Is(err, errReturnOrderNotFound):
+		utils.WriteError(w, http.StatusNotFound, "not_found", "Order or return not found")
+	case errors.As(err, &refused) && refused.Code == "invalid_request":
+		utils.WriteError(w, http.StatusBadRequest, refused.Code, refused.Message)
+	case errors.As(err, &refused):
+		utils.WriteError(w, http.StatusUnprocessableEntity, refused.Code, refused.Message)
+	default:
+		writeUpstreamError(w, r, err)
+	}
+}
+
+// verifiedCustomer returns the verified customer of the X-Session-ID
+// session, writing an error when the session has none. Return policies
+// follow the tenant stored with the identity, not the request's headers.
+func (c *ReturnsController) verifiedCustomer(w http.ResponseWriter, r *http.Request) (*models.CustomerIdentity, bool) {
+	sessionID := sessionHeader(r)
+	if sessionID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "X-Session-ID header is required")
+		return nil, false
+	}
+
+	identity, err := c.store.GetSessionIdentity(r.Context(), sessionID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return nil, false
+	}
+	if identity == nil {
+		utils.WriteError(w, http.StatusUnauthorized, "verified_identity_required", "Sign in to manage returns")
+		return nil, false
+	}
+	return identity, true
+}
+
+// GetReturnEligibility lists which lines of ?orderId= the customer signed in
+// to the session can return
+func (c *ReturnsController) GetReturnEligibility(w http.ResponseWriter, r *http.Request) {
+	identity, ok := c.verifiedCustomer(w, r)
+	if !ok {
+		return
+	}
+	orderID := r.URL.Query().Get("orderId")
+	if orderID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "orderId is required")
+		return
+	}
+
+	eligibility, err := c.eligibility(r.Context(), identity.TenantOrDefault(), identity.CustomerID, orderID)
+	if err != nil {
+		writeReturnError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, eligibility)
+}
+
+// GetExchangeOptions suggests variants to exchange an order line for, given
+// ?orderId=, ?lineItemId= and the return ?reason=
+func (c *ReturnsController) GetExchangeOptions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	identity, ok := c.verifiedCustomer(w, r)
+	if !ok {
+		return
+	}
+	if q.Get("orderId") == "" || q.Get("lineItemId") == "" || q.Get("reason") == "" {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "orderId, lineItemId and reason are required")
+		return
+	}
+
+	options, err := c.exchangeOptions(r.Context(), identity.TenantOrDefault(), identity.CustomerID,
+		q.Get("orderId"), q.Get("lineItemId"), q.Get("reason"), utils.ParseLocale(r))
+	if err != nil {
+		writeReturnError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"options": options})
+}
+
+// CreateReturn requests a return for the verified customer of the session
+func (c *ReturnsController) CreateReturn(w http.ResponseWriter, r *http.Request) {
+	var req ReturnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON request body")
+		return
+	}
+	identity, ok := c.verifiedCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := c.requestReturn(r.Context(), identity.TenantOrDefault(), identity.CustomerID, req, utils.ParseLocale(r))
+	if err != nil {
+		writeReturnError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, status)
+}
+
+// GetReturn returns the status, reference and labels of a return of the
+// session's verified customer
+func (c *ReturnsController) GetReturn(w http.ResponseWriter, r *http.Request) {
+	identity, ok := c.verifiedCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := c.returnStatus(r.Context(), identity.CustomerID, mux.Vars(r)["id"])
+	if err != nil {
+		writeReturnError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, status)
+}
+
+// GetReturnPolicy returns the caller's tenant return policy
+func (c *ReturnsController) GetReturnPolicy(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, c.policy(r.Context(), middleware.TenantFromRequest(r)))
+}
+
+// UpdateReturnPolicy replaces the caller's tenant return policy
+func (c *ReturnsController) UpdateReturnPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy models.ReturnPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON request body")
+		return
+	}
+	if policy.WindowDays < 0 || policy.WindowDays > 365 {
+		utils.WriteError(w, http.StatusBadRequest, "invalid_request", "windowDays must be between 0 and 365")
+		return
+	}
+	if policy.FinalSaleTags == nil {
+		policy.FinalSaleTags = []string{}
+	}
+
+	tenant := middleware.TenantFromRequest(r)
+	if err := c.store.SaveReturnPolicy(r.Context(), tenant, policy); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, c.policy(r.Context(), tenant))
+}
+
+// returnReasonSchema is the reason argument of the returns chat tools
+var returnReasonSchema = &genai.Schema{
+	Type:        genai.TypeString,
+	Format:      "enum",
+	Enum:        []string{"size_too_small", "size_too_large", "unwanted", "not_as_described", "wrong_item", "defective", "style", "color", "other"},
+	Description: "Why the shopper is returning the item",
+}
+
+// returnToolResult converts a returns flow result for the model. Refusals
+// are answers rather than failures, so they come back as refused with a
+// message the model can relay.
+func returnToolResult(v any, err error) (map[string]any, error) {
+	var refused *returnError
+	switch {
+	case errors.Is(err, errReturnOrderNotFound):
+		return map[string]any{"refused": "not_found", "message": "No such order or return on the shopper's account."}, nil
+	case errors.As(err, &refused):
+		return map[string]any{"refused": refused.Code, "message": refused.Message}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]any
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *ChatController) returns() *ReturnsController {
+	return &ReturnsController{cfg: c.cfg, shopify: c.shopify, store: c.store}
+}
+
+// runReturnEligibilityTool checks which lines of an order can be returned
+func (c *ChatController) runReturnEligibilityTool(ctx context.Context, cc chatContext, args map[string]any) (map[string]any, error) {
+	orderID, _ := args["orderId"].(string)
+	return returnToolResult(c.returns().eligibility(ctx, cc.Identity.TenantOrDefault(), cc.Identity.CustomerID, orderID))
+}
+
+// runSuggestExchangeTool suggests variants to exchange an order line for
+func (c *ChatController) runSuggestExchangeTool(ctx context.Context, cc chatContext, args map[string]any) (map[string]any, error) {
+	orderID, _ := args["orderId"].(string)
+	lineItemID, _ := args["lineItemId"].(string)
+	reason, _ := args["reason"].(string)
+	options, err := c.returns().exchangeOptions(ctx, cc.Identity.TenantOrDefault(), cc.Identity.CustomerID, orderID, lineItemID, reason, cc.Locale)
+	return returnToolResult(map[string]any{"options": options}, err)
+}
+
+// runRequestReturnTool requests a return of one order line
+func (c *ChatController) runRequestReturnTool(ctx context.Context, cc chatContext, args map[string]any) (map[string]any, error) {
+	item := ReturnItemRequest{}
+	item.LineItemID, _ = args["lineItemId"].(string)
+	item.Reason, _ = args["reason"].(string)
+	item.Note, _ = args["note"].(string)
+	item.ExchangeVariantID, _ = args["exchangeVariantId"].(string)
+	if n, ok := args["quantity"].(float64); ok {
+		item.Quantity = int(n)
+	}
+	orderID, _ := args["orderId"].(string)
+
+	req := ReturnRequest{OrderID: orderID, Items: []ReturnItemRequest{item}}
+	return returnToolResult(c.returns().requestReturn(ctx, cc.Identity.TenantOrDefault(), cc.Identity.CustomerID, req, cc.Locale))
+}
+
+// runReturnStatusTool reads the status and label of a return
+func (c *ChatController) runReturnStatusTool(ctx context.Context, cc chatContext, args map[string]any) (map[string]any, error) {
+	returnID, _ := args["returnId"].(string)
+	return returnToolResult(c.returns().returnStatus(ctx, cc.Identity.CustomerID, returnID))
+}
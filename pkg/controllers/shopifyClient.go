@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"strategy-fox-go-bd/pkg/config"
+)
+
+// ShopifyClient sends GraphQL operations to the shop and returns the raw
+// response body. Storefront serves public reads; Admin is for server-side
+// operations and must never back an anonymous read.
+type ShopifyClient interface {
+	Storefront(ctx context.Context, query string, variables map[string]interface{}) ([]byte, error)
+	Admin(ctx context.Context, query string, variables map[string]interface{}) ([]byte, error)
+}
+
+// httpShopifyClient talks to the Shopify GraphQL endpoints of one store
+type httpShopifyClient struct {
+	cfg        config.ShopifyConfig
+	httpClient *http.Client
+}
+
+// NewShopifyClient returns a client for the configured store. A nil
+// httpClient uses http.DefaultClient.
+func NewShopifyClient(cfg config.ShopifyConfig, httpClient *http.Client) ShopifyClient {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &httpShopifyClient{cfg: cfg, httpClient: httpClient}
+}
+
+// Storefront runs a Storefront API operation. The Storefront API only
+// exposes ACTIVE products published to the storefront's sales channel.
+func (c *httpShopifyClient) Storefront(ctx context.Context, query string, variables map[string]interface{}) ([]byte, error) {
+	accessToken := c.cfg.StorefrontAccessToken.Value()
+	if accessToken == "" {
+		return nil, fmt.Errorf("Shopify storefront access token not set")
+	}
+
+	return sendGraphQLRequest(ctx, c.httpClient, "storefront", c.cfg.StorefrontURL(), "X-Shopify-Storefront-Access-Token", accessToken, query, variables)
+}
+
+// Admin runs an Admin API operation with the private access token
+func (c *httpShopifyClient) Admin(ctx context.Context, query string, variables map[string]interface{}) ([]byte, error) {
+	accessToken := c.cfg.AdminAccessToken.Value()
+	if accessToken == "" {
+		return nil, fmt.Errorf("Shopify access token not set")
+	}
+
+	return sendGraphQLRequest(ctx, c.httpClient, "admin", c.cfg.AdminURL(), "X-Shopify-Access-Token", accessToken, query, variables)
+}
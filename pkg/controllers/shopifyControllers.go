@@ -2,17 +2,24 @@ package controllers
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"os"
 )
 
-var ctx = context.Background()
+// ShopifyController serves the catalog endpoints under /api/shopify
+type ShopifyController struct {
+	shopify ShopifyClient
+	cache   Cache
+}
+
+// NewShopifyController returns a ShopifyController reading from shopify and
+// caching responses in cache
+func NewShopifyController(shopify ShopifyClient, cache Cache) *ShopifyController {
+	return &ShopifyController{shopify: shopify, cache: cache}
+}
 
 //func GetProducts(w http.ResponseWriter, r *http.Request) {
 //
@@ -155,15 +162,68 @@ var ctx = context.Background()
 //	w.Write(responseBody)
 //}
 
-// GetProductByIdGQ retrieves product details and 3D models by product ID
-func GetProductByIdGQ(w http.ResponseWriter, r *http.Request) {
+// legacyV2Products maps a Storefront product response back to the shape
+// v2 clients got from the Admin API: prices as amount strings, a status and
+// 1-based option positions. The Storefront API only returns active
+// products. Bodies that are not JSON are returned unchanged.
+func legacyV2Products(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var response interface{}
+	if err := decoder.Decode(&response); err != nil {
+		return body
+	}
+	legacyV2Node(response)
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		return body
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n"))
+}
+
+// legacyV2Node rewrites the products and variants found under v in place
+func legacyV2Node(v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			legacyV2Node(item)
+		}
+	case map[string]interface{}:
+		for _, key := range []string{"price", "compareAtPrice"} {
+			if money, ok := v[key].(map[string]interface{}); ok {
+				v[key] = money["amount"]
+			}
+		}
+		if options, ok := v["options"].([]interface{}); ok {
+			if _, isProduct := v["title"]; isProduct {
+				v["status"] = "ACTIVE"
+			}
+			for i, option := range options {
+				if option, ok := option.(map[string]interface{}); ok {
+					option["position"] = i + 1
+				}
+			}
+		}
+		for _, child := range v {
+			legacyV2Node(child)
+		}
+	}
+}
+
+// GetProductByIdGQ retrieves product details and 3D models by product ID.
+// Reads go through the Storefront API, which only exposes ACTIVE products
+// published to the storefront channel.
+func (c *ShopifyController) GetProductByIdGQ(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID := vars["id"]
 
 	// GraphQL query
-	query := fmt.Sprintf(`
-		{
-			product(id: "gid://shopify/Product/%s") {
+	query := `
+		query ProductById($id: ID!) {
+			product(id: $id) {
 				id
 				title
 				descriptionHtml
@@ -192,7 +252,10 @@ func GetProductByIdGQ(w http.ResponseWriter, r *http.Request) {
 				variants(first: 1) {
 					edges {
 						node {
-							price
+							price {
+								amount
+								currencyCode
+							}
 						}
 					}
 				}
@@ -202,40 +265,30 @@ func GetProductByIdGQ(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
-	`, productID)
-
-	// Shopify GraphQL API details
-	apiURL := fmt.Sprintf("https://%s/admin/api/2024-10/graphql.json", os.Getenv("SHOPIFY_STORE_NAME"))
-	accessToken := os.Getenv("SHOPIFY_ADMIN_API_PASS_TOKEN")
-
-	// Validate token
-	if accessToken == "" {
-		http.Error(w, "Shopify access token not set", http.StatusInternalServerError)
-		return
-	}
+	`
 
 	// Execute GraphQL request
-	responseBody, err := executeGraphQLRequest(apiURL, accessToken, query)
+	responseBody, err := c.shopify.Storefront(r.Context(), query, map[string]interface{}{"id": "gid://shopify/Product/" + productID})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error executing GraphQL request: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Write the response back to the client
+	// Write the response back to the client in the v2 shape
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write(responseBody)
+	w.Write(legacyV2Products(responseBody))
 }
 
 // GetProductByNameGQ retrieves product details and 3D models by product handle
-func GetProductByNameGQ(w http.ResponseWriter, r *http.Request) {
+func (c *ShopifyController) GetProductByNameGQ(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productHandle := vars["name"]
 
-	// GraphQL query
-	query := fmt.Sprintf(`
-		{
-			productByHandle(handle: "%s") {
+	// productByHandle is deprecated; the alias keeps the v2 response key
+	query := `
+		query ProductByHandle($handle: String!) {
+			productByHandle: product(handle: $handle) {
 				id
 				title
 				descriptionHtml
@@ -264,7 +317,10 @@ func GetProductByNameGQ(w http.ResponseWriter, r *http.Request) {
 				variants(first: 1) {
 					edges {
 						node {
-							price
+							price {
+								amount
+								currencyCode
+							}
 						}
 					}
 				}
@@ -274,150 +330,139 @@ func GetProductByNameGQ(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
-	`, productHandle)
-
-	// Shopify GraphQL API details
-	apiURL := fmt.Sprintf("https://%s/admin/api/2024-10/graphql.json", os.Getenv("SHOPIFY_STORE_NAME"))
-	accessToken := os.Getenv("SHOPIFY_ADMIN_API_PASS_TOKEN")
-
-	// Validate token
-	if accessToken == "" {
-		http.Error(w, "Shopify access token not set", http.StatusInternalServerError)
-		return
-	}
+	`
 
 	// Execute GraphQL request
-	responseBody, err := executeGraphQLRequest(apiURL, accessToken, query)
+	responseBody, err := c.shopify.Storefront(r.Context(), query, map[string]interface{}{"handle": productHandle})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error executing GraphQL request: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Write the response back to the client
+	// Write the response back to the client in the v2 shape
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write(responseBody)
+	w.Write(legacyV2Products(responseBody))
 }
 
-func GetProductsGQ(w http.ResponseWriter, r *http.Request) {
-	query := fmt.Sprintf(`{
-		products(first: 34) {
-    edges {
-      node {
-        id
-        title
-        descriptionHtml
-        vendor
-        productType
-        createdAt
-        updatedAt
-        handle
-        tags
-        status
-        media(first: 10) {
-      edges {
-        node {
-          mediaContentType
-          alt
-          ... on Model3d {
-            id
-            sources {
-              url
-              format
-              mimeType
-            }
-          }
-          ... on MediaImage {
-            image {
-              url
-              altText
-            }
-          }
-        }
-      }
-    }
-        options {
-          id
-          name
-          position
-          values
-        }
-        variants(first: 10) {
-          edges {
-            node {
-              id
-              title
-              price
-              compareAtPrice
-              availableForSale
-              selectedOptions {
-                name
-                value
-              }
-              sku
-            }
-          }
-        }
-      }
-    }
-  }}
-	`)
-
-	apiURL := fmt.Sprintf("https://%s/admin/api/2024-10/graphql.json", os.Getenv("SHOPIFY_STORE_NAME"))
-	accessToken := os.Getenv("SHOPIFY_ADMIN_API_PASS_TOKEN")
+// productGQFieldsFragment is the product projection of the /v2/products
+// list, shared with collection pages so the two cannot drift apart
+const productGQFieldsFragment = `
+fragment ProductGQFields on Product {
+	id
+	title
+	descriptionHtml
+	vendor
+	productType
+	createdAt
+	updatedAt
+	handle
+	tags
+	media(first: 10) {
+		edges {
+			node {
+				mediaContentType
+				alt
+				... on Model3d {
+					id
+					sources {
+						url
+						format
+						mimeType
+					}
+				}
+				... on MediaImage {
+					image {
+						url
+						altText
+					}
+				}
+			}
+		}
+	}
+	options {
+		id
+		name
+		values
+	}
+	variants(first: 10) {
+		edges {
+			node {
+				id
+				title
+				price {
+					amount
+					currencyCode
+				}
+				compareAtPrice {
+					amount
+					currencyCode
+				}
+				availableForSale
+				selectedOptions {
+					name
+					value
+				}
+				sku
+			}
+		}
+	}
+}
+`
 
-	// Validate token
-	if accessToken == "" {
-		http.Error(w, "Shopify access token not set", http.StatusInternalServerError)
-		return
+func (c *ShopifyController) GetProductsGQ(w http.ResponseWriter, r *http.Request) {
+	query := `{
+	products(first: 34) {
+		edges { node { ...ProductGQFields } }
 	}
+}
+` + productGQFieldsFragment
 
 	// Execute GraphQL request
-	responseBody, err := executeGraphQLRequest(apiURL, accessToken, query)
+	responseBody, err := c.shopify.Storefront(r.Context(), query, nil)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error executing GraphQL request: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Write the response back to the client
+	// Write the response back to the client in the v2 shape
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write(responseBody)
+	w.Write(legacyV2Products(responseBody))
+}
+
+// MetafieldUpdateRequest sets one metafield on a product
+type MetafieldUpdateRequest struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Type      string `json:"type"`
 }
 
-func UpdateMetafieldById(w http.ResponseWriter, r *http.Request) {
+// UpdateMetafieldById sets a product metafield through the Admin API. The
+// values are sent as GraphQL variables so they cannot alter the mutation.
+func (c *ShopifyController) UpdateMetafieldById(w http.ResponseWriter, r *http.Request) {
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error reading body: %v", err), http.StatusBadRequest)
+		return
 	}
 
-	var parsedBody map[string]interface{}
-	err = json.Unmarshal(body, &parsedBody)
+	var req MetafieldUpdateRequest
+	err = json.Unmarshal(body, &req)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error parsing body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" || req.Namespace == "" || req.Key == "" || req.Type == "" {
+		http.Error(w, "id, namespace, key and type are required", http.StatusBadRequest)
+		return
 	}
 
-	id := parsedBody["id"].(string)
-	namespace := parsedBody["namespace"].(string)
-	key := parsedBody["key"].(string)
-	value := parsedBody["value"].(string)
-	typo := parsedBody["type"].(string)
-
-	mutation := fmt.Sprintf(`mutation {
-  productUpdate(
-    input: {
-      id: "%s",
-      metafields: [
-        {
-          namespace: "%s",
-          key: "%s",
-          value: "%s",
-          type: "%s"
-        }
-      ]
-    }
-  ) {
+	mutation := `mutation UpdateProductMetafield($input: ProductInput!) {
+  productUpdate(input: $input) {
     product {
       id
       title
@@ -438,20 +483,21 @@ func UpdateMetafieldById(w http.ResponseWriter, r *http.Request) {
     }
   }
 }
-`, id, namespace, key, value, typo)
-
-	// Shopify GraphQL API details
-	apiURL := fmt.Sprintf("https://%s/admin/api/2024-10/graphql.json", os.Getenv("SHOPIFY_STORE_NAME"))
-	accessToken := os.Getenv("SHOPIFY_ADMIN_API_PASS_TOKEN")
-
-	// Validate token
-	if accessToken == "" {
-		http.Error(w, "Shopify access token not set", http.StatusInternalServerError)
-		return
+`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"id": req.ID,
+			"metafields": []map[string]interface{}{{
+				"namespace": req.Namespace,
+				"key":       req.Key,
+				"value":     req.Value,
+				"type":      req.Type,
+			}},
+		},
 	}
 
 	// Execute GraphQL request
-	responseBody, err := executeGraphQLRequest(apiURL, accessToken, mutation)
+	responseBody, err := c.shopify.Admin(r.Context(), mutation, variables)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error executing GraphQL request: %v", err), http.StatusInternalServerError)
 		return
@@ -463,42 +509,3 @@ func UpdateMetafieldById(w http.ResponseWriter, r *http.Request) {
 	w.Write(responseBody)
 
 }
-
-func executeGraphQLRequest(url, accessToken, query string) ([]byte, error) {
-	// Prepare request body
-	requestBody, err := json.Marshal(map[string]string{"query": query})
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request body: %v", err)
-	}
-
-	// Create the HTTP POST request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
-	}
-
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Shopify-Access-Token", accessToken)
-
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// Read the response body
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
-	}
-
-	// Check for non-200 status codes
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GraphQL query failed with status: %d, response: %s", resp.StatusCode, body)
-	}
-
-	return body, nil
-}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"strings"
+
+	"strategy-fox-go-bd/pkg/models"
+)
+
+// withInContext appends the @inContext directive for the given locale to a
+// Storefront operation header such as "query Products($first: Int!)", adding
+// the matching variable definitions and values. The header is returned
+// unchanged when no locale was requested.
+func withInContext(operation string, locale models.Locale, variables map[string]interface{}) string {
+	var definitions, arguments []string
+	if locale.Country != "" {
+		definitions = append(definitions, "$country: CountryCode")
+		arguments = append(arguments, "country: $country")
+		variables["country"] = locale.Country
+	}
+	if locale.Language != "" {
+		definitions = append(definitions, "$language: LanguageCode")
+		arguments = append(arguments, "language: $language")
+		variables["language"] = locale.Language
+	}
+	if len(arguments) == 0 {
+		return operation
+	}
+
+	if strings.HasSuffix(operation, ")") {
+		operation = strings.TrimSuffix(operation, ")") + ", " + strings.Join(definitions, ", ") + ")"
+	} else {
+		operation += "(" + strings.Join(definitions, ", ") + ")"
+	}
+
+	return operation + " @inContext(" + strings.Join(arguments, ", ") + ")"
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/logging"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/notify"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+// restockDeliveryTimeout bounds the notifications sent for one restock
+// webhook after it has been acknowledged
+const restockDeliveryTimeout = 2 * time.Minute
+
+// WebhookController receives Shopify webhooks. Deliveries are verified by
+// middleware.VerifyShopifyWebhook before they reach it.
+type WebhookController struct {
+	inventory   *InventoryController
+	backInStock *BackInStockController
+}
+
+// NewWebhookController returns a WebhookController updating the inventory
+// cache and sending the back-in-stock notifications in store through notifier
+func NewWebhookController(cfg *config.Config, shopify ShopifyClient, cache Cache, store *models.Store, notifier notify.Notifier) *WebhookController {
+	return &WebhookController{
+		inventory:   NewInventoryController(cfg, shopify, cache),
+		backInStock: NewBackInStockController(cfg, shopify, store, notifier),
+	}
+}
+
+// HandleShopifyWebhook dispatches a webhook on its X-Shopify-Topic. Topics
+// without a handler are acknowledged so Shopify does not retry them.
+// Back-in-stock notifications are sent after the response, as Shopify
+// expects webhooks to be acknowledged within seconds.
+func (c *WebhookController) HandleShopifyWebhook(w http.ResponseWriter, r *http.Request) {
+	topic := r.Header.Get("X-Shopify-Topic")
+	switch topic {
+	case "inventory_levels/update":
+		var update InventoryLevelUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil || update.InventoryItemID == 0 {
+			utils.WriteError(w, http.StatusBadRequest, "invalid_request", "Invalid inventory level payload")
+			return
+		}
+		c.inventory.invalidateLevel(r.Context(), update)
+		if update.Available != nil && *update.Available > 0 {
+			go c.deliverRestock(logging.Detach(r.Context()), strconv.FormatInt(update.InventoryItemID, 10))
+		}
+	default:
+		slog.InfoContext(r.Context(), "ignored webhook", slog.String("topic", topic))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deliverRestock notifies the subscribers of a restocked inventory item in
+// the background. Undelivered notifications are retried by a later restock
+// or the poller.
+func (c *WebhookController) deliverRestock(ctx context.Context, itemID string) {
+	ctx, cancel := context.WithTimeout(ctx, restockDeliveryTimeout)
+	defer cancel()
+	c.backInStock.notifyRestock(ctx, itemID)
+}
@@ -0,0 +1,247 @@
+// Package guardrails screens chatbot traffic in both directions: shopper
+// input is checked for prompt injection and disallowed topics before it
+// reaches the model, and model output is checked for leaked instructions,
+// competitor mentions and discount codes the shop never issued.
+package guardrails
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/generative-ai-go/genai"
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/redact"
+)
+
+// Verdict is the outcome of a guardrail check
+type Verdict struct {
+	Blocked bool
+	Rule    string
+	Reason  string
+}
+
+var allowed = Verdict{}
+
+func blocked(rule, reason string) Verdict {
+	return Verdict{Blocked: true, Rule: rule, Reason: reason}
+}
+
+// injectionPatterns match common attempts to override the system prompt
+var injectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b.{0,30}\b(previous|prior|above|earlier|all|your|system)\b.{0,20}\b(instructions?|prompts?|rules?|directions?)\b`),
+	regexp.MustCompile(`(?i)\b(reveal|show|print|repeat|output|tell me)\b.{0,30}\b(system prompt|system message|hidden (instructions?|prompt)|initial instructions?|your instructions)\b`),
+	regexp.MustCompile(`(?i)\byou are (now|no longer)\b`),
+	regexp.MustCompile(`(?i)\b(developer|god|jailbreak|dan) mode\b`),
+	regexp.MustCompile(`(?i)\bpretend (to be|you are)\b.{0,40}\b(unrestricted|without (any )?(rules|restrictions|filters))\b`),
+	regexp.MustCompile(`(?i)</?(system|assistant|instructions?)>`),
+}
+
+// InputPolicy holds the rules applied to shopper messages
+type InputPolicy struct {
+	// BlockedTopics are subjects the bot refuses to discuss
+	BlockedTopics []string
+}
+
+// NewInputPolicy builds a policy refusing the configured topics
+func NewInputPolicy(cfg config.GuardrailsConfig) *InputPolicy {
+	return &InputPolicy{BlockedTopics: cfg.BlockedTopics}
+}
+
+// CheckInput screens a shopper message before it is sent to the model
+func (p *InputPolicy) CheckInput(text string) Verdict {
+	for _, pattern := range injectionPatterns {
+		if pattern.MatchString(text) {
+			return blocked("prompt_injection", fmt.Sprintf("matched %q", pattern.FindString(text)))
+		}
+	}
+
+	lower := strings.ToLower(text)
+	for _, topic := range p.BlockedTopics {
+		if containsWord(lower, topic) {
+			return blocked("disallowed_topic", fmt.Sprintf("mentions %q", topic))
+		}
+	}
+
+	return allowed
+}
+
+// OutputPolicy holds the rules applied to model responses
+type OutputPolicy struct {
+	// Competitors are brand names the bot must not mention
+	Competitors []string
+	// AllowedDiscountCodes are the only codes the bot may quote
+	AllowedDiscountCodes []string
+
+	promptShingles map[string]bool
+}
+
+// leakShingleSize is the number of consecutive system prompt words that must
+// reappear in a response to count as leaked instructions. It is long enough
+// that echoing one of the prompt's short example phrases is not flagged.
+const leakShingleSize = 12
+
+// discountCodePattern finds codes offered as "code SAVE20", "coupon: XMAS"
+// or "promo code “WELCOME10”"
+var discountCodePattern = regexp.MustCompile(`(?i)\b(?:discount code|promo code|coupon code|coupon|code|voucher)\b\s*[:\-]?\s*["'“‘*]*([A-Za-z0-9_-]{4,24})`)
+
+// NewOutputPolicy builds a policy protecting systemPrompt with the
+// configured competitors and allowed discount codes
+func NewOutputPolicy(cfg config.GuardrailsConfig, systemPrompt string) *OutputPolicy {
+	return &OutputPolicy{
+		Competitors:          cfg.Competitors,
+		AllowedDiscountCodes: cfg.AllowedDiscountCodes,
+		promptShingles:       shingles(stripExamples(systemPrompt), leakShingleSize),
+	}
+}
+
+// WithDiscountCodes returns a copy of the policy that also allows codes,
+// such as those of the shop's live promotions
+func (p *OutputPolicy) WithDiscountCodes(codes ...string) *OutputPolicy {
+	extended := *p
+	extended.AllowedDiscountCodes = append(append([]string{}, p.AllowedDiscountCodes...), codes...)
+	return &extended
+}
+
+// quotedExamplePattern matches the quoted sample replies in the system prompt
+var quotedExamplePattern = regexp.MustCompile(`“[^”]*”|"[^"]*"`)
+
+// stripExamples removes example lines and quoted sample replies from the
+// system prompt; the model is meant to echo those, so they are not leaks
+func stripExamples(prompt string) string {
+	var kept []string
+	for _, line := range strings.Split(prompt, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "Example") {
+			continue
+		}
+		kept = append(kept, quotedExamplePattern.ReplaceAllString(line, " "))
+	}
+	return strings.Join(kept, "\n")
+}
+
+// CheckOutput screens a model response before it is returned to the shopper
+func (p *OutputPolicy) CheckOutput(text string) Verdict {
+	for shingle := range shingles(text, leakShingleSize) {
+		if p.promptShingles[shingle] {
+			return blocked("system_prompt_leak", fmt.Sprintf("response repeats system prompt text %q", shingle))
+		}
+	}
+
+	lower := strings.ToLower(text)
+	for _, competitor := range p.Competitors {
+		if containsWord(lower, competitor) {
+			return blocked("competitor_mention", fmt.Sprintf("mentions competitor %q", competitor))
+		}
+	}
+
+	for _, match := range discountCodePattern.FindAllStringSubmatch(text, -1) {
+		code := match[1]
+		// Plain words after "code" (e.g. "use the code below") are not codes
+		if strings.ToLower(code) == code && !strings.ContainsAny(code, "0123456789") {
+			continue
+		}
+		if !containsFold(p.AllowedDiscountCodes, code) {
+			return blocked("unknown_discount_code", fmt.Sprintf("offers unissued code %q", code))
+		}
+	}
+
+	return allowed
+}
+
+// SafetySettings returns Gemini safety settings for every harm category at
+// level (low, medium, high or none), defaulting to medium
+func SafetySettings(level string) []*genai.SafetySetting {
+	threshold := genai.HarmBlockMediumAndAbove
+	switch strings.ToLower(level) {
+	case "low":
+		threshold = genai.HarmBlockLowAndAbove
+	case "high":
+		threshold = genai.HarmBlockOnlyHigh
+	case "none":
+		threshold = genai.HarmBlockNone
+	}
+
+	categories := []genai.HarmCategory{
+		genai.HarmCategoryHarassment,
+		genai.HarmCategoryHateSpeech,
+		genai.HarmCategorySexuallyExplicit,
+		genai.HarmCategoryDangerousContent,
+	}
+
+	settings := make([]*genai.SafetySetting, 0, len(categories))
+	for _, category := range categories {
+		settings = append(settings, &genai.SafetySetting{Category: category, Threshold: threshold})
+	}
+	return settings
+}
+
+// BlockedByProvider converts a Gemini safety block into a Verdict
+func BlockedByProvider(err *genai.BlockedError) Verdict {
+	if err.PromptFeedback != nil {
+		return blocked("provider_safety", fmt.Sprintf("prompt blocked: %s", err.PromptFeedback.BlockReason))
+	}
+	if err.Candidate != nil {
+		return blocked("provider_safety", fmt.Sprintf("response blocked: %s", err.Candidate.FinishReason))
+	}
+	return blocked("provider_safety", "blocked by provider")
+}
+
+// RefusalMessage is the brand-voice reply sent in place of a blocked exchange
+func RefusalMessage(v Verdict) string {
+	switch v.Rule {
+	case "prompt_injection", "system_prompt_leak":
+		return "Nice try! 😄 I keep my behind-the-scenes notes to myself. I'm all yours for outfits, sizes, orders and anything fashion though. What are you shopping for today?"
+	case "unknown_discount_code":
+		return "I don't want to send you off with a code that won't work! 🙈 Check our promotions page for the latest deals, or ask me about a specific product and I'll tell you what I know."
+	default:
+		return "Oops, that's a little outside my wardrobe! 👗 I'm here to help with fashion, sizing, orders and returns. Can I help you find something?"
+	}
+}
+
+// LogBlocked records a blocked exchange with its reason. The text is
+// redacted so personal data never reaches the logs.
+func LogBlocked(ctx context.Context, direction, text string, v Verdict) {
+	slog.WarnContext(ctx, "guardrail blocked exchange",
+		slog.String("direction", direction),
+		slog.String("rule", v.Rule),
+		slog.String("reason", redact.Text(v.Reason)),
+		slog.String("text", truncate(redact.Text(text), 200)),
+	)
+}
+
+func shingles(text string, size int) map[string]bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '\'')
+	})
+
+	set := map[string]bool{}
+	for i := 0; i+size <= len(words); i++ {
+		set[strings.Join(words[i:i+size], " ")] = true
+	}
+	return set
+}
+
+func containsWord(lower, phrase string) bool {
+	pattern := `\b` + regexp.QuoteMeta(strings.ToLower(phrase)) + `\b`
+	matched, _ := regexp.MatchString(pattern, lower)
+	return matched
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	return string([]rune(text)[:max]) + "…"
+}
@@ -0,0 +1,42 @@
+// Package lifecycle tracks whether the server is shutting down, so readiness
+// probes can fail early and long-lived streams can finish cleanly.
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	draining atomic.Bool
+
+	shutdownOnce   sync.Once
+	shutdownCtx    context.Context
+	shutdownCancel context.CancelFunc
+)
+
+func init() {
+	shutdownCtx, shutdownCancel = context.WithCancel(context.Background())
+}
+
+// StartDraining marks the server as shutting down. Readiness turns false and
+// ShutdownContext is cancelled so streaming handlers can send a final event
+// and return while in-flight requests finish.
+func StartDraining() {
+	shutdownOnce.Do(func() {
+		draining.Store(true)
+		shutdownCancel()
+	})
+}
+
+// Draining reports whether StartDraining has been called
+func Draining() bool {
+	return draining.Load()
+}
+
+// ShutdownContext is cancelled when the server starts draining. Streaming
+// handlers such as SSE should select on it alongside the request context.
+func ShutdownContext() context.Context {
+	return shutdownCtx
+}
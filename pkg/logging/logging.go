@@ -0,0 +1,147 @@
+// Package logging configures structured JSON logging and carries per-request
+// fields such as the request ID and tenant through the context, so every log
+// line written with a request context can be correlated.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey struct{}
+
+// RequestFields are attached to every log line of a request. Inner layers
+// fill in the tenant once it is known and add upstream cost as it accrues.
+type RequestFields struct {
+	RequestID string
+
+	mu           sync.Mutex
+	tenant       string
+	shopifyCost  float64
+	shopifyCalls int
+	llmTokens    int64
+	llmCalls     int
+}
+
+// SetTenant records the tenant the request belongs to
+func (f *RequestFields) SetTenant(tenant string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tenant = tenant
+}
+
+// Tenant returns the tenant the request belongs to
+func (f *RequestFields) Tenant() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tenant
+}
+
+// AddShopifyCost records one Shopify call and its GraphQL query cost
+func (f *RequestFields) AddShopifyCost(cost float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.shopifyCalls++
+	f.shopifyCost += cost
+}
+
+// AddLLMTokens records one LLM call and the tokens it used
+func (f *RequestFields) AddLLMTokens(tokens int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.llmCalls++
+	f.llmTokens += tokens
+}
+
+// CostAttrs returns the accumulated upstream cost as log attributes
+func (f *RequestFields) CostAttrs() []any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return []any{
+		slog.Int("shopify_calls", f.shopifyCalls),
+		slog.Float64("shopify_query_cost", f.shopifyCost),
+		slog.Int("llm_calls", f.llmCalls),
+		slog.Int64("llm_tokens", f.llmTokens),
+	}
+}
+
+// WithFields stores request fields in ctx
+func WithFields(ctx context.Context, fields *RequestFields) context.Context {
+	return context.WithValue(ctx, contextKey{}, fields)
+}
+
+// Fields returns the request fields stored in ctx, or nil
+func Fields(ctx context.Context) *RequestFields {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(contextKey{}).(*RequestFields)
+	return fields
+}
+
+// RequestID returns the request ID stored in ctx, or ""
+func RequestID(ctx context.Context) string {
+	if fields := Fields(ctx); fields != nil {
+		return fields.RequestID
+	}
+	return ""
+}
+
+// Detach returns a background context carrying the request fields of ctx,
+// for work that outlives the request such as async preference extraction
+func Detach(ctx context.Context) context.Context {
+	if fields := Fields(ctx); fields != nil {
+		return WithFields(context.Background(), fields)
+	}
+	return context.Background()
+}
+
+// contextHandler adds the request ID, tenant and trace IDs from the context
+// to records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if fields := Fields(ctx); fields != nil {
+		record.AddAttrs(slog.String("request_id", fields.RequestID))
+		if tenant := fields.Tenant(); tenant != "" {
+			record.AddAttrs(slog.String("tenant", tenant))
+		}
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Setup installs a JSON slog logger as the default at the named level
+// (debug, info, warn or error). The standard log package is routed through
+// it as well.
+func Setup(levelName string) {
+	level := slog.LevelInfo
+	switch strings.ToLower(levelName) {
+	case "debug":
+		level = slog.LevelDebug
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	}
+
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(contextHandler{handler}))
+}
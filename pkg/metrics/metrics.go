@@ -0,0 +1,215 @@
+// Package metrics defines the Prometheus series exposed on /metrics: HTTP
+// latency per route, Shopify calls and query cost, Redis cache hits, Gemini
+// token usage and active chat sessions. Every series carries a tenant label.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"strategy-fox-go-bd/pkg/logging"
+)
+
+// maxTenants caps the distinct tenant label values when no tenant list is
+// configured, since every configured origin or API key adds a tenant
+const maxTenants = 100
+
+// otherTenant is the label used for tenants beyond the allowed set
+const otherTenant = "other"
+
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"tenant", "route", "method", "status"})
+
+	shopifyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shopify_requests_total",
+		Help: "Shopify GraphQL requests by API and outcome (ok, throttled, error or the HTTP status).",
+	}, []string{"tenant", "api", "status"})
+
+	shopifyRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shopify_request_duration_seconds",
+		Help:    "Shopify GraphQL request latency.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"tenant", "api"})
+
+	shopifyQueryCost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shopify_query_cost_total",
+		Help: "Actual GraphQL query cost reported by Shopify.",
+	}, []string{"tenant", "api"})
+
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_cache_requests_total",
+		Help: "Redis cache lookups by keyspace and result (hit or miss).",
+	}, []string{"tenant", "keyspace", "result"})
+
+	geminiTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gemini_tokens_total",
+		Help: "Gemini tokens from UsageMetadata by call and type (prompt, completion or total).",
+	}, []string{"tenant", "call", "type"})
+
+	geminiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gemini_errors_total",
+		Help: "Gemini calls that failed or stopped early, by finish reason.",
+	}, []string{"tenant", "call", "finish_reason"})
+
+	activeChatSessions = prometheus.NewDesc(
+		"chat_active_sessions",
+		"Chat sessions with a message in the active window.",
+		[]string{"tenant"}, nil,
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequestDuration,
+		shopifyRequests,
+		shopifyRequestDuration,
+		shopifyQueryCost,
+		cacheRequests,
+		geminiTokens,
+		geminiErrors,
+	)
+}
+
+// Handler serves the registered metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+var (
+	tenantsMu      sync.Mutex
+	seenTenants    = map[string]bool{}
+	allowedTenants map[string]bool
+)
+
+// SetAllowedTenants fixes the tenant label values; any other tenant is
+// reported as "other". An empty list keeps the first maxTenants seen.
+func SetAllowedTenants(tenants []string) {
+	tenantsMu.Lock()
+	defer tenantsMu.Unlock()
+
+	allowedTenants = nil
+	if len(tenants) == 0 {
+		return
+	}
+	allowedTenants = map[string]bool{"default": true}
+	for _, t := range tenants {
+		allowedTenants[t] = true
+	}
+}
+
+// TenantLabel bounds the tenant label to the allowed tenants, or to the
+// first maxTenants distinct tenants when none are configured
+func TenantLabel(tenant string) string {
+	if tenant == "" {
+		return "default"
+	}
+
+	tenantsMu.Lock()
+	defer tenantsMu.Unlock()
+	if allowedTenants != nil {
+		if allowedTenants[tenant] {
+			return tenant
+		}
+		return otherTenant
+	}
+
+	if seenTenants[tenant] {
+		return tenant
+	}
+	if len(seenTenants) >= maxTenants {
+		return otherTenant
+	}
+	seenTenants[tenant] = true
+	return tenant
+}
+
+// tenantFromContext returns the tenant label of the request in ctx
+func tenantFromContext(ctx context.Context) string {
+	if fields := logging.Fields(ctx); fields != nil {
+		return TenantLabel(fields.Tenant())
+	}
+	return TenantLabel("")
+}
+
+// ObserveHTTPRequest records one served HTTP request
+func ObserveHTTPRequest(tenant, route, method, status string, duration time.Duration) {
+	httpRequestDuration.WithLabelValues(TenantLabel(tenant), route, method, status).Observe(duration.Seconds())
+}
+
+// ObserveShopifyRequest records one Shopify GraphQL call and its query cost
+func ObserveShopifyRequest(ctx context.Context, api, status string, duration time.Duration, cost float64) {
+	tenant := tenantFromContext(ctx)
+	shopifyRequests.WithLabelValues(tenant, api, status).Inc()
+	shopifyRequestDuration.WithLabelValues(tenant, api).Observe(duration.Seconds())
+	if cost > 0 {
+		shopifyQueryCost.WithLabelValues(tenant, api).Add(cost)
+	}
+}
+
+// ObserveCacheLookup records a cache hit or miss for the keyspace of key,
+// which is its first colon-separated segment
+func ObserveCacheLookup(ctx context.Context, key string, hit bool) {
+	keyspace, _, _ := strings.Cut(key, ":")
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequests.WithLabelValues(tenantFromContext(ctx), keyspace, result).Inc()
+}
+
+// ObserveGeminiTokens records the token usage of a Gemini call
+func ObserveGeminiTokens(ctx context.Context, call string, prompt, completion, total int64) {
+	tenant := tenantFromContext(ctx)
+	geminiTokens.WithLabelValues(tenant, call, "prompt").Add(float64(prompt))
+	geminiTokens.WithLabelValues(tenant, call, "completion").Add(float64(completion))
+	geminiTokens.WithLabelValues(tenant, call, "total").Add(float64(total))
+}
+
+// ObserveGeminiError records a failed or truncated Gemini call
+func ObserveGeminiError(ctx context.Context, call, finishReason string) {
+	geminiErrors.WithLabelValues(tenantFromContext(ctx), call, finishReason).Inc()
+}
+
+// activeSessionsCollector reports active chat sessions per tenant at scrape
+// time, so the gauge reflects every instance sharing the Redis store
+type activeSessionsCollector struct {
+	count func(ctx context.Context) (map[string]int64, error)
+}
+
+func (c activeSessionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeChatSessions
+}
+
+func (c activeSessionsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	counts, err := c.count(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(activeChatSessions, err)
+		return
+	}
+
+	byLabel := map[string]int64{}
+	for tenant, n := range counts {
+		byLabel[TenantLabel(tenant)] += n
+	}
+	for tenant, n := range byLabel {
+		ch <- prometheus.MustNewConstMetric(activeChatSessions, prometheus.GaugeValue, float64(n), tenant)
+	}
+}
+
+// RegisterActiveSessions installs the active chat sessions gauge, computed
+// by count on every scrape
+func RegisterActiveSessions(count func(ctx context.Context) (map[string]int64, error)) {
+	prometheus.MustRegister(activeSessionsCollector{count: count})
+}
@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/logging"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+type contextKey string
+
+const principalContextKey contextKey = "principal"
+
+// TokenClaims are the claims carried by HMAC-signed access tokens
+type TokenClaims struct {
+	Role   string `json:"role"`
+	Tenant string `json:"tenant,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// PrincipalFromContext returns the authenticated caller, if any
+func PrincipalFromContext(ctx context.Context) (*models.Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey).(*models.Principal)
+	return principal, ok
+}
+
+// Authenticate resolves the caller from the Authorization header and stores
+// the principal in the request context. Requests without credentials pass
+// through anonymously; invalid credentials are rejected with 401. API keys
+// are looked up in store, tokens are verified with the secret of cfg.
+func Authenticate(cfg *config.Config, store *models.Store) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			credential := bearerCredential(r)
+			if credential == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal, err := resolvePrincipal(r.Context(), cfg.Auth, store, credential)
+			if err != nil {
+				slog.WarnContext(r.Context(), "authentication failed", slog.Any("error", err))
+				utils.WriteError(w, http.StatusUnauthorized, "unauthorized", "Invalid credentials")
+				return
+			}
+
+			if fields := logging.Fields(r.Context()); fields != nil && principal.Tenant != "" {
+				fields.SetTenant(principal.Tenant)
+			}
+			ctx := context.WithValue(r.Context(), principalContextKey, principal)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireRole rejects requests whose principal does not hold at least role
+func RequireRole(role string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="strategy-fox"`)
+				utils.WriteError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+				return
+			}
+
+			if !models.RoleSatisfies(principal.Role, role) {
+				utils.WriteError(w, http.StatusForbidden, "forbidden", fmt.Sprintf("This endpoint requires the %s role", role))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bearerCredential extracts the credential from "Authorization: Bearer <x>"
+// or the X-API-Key header
+func bearerCredential(r *http.Request) string {
+	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+		return apiKey
+	}
+
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// resolvePrincipal validates a JWT or API key credential
+func resolvePrincipal(ctx context.Context, auth config.AuthConfig, store *models.Store, credential string) (*models.Principal, error) {
+	// JWTs have three dot-separated segments, API keys have none
+	if strings.Count(credential, ".") == 2 {
+		return parseAccessToken(auth, credential)
+	}
+
+	key, err := store.LookupAPIKey(ctx, credential)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown API key")
+	}
+
+	return &models.Principal{Subject: "key:" + key.ID, Role: key.Role, Tenant: key.TenantOrDefault(), Method: "api_key"}, nil
+}
+
+// parseAccessToken verifies an HS256 token signed with the JWT secret
+func parseAccessToken(auth config.AuthConfig, token string) (*models.Principal, error) {
+	secret := auth.JWTSecret.Value()
+	if secret == "" {
+		return nil, fmt.Errorf("AUTH_JWT_SECRET not set")
+	}
+
+	claims := &TokenClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+
+	if !models.ValidRole(claims.Role) {
+		return nil, fmt.Errorf("token has unknown role %q", claims.Role)
+	}
+
+	return &models.Principal{Subject: claims.Subject, Role: claims.Role, Tenant: claims.Tenant, Method: "jwt"}, nil
+}
+
+// SignAccessToken issues an HS256 token for the given subject and role,
+// signed with the secret of auth
+func SignAccessToken(auth config.AuthConfig, subject, role, tenant string, ttl time.Duration) (string, error) {
+	secret := auth.JWTSecret.Value()
+	if secret == "" {
+		return "", fmt.Errorf("AUTH_JWT_SECRET not set")
+	}
+	if !models.ValidRole(role) {
+		return "", fmt.Errorf("unknown role %q", role)
+	}
+
+	now := time.Now()
+	claims := TokenClaims{
+		Role:   role,
+		Tenant: tenant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
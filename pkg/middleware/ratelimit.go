@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/utils"
+)
+
+// RateLimit is a sliding-window quota applied to a group of routes
+type RateLimit struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// PerMinute builds a per-minute quota
+func PerMinute(name string, limit int) RateLimit {
+	return RateLimit{Name: name, Limit: limit, Window: time.Minute}
+}
+
+// slidingWindowScript checks every key's window before recording anything:
+// it trims entries older than the window, and only when all keys are under
+// the limit adds the request to each of them. It returns {allowed, count,
+// resetAtMillis} for the fullest key, so a request refused by one identity
+// never uses up the quota of another.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local allowed = 1
+local fullest = KEYS[1]
+local count = -1
+for _, key in ipairs(KEYS) do
+	redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+	local n = redis.call('ZCARD', key)
+	if n >= limit then
+		allowed = 0
+	end
+	if n > count then
+		fullest = key
+		count = n
+	end
+end
+if allowed == 1 then
+	for _, key in ipairs(KEYS) do
+		redis.call('ZADD', key, now, ARGV[4])
+		redis.call('PEXPIRE', key, window)
+	end
+	count = count + 1
+end
+local reset = now + window
+local oldest = redis.call('ZRANGE', fullest, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window
+end
+return {allowed, count, reset}
+`)
+
+type rateLimitResult struct {
+	allowed   bool
+	remaining int
+	resetAt   time.Time
+}
+
+// rateLimitKey names the window of one identity. The limit name is a hash
+// tag so every key of a check lands in the same Redis Cluster slot.
+func rateLimitKey(limit RateLimit, identity string) string {
+	return fmt.Sprintf("ratelimit:{%s}:%s", limit.Name, identity)
+}
+
+// RateLimitMiddleware enforces limit separately for the caller's IP, session
+// (X-Session-ID) and API key or token subject. A request is only counted
+// when every identity is under the limit, and the most restrictive identity
+// decides the X-RateLimit-* headers. Windows are kept in client; Redis
+// failures fail open.
+func RateLimitMiddleware(client redis.UniversalClient, limit RateLimit) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if client == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			keys := []string{rateLimitKey(limit, "ip:"+ClientIP(r))}
+			if session := r.Header.Get("X-Session-ID"); session != "" {
+				keys = append(keys, rateLimitKey(limit, "session:"+session))
+			}
+			if principal, ok := PrincipalFromContext(r.Context()); ok {
+				keys = append(keys, rateLimitKey(limit, "principal:"+principal.Subject))
+			}
+
+			result, err := checkRateLimit(r, client, keys, limit)
+			if err != nil {
+				slog.WarnContext(r.Context(), "rate limit check failed", slog.String("limit", limit.Name), slog.Any("error", err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(result.resetAt.Unix(), 10))
+
+			if !result.allowed {
+				retryAfter := int(time.Until(result.resetAt).Seconds()) + 1
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				utils.WriteError(w, http.StatusTooManyRequests, "rate_limited", "Too many requests, please slow down")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func checkRateLimit(r *http.Request, client redis.UniversalClient, keys []string, limit RateLimit) (*rateLimitResult, error) {
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%d-%d", now, rand.Int63())
+
+	values, err := slidingWindowScript.Run(r.Context(), client, keys,
+		now, limit.Window.Milliseconds(), limit.Limit, member).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := limit.Limit - int(values[1])
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &rateLimitResult{
+		allowed:   values[0] == 1,
+		remaining: remaining,
+		resetAt:   time.UnixMilli(values[2]),
+	}, nil
+}
+
+// LimitBody caps request bodies at maxBytes. Handlers see a
+// *http.MaxBytesError when reading past the limit.
+func LimitBody(maxBytes int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				utils.WriteError(w, http.StatusRequestEntityTooLarge, "payload_too_large",
+					fmt.Sprintf("Request body must not exceed %d bytes", maxBytes))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"strategy-fox-go-bd/pkg/testsupport"
+)
+
+// rateLimited serves 200 behind a RateLimitMiddleware of limit per minute
+// backed by client
+func rateLimited(client redis.UniversalClient, limit int) http.Handler {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return RateLimitMiddleware(client, PerMinute("test", limit))(ok)
+}
+
+// send makes a request from ip, with session as X-Session-ID when set
+func send(handler http.Handler, ip, session string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = ip + ":4321"
+	if session != "" {
+		req.Header.Set("X-Session-ID", session)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	client, _ := testsupport.NewFakeRedis(t)
+	handler := rateLimited(client, 2)
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		rec := send(handler, "192.0.2.1", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want 200", i+1, rec.Code)
+		}
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("X-RateLimit-Limit = %q, want 2", got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != wantRemaining {
+			t.Errorf("request %d X-RateLimit-Remaining = %q, want %s", i+1, got, wantRemaining)
+		}
+		reset, err := strconv.ParseInt(rec.Header().Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil || reset < time.Now().Unix() || reset > time.Now().Add(time.Minute).Unix()+1 {
+			t.Errorf("X-RateLimit-Reset = %q, want within the next minute", rec.Header().Get("X-RateLimit-Reset"))
+		}
+	}
+
+	rec := send(handler, "192.0.2.1", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 61 {
+		t.Errorf("Retry-After = %q, want 1 to 61 seconds", rec.Header().Get("Retry-After"))
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error.Code != "rate_limited" {
+		t.Errorf("body code = %q, %v; want rate_limited", body.Error.Code, err)
+	}
+}
+
+func TestRateLimitCountsOnlyAdmittedRequests(t *testing.T) {
+	client, _ := testsupport.NewFakeRedis(t)
+	handler := rateLimited(client, 2)
+
+	for i := 0; i < 2; i++ {
+		if rec := send(handler, "192.0.2.1", "session-1"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want 200", i+1, rec.Code)
+		}
+	}
+
+	// The session is spent, so a new IP using it is refused without the
+	// refusal counting against the new IP
+	if rec := send(handler, "192.0.2.2", "session-1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429 for the spent session", rec.Code)
+	}
+	rec := send(handler, "192.0.2.2", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "1" {
+		t.Errorf("status = %d, remaining = %q; want the new IP's quota untouched", rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+}
+
+func TestRateLimitFailsOpen(t *testing.T) {
+	client, server := testsupport.NewFakeRedis(t)
+	handler := rateLimited(client, 1)
+	server.Close()
+
+	rec := send(handler, "192.0.2.1", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("status = %d, headers = %v; want the request served without rate limit headers", rec.Code, rec.Header())
+	}
+}
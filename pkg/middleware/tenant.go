@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/models"
+)
+
+const clientContextKey contextKey = "client"
+
+// clientInfo is what ClientContext resolved about the caller
+type clientInfo struct {
+	IP           string
+	OriginTenant string
+}
+
+// ClientContext resolves the caller's IP address and origin tenant with the
+// server configuration and stores them in the request context for ClientIP
+// and TenantFromRequest. It must run before the other middleware.
+func ClientContext(cfg *config.Config) mux.MiddlewareFunc {
+	originTenants := cfg.Server.OriginTenants()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := &clientInfo{IP: remoteIP(r), OriginTenant: models.DefaultTenant}
+			if cfg.Server.TrustProxyHeaders {
+				if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+					info.IP = strings.TrimSpace(strings.SplitN(forwarded, ",", 2)[0])
+				}
+			}
+			if origin := strings.TrimRight(r.Header.Get("Origin"), "/"); origin != "" {
+				if tenant, ok := originTenants[origin]; ok {
+					info.OriginTenant = tenant
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), clientContextKey, info)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// TenantFromRequest resolves the tenant of a request from the authenticated
+// principal, then the server's origin to tenant map, falling back to
+// "default". Anonymous callers cannot name a tenant themselves, since the
+// tenant selects token budgets, return policies and promotions. A principal
+// without a tenant belongs to the default tenant, whatever its origin.
+func TenantFromRequest(r *http.Request) string {
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		if principal.Tenant != "" {
+			return principal.Tenant
+		}
+		return models.DefaultTenant
+	}
+	if info, ok := r.Context().Value(clientContextKey).(*clientInfo); ok {
+		return info.OriginTenant
+	}
+	return models.DefaultTenant
+}
+
+// ClientIP returns the caller's IP address. X-Forwarded-For is only honoured
+// when ClientContext runs with trusted proxy headers enabled, since clients
+// can set it freely.
+func ClientIP(r *http.Request) string {
+	if info, ok := r.Context().Value(clientContextKey).(*clientInfo); ok {
+		return info.IP
+	}
+	return remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"strategy-fox-go-bd/pkg/config"
+)
+
+func TestClientContext(t *testing.T) {
+	cases := []struct {
+		name       string
+		trustProxy bool
+		origin     string
+		wantIP     string
+		wantTenant string
+	}{
+		{name: "untrusted proxy header", wantIP: "192.0.2.1", wantTenant: "default"},
+		{name: "trusted proxy header", trustProxy: true, wantIP: "198.51.100.7", wantTenant: "default"},
+		{name: "mapped origin", origin: "https://doodad.example.com/", wantIP: "192.0.2.1", wantTenant: "doodad"},
+		{name: "unknown origin", origin: "https://evil.example.com", wantIP: "192.0.2.1", wantTenant: "default"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Defaults()
+			cfg.Server.TrustProxyHeaders = tc.trustProxy
+			cfg.Server.TenantOrigins = []string{"https://doodad.example.com=doodad"}
+
+			var ip, tenant string
+			handler := ClientContext(&cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip, tenant = ClientIP(r), TenantFromRequest(r)
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "192.0.2.1:4321"
+			req.Header.Set("X-Forwarded-For", "198.51.100.7, 192.0.2.1")
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if ip != tc.wantIP || tenant != tc.wantTenant {
+				t.Errorf("ip, tenant = %q, %q; want %q, %q", ip, tenant, tc.wantIP, tc.wantTenant)
+			}
+		})
+	}
+}
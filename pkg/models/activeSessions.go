@@ -0,0 +1,64 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ActiveSessionWindow is how recently a session must have chatted to count
+// as active
+const ActiveSessionWindow = 15 * time.Minute
+
+const activeTenantsKey = "chat:active:tenants"
+
+func activeSessionsKey(tenant string) string {
+	return "chat:active:" + tenant
+}
+
+// TouchActiveSession marks a chat session of tenant as active now
+func (s *Store) TouchActiveSession(ctx context.Context, tenant, sessionID string) error {
+	if s.client == nil {
+		return nil
+	}
+
+	now := time.Now()
+	key := activeSessionsKey(tenant)
+	pipe := s.client.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.Unix()), Member: sessionID})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-ActiveSessionWindow).Unix(), 10))
+	pipe.Expire(ctx, key, ActiveSessionWindow)
+	pipe.SAdd(ctx, activeTenantsKey, tenant)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to mark session active: %v", err)
+	}
+	return nil
+}
+
+// CountActiveSessions returns the number of active chat sessions per tenant
+func (s *Store) CountActiveSessions(ctx context.Context) (map[string]int64, error) {
+	counts := map[string]int64{}
+	if s.client == nil {
+		return counts, nil
+	}
+
+	tenants, err := s.client.SMembers(ctx, activeTenantsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active tenants: %v", err)
+	}
+
+	since := strconv.FormatInt(time.Now().Add(-ActiveSessionWindow).Unix(), 10)
+	for _, tenant := range tenants {
+		n, err := s.client.ZCount(ctx, activeSessionsKey(tenant), since, "+inf").Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to count active sessions: %v", err)
+		}
+		if n > 0 {
+			counts[tenant] = n
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,565 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"strategy-fox-go-bd/pkg/config"
+)
+
+// Chat turn outcomes
+const (
+	OutcomeAnswered = "answered"
+	OutcomeBlocked  = "blocked"
+	OutcomeError    = "error"
+)
+
+// ChatEvent is the analytics record of one chat turn. It holds no message
+// text, and the session is only stored as a hash so events carry no shopper
+// data.
+type ChatEvent struct {
+	At               time.Time `json:"at"`
+	Tenant           string    `json:"tenant"`
+	SessionHash      string    `json:"sessionHash"`
+	Intent           string    `json:"intent,omitempty"`
+	Products         []string  `json:"products,omitempty"`
+	ToolCalls        []string  `json:"toolCalls,omitempty"`
+	LatencyMs        int64     `json:"latencyMs"`
+	Outcome          string    `json:"outcome"`
+	Escalated        bool      `json:"escalated,omitempty"`
+	PromptTokens     int64     `json:"promptTokens"`
+	CompletionTokens int64     `json:"completionTokens"`
+	TotalTokens      int64     `json:"totalTokens"`
+}
+
+// AnalyticsStore is an append-only log of chat events. Events are only
+// removed for GDPR erasures.
+type AnalyticsStore interface {
+	Append(ctx context.Context, event ChatEvent) error
+	// Range returns tenant's events with from <= At < to, oldest first
+	Range(ctx context.Context, tenant string, from, to time.Time) ([]ChatEvent, error)
+	// SessionEvents returns the events of the hashed sessions in every
+	// tenant
+	SessionEvents(ctx context.Context, sessionHashes []string) ([]ChatEvent, error)
+	// DeleteSessionEvents removes the events of the hashed sessions
+	DeleteSessionEvents(ctx context.Context, sessionHashes []string) error
+}
+
+// NewAnalyticsStore returns the store selected by cfg.Sink, or nil for
+// "none" or when the Redis sink has no client
+func NewAnalyticsStore(cfg config.AnalyticsConfig, client redis.UniversalClient) AnalyticsStore {
+	switch strings.ToLower(cfg.Sink) {
+	case "redis":
+		if client == nil {
+			return nil
+		}
+		return &redisAnalyticsStore{client: client, retention: cfg.Retention}
+	case "file":
+		return &fileAnalyticsStore{dir: cfg.Dir}
+	}
+	return nil
+}
+
+// redisAnalyticsStore keeps one stream per tenant. Entry IDs are the event
+// time, so a date range maps directly onto XRANGE. Each session also has a
+// set of the entries it wrote, so GDPR requests find them without scanning
+// the keyspace, which only covers one node of a Redis Cluster.
+type redisAnalyticsStore struct {
+	client    redis.UniversalClient
+	retention time.Duration
+}
+
+// analyticsRangePage bounds each XRANGE call when reading a date range
+const analyticsRangePage = 1000
+
+func analyticsStreamKey(tenant string) string {
+	return "analytics:chat:" + tenant
+}
+
+// analyticsSessionKey is the set of "<entry ID> <stream key>" members of the
+// events of a hashed session
+func analyticsSessionKey(sessionHash string) string {
+	return "analytics:session:" + sessionHash
+}
+
+func (s *redisAnalyticsStore) Append(ctx context.Context, event ChatEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode chat event: %v", err)
+	}
+
+	args := &redis.XAddArgs{
+		Stream: analyticsStreamKey(event.Tenant),
+		Values: map[string]interface{}{"event": data},
+	}
+	if s.retention > 0 {
+		args.MinID = strconv.FormatInt(time.Now().Add(-s.retention).UnixMilli(), 10)
+		args.Approx = true
+	}
+	id, err := s.client.XAdd(ctx, args).Result()
+	if err != nil {
+		return fmt.Errorf("failed to store chat event: %v", err)
+	}
+
+	indexKey := analyticsSessionKey(event.SessionHash)
+	if err := s.client.SAdd(ctx, indexKey, id+" "+args.Stream).Err(); err != nil {
+		return fmt.Errorf("failed to index chat event: %v", err)
+	}
+	if s.retention > 0 {
+		if err := s.client.Expire(ctx, indexKey, s.retention).Err(); err != nil {
+			return fmt.Errorf("failed to index chat event: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *redisAnalyticsStore) Range(ctx context.Context, tenant string, from, to time.Time) ([]ChatEvent, error) {
+	key := analyticsStreamKey(tenant)
+	start := strconv.FormatInt(from.UnixMilli(), 10)
+	end := strconv.FormatInt(to.UnixMilli()-1, 10)
+
+	var events []ChatEvent
+	for {
+		messages, err := s.client.XRangeN(ctx, key, start, end, analyticsRangePage).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chat events: %v", err)
+		}
+		for _, message := range messages {
+			raw, _ := message.Values["event"].(string)
+			var event ChatEvent
+			if err := json.Unmarshal([]byte(raw), &event); err == nil {
+				events = append(events, event)
+			}
+		}
+		if len(messages) < analyticsRangePage {
+			return events, nil
+		}
+		// Continue after the last entry; "(" makes the start exclusive
+		start = "(" + messages[len(messages)-1].ID
+	}
+}
+
+// streamSessionEvents calls fn with the stream key, ID and event of every
+// indexed entry of the hashed sessions. Entries trimmed by retention are
+// skipped.
+func (s *redisAnalyticsStore) streamSessionEvents(ctx context.Context, hashes []string, fn func(key, id string, event ChatEvent)) error {
+	for _, hash := range hashes {
+		members, err := s.client.SMembers(ctx, analyticsSessionKey(hash)).Result()
+		if err != nil {
+			return fmt.Errorf("failed to list chat events: %v", err)
+		}
+		sort.Strings(members)
+		for _, member := range members {
+			id, key, ok := strings.Cut(member, " ")
+			if !ok {
+				continue
+			}
+			messages, err := s.client.XRangeN(ctx, key, id, id, 1).Result()
+			if err != nil {
+				return fmt.Errorf("failed to read chat events: %v", err)
+			}
+			for _, message := range messages {
+				raw, _ := message.Values["event"].(string)
+				var event ChatEvent
+				if err := json.Unmarshal([]byte(raw), &event); err == nil && event.SessionHash == hash {
+					fn(key, message.ID, event)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *redisAnalyticsStore) SessionEvents(ctx context.Context, sessionHashes []string) ([]ChatEvent, error) {
+	events := []ChatEvent{}
+	err := s.streamSessionEvents(ctx, sessionHashes, func(key, id string, event ChatEvent) {
+		events = append(events, event)
+	})
+	return events, err
+}
+
+func (s *redisAnalyticsStore) DeleteSessionEvents(ctx context.Context, sessionHashes []string) error {
+	ids := map[string][]string{}
+	err := s.streamSessionEvents(ctx, sessionHashes, func(key, id string, event ChatEvent) {
+		ids[key] = append(ids[key], id)
+	})
+	if err != nil {
+		return err
+	}
+	for key, keyIDs := range ids {
+		if err := s.client.XDel(ctx, key, keyIDs...).Err(); err != nil {
+			return fmt.Errorf("failed to delete chat events: %v", err)
+		}
+	}
+	for _, hash := range sessionHashes {
+		if err := s.client.Del(ctx, analyticsSessionKey(hash)).Err(); err != nil {
+			return fmt.Errorf("failed to delete chat event index: %v", err)
+		}
+	}
+	return nil
+}
+
+func hashSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// fileAnalyticsStore appends events to dir/<tenant>/<YYYY-MM-DD>.jsonl
+type fileAnalyticsStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func (s *fileAnalyticsStore) path(tenant string, day time.Time) string {
+	return filepath.Join(s.dir, filepath.Base(tenant), day.UTC().Format("2006-01-02")+".jsonl")
+}
+
+func (s *fileAnalyticsStore) Append(ctx context.Context, event ChatEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode chat event: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(event.Tenant, event.At)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create analytics directory: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open analytics file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to store chat event: %v", err)
+	}
+	return nil
+}
+
+func (s *fileAnalyticsStore) Range(ctx context.Context, tenant string, from, to time.Time) ([]ChatEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []ChatEvent
+	for day := from.UTC().Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+		f, err := os.Open(s.path(tenant, day))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open analytics file: %v", err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var event ChatEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				continue
+			}
+			if !event.At.Before(from) && event.At.Before(to) {
+				events = append(events, event)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read analytics file: %v", err)
+		}
+	}
+	return events, nil
+}
+
+// eventFiles returns the paths of every tenant's event files
+func (s *fileAnalyticsStore) eventFiles() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*", "*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list analytics files: %v", err)
+	}
+	return paths, nil
+}
+
+func (s *fileAnalyticsStore) SessionEvents(ctx context.Context, sessionHashes []string) ([]ChatEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths, err := s.eventFiles()
+	if err != nil {
+		return nil, err
+	}
+	hashes := hashSet(sessionHashes)
+	events := []ChatEvent{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read analytics file: %v", err)
+		}
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			var event ChatEvent
+			if json.Unmarshal(line, &event) == nil && hashes[event.SessionHash] {
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
+
+// DeleteSessionEvents rewrites each file holding the sessions' events
+// without them, replacing it atomically
+func (s *fileAnalyticsStore) DeleteSessionEvents(ctx context.Context, sessionHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths, err := s.eventFiles()
+	if err != nil {
+		return err
+	}
+	hashes := hashSet(sessionHashes)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read analytics file: %v", err)
+		}
+
+		var kept bytes.Buffer
+		removed := false
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			var event ChatEvent
+			if json.Unmarshal(line, &event) == nil && hashes[event.SessionHash] {
+				removed = true
+				continue
+			}
+			kept.Write(line)
+			kept.WriteByte('\n')
+		}
+		if !removed {
+			continue
+		}
+
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, kept.Bytes(), 0o640); err != nil {
+			return fmt.Errorf("failed to rewrite analytics file: %v", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return fmt.Errorf("failed to rewrite analytics file: %v", err)
+		}
+	}
+	return nil
+}
+
+// AnalyticsDataSource exposes the events of a shopper's sessions in store
+// to GDPR exports and erasures. Events live in shared streams or files, so
+// they are erased entry by entry rather than by key.
+func AnalyticsDataSource(store AnalyticsStore) ShopperDataSource {
+	sessionHashes := func(id ShopperIdentity) []string {
+		hashes := make([]string, 0, len(id.SessionIDs))
+		for _, sessionID := range id.SessionIDs {
+			hashes = append(hashes, HashIdentifier(sessionID))
+		}
+		return hashes
+	}
+	return ShopperDataSource{
+		Name: "analyticsEvents",
+		Export: func(ctx context.Context, id ShopperIdentity) (interface{}, error) {
+			return store.SessionEvents(ctx, sessionHashes(id))
+		},
+		Erase: func(ctx context.Context, id ShopperIdentity) error {
+			return store.DeleteSessionEvents(ctx, sessionHashes(id))
+		},
+		Remaining: func(ctx context.Context, id ShopperIdentity) (int, error) {
+			events, err := store.SessionEvents(ctx, sessionHashes(id))
+			return len(events), err
+		},
+	}
+}
+
+// AnalyticsCount is a ranked value and how often it occurred
+type AnalyticsCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// AnalyticsDay is the activity of one UTC day
+type AnalyticsDay struct {
+	Date          string `json:"date"`
+	Turns         int    `json:"turns"`
+	Conversations int    `json:"conversations"`
+	Tokens        int64  `json:"tokens"`
+}
+
+// AnalyticsReport aggregates the chat events of a tenant over a date range.
+// A conversation is resolved when none of its turns escalated or failed.
+type AnalyticsReport struct {
+	Tenant                string           `json:"tenant"`
+	From                  time.Time        `json:"from"`
+	To                    time.Time        `json:"to"`
+	Turns                 int              `json:"turns"`
+	Conversations         int              `json:"conversations"`
+	ResolvedConversations int              `json:"resolvedConversations"`
+	ResolutionRate        float64          `json:"resolutionRate"`
+	EscalatedTurns        int              `json:"escalatedTurns"`
+	BlockedTurns          int              `json:"blockedTurns"`
+	FailedTurns           int              `json:"failedTurns"`
+	AvgLatencyMs          float64          `json:"avgLatencyMs"`
+	P95LatencyMs          int64            `json:"p95LatencyMs"`
+	PromptTokens          int64            `json:"promptTokens"`
+	CompletionTokens      int64            `json:"completionTokens"`
+	TotalTokens           int64            `json:"totalTokens"`
+	TokensPerConversation float64          `json:"tokensPerConversation"`
+	CostUSD               float64          `json:"costUsd"`
+	CostPerConversation   float64          `json:"costPerConversationUsd"`
+	TopIntents            []AnalyticsCount `json:"topIntents"`
+	TopProducts           []AnalyticsCount `json:"topProducts"`
+	TopTools              []AnalyticsCount `json:"topTools"`
+	Daily                 []AnalyticsDay   `json:"daily"`
+}
+
+// AggregateChatEvents builds the report of events, ranking at most top
+// entries per list. Prices are in USD per million tokens.
+func AggregateChatEvents(events []ChatEvent, top int, prices config.AnalyticsConfig) AnalyticsReport {
+	report := AnalyticsReport{
+		TopIntents:  []AnalyticsCount{},
+		TopProducts: []AnalyticsCount{},
+		TopTools:    []AnalyticsCount{},
+		Daily:       []AnalyticsDay{},
+	}
+
+	intents := map[string]int{}
+	products := map[string]int{}
+	tools := map[string]int{}
+	resolved := map[string]bool{}
+	days := map[string]*AnalyticsDay{}
+	daySessions := map[string]map[string]bool{}
+	latencies := make([]int64, 0, len(events))
+	var latencyTotal int64
+
+	for _, event := range events {
+		report.Turns++
+		report.PromptTokens += event.PromptTokens
+		report.CompletionTokens += event.CompletionTokens
+		report.TotalTokens += event.TotalTokens
+		latencies = append(latencies, event.LatencyMs)
+		latencyTotal += event.LatencyMs
+
+		intent := event.Intent
+		if intent == "" {
+			intent = "unknown"
+		}
+		intents[intent]++
+		for _, product := range uniqueStrings(event.Products) {
+			products[product]++
+		}
+		for _, tool := range event.ToolCalls {
+			tools[tool]++
+		}
+
+		failed := false
+		switch event.Outcome {
+		case OutcomeBlocked:
+			report.BlockedTurns++
+		case OutcomeError:
+			report.FailedTurns++
+			failed = true
+		}
+		if event.Escalated {
+			report.EscalatedTurns++
+		}
+		if ok, seen := resolved[event.SessionHash]; !seen || ok {
+			resolved[event.SessionHash] = !event.Escalated && !failed
+		}
+
+		date := event.At.UTC().Format("2006-01-02")
+		day, ok := days[date]
+		if !ok {
+			day = &AnalyticsDay{Date: date}
+			days[date] = day
+			daySessions[date] = map[string]bool{}
+		}
+		day.Turns++
+		day.Tokens += event.TotalTokens
+		daySessions[date][event.SessionHash] = true
+	}
+
+	report.Conversations = len(resolved)
+	for _, ok := range resolved {
+		if ok {
+			report.ResolvedConversations++
+		}
+	}
+	if report.Conversations > 0 {
+		report.ResolutionRate = float64(report.ResolvedConversations) / float64(report.Conversations)
+		report.TokensPerConversation = float64(report.TotalTokens) / float64(report.Conversations)
+	}
+	if report.Turns > 0 {
+		report.AvgLatencyMs = float64(latencyTotal) / float64(report.Turns)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		report.P95LatencyMs = latencies[(len(latencies)*95+99)/100-1]
+	}
+
+	report.CostUSD = (float64(report.PromptTokens)*prices.PromptTokenPrice + float64(report.CompletionTokens)*prices.CompletionTokenPrice) / 1e6
+	if report.Conversations > 0 {
+		report.CostPerConversation = report.CostUSD / float64(report.Conversations)
+	}
+
+	report.TopIntents = topCounts(intents, top)
+	report.TopProducts = topCounts(products, top)
+	report.TopTools = topCounts(tools, top)
+
+	for date, day := range days {
+		day.Conversations = len(daySessions[date])
+		report.Daily = append(report.Daily, *day)
+	}
+	sort.Slice(report.Daily, func(i, j int) bool { return report.Daily[i].Date < report.Daily[j].Date })
+
+	return report
+}
+
+// topCounts ranks counts by frequency, then name, keeping at most n
+func topCounts(counts map[string]int, n int) []AnalyticsCount {
+	ranked := make([]AnalyticsCount, 0, len(counts))
+	for name, count := range counts {
+		ranked = append(ranked, AnalyticsCount{Name: name, Count: count})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Name < ranked[j].Name
+	})
+	if n > 0 && len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
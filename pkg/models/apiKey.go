@@ -0,0 +1,211 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DefaultTenant is the tenant of requests and keys that name none
+const DefaultTenant = "default"
+
+const (
+	RoleShopper = "shopper"
+	RoleAgent   = "agent"
+	RoleAdmin   = "admin"
+)
+
+// roleRank orders roles so that a higher role satisfies a lower requirement
+var roleRank = map[string]int{
+	RoleShopper: 1,
+	RoleAgent:   2,
+	RoleAdmin:   3,
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// RoleSatisfies reports whether role grants at least the access of required
+func RoleSatisfies(role, required string) bool {
+	return ValidRole(role) && roleRank[role] >= roleRank[required]
+}
+
+// Principal is the authenticated caller attached to a request
+type Principal struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+	Tenant  string `json:"tenant,omitempty"`
+	Method  string `json:"method"`
+}
+
+// APIKey is the stored metadata of an API key. The secret itself is never
+// stored; keys are looked up by the SHA-256 hash of the presented secret.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TenantOrDefault returns the key's tenant, treating keys created without
+// one as belonging to DefaultTenant
+func (k APIKey) TenantOrDefault() string {
+	if k.Tenant == "" {
+		return DefaultTenant
+	}
+	return k.Tenant
+}
+
+const (
+	apiKeyIndexKey  = "apikeys"
+	apiKeyKeyPrefix = "apikey:"
+	apiKeyPrefix    = "sfk_"
+)
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateAPIKey generates and stores a new API key, returning the plaintext
+// secret once. An empty tenant creates a DefaultTenant key.
+func (s *Store) CreateAPIKey(ctx context.Context, name, role, tenant string) (string, *APIKey, error) {
+	if !ValidRole(role) {
+		return "", nil, fmt.Errorf("unknown role %q", role)
+	}
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate key id: %v", err)
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate key secret: %v", err)
+	}
+	secret = apiKeyPrefix + secret
+
+	key := &APIKey{
+		ID:        id,
+		Name:      name,
+		Role:      role,
+		Tenant:    tenant,
+		Hash:      hashAPIKey(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode key: %v", err)
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, apiKeyKeyPrefix+key.Hash, data, 0)
+	pipe.HSet(ctx, apiKeyIndexKey, key.ID, key.Hash)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", nil, fmt.Errorf("failed to store key: %v", err)
+	}
+
+	return secret, key, nil
+}
+
+// LookupAPIKey returns the key matching secret, or nil when it is unknown
+func (s *Store) LookupAPIKey(ctx context.Context, secret string) (*APIKey, error) {
+	data, err := s.client.Get(ctx, apiKeyKeyPrefix+hashAPIKey(secret)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key: %v", err)
+	}
+
+	var key APIKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("failed to decode key: %v", err)
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns the keys of tenant ordered by creation time. An empty
+// tenant lists every tenant's keys, for the operator CLI.
+func (s *Store) ListAPIKeys(ctx context.Context, tenant string) ([]APIKey, error) {
+	hashes, err := s.client.HVals(ctx, apiKeyIndexKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %v", err)
+	}
+
+	keys := []APIKey{}
+	for _, hash := range hashes {
+		data, err := s.client.Get(ctx, apiKeyKeyPrefix+hash).Bytes()
+		if err != nil {
+			continue
+		}
+		var key APIKey
+		if err := json.Unmarshal(data, &key); err == nil && (tenant == "" || key.TenantOrDefault() == tenant) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// RevokeAPIKey deletes the key of tenant with the given ID, reporting
+// whether it existed. Another tenant's key is reported as missing. An empty
+// tenant revokes a key of any tenant, for the operator CLI.
+func (s *Store) RevokeAPIKey(ctx context.Context, tenant, id string) (bool, error) {
+	hash, err := s.client.HGet(ctx, apiKeyIndexKey, id).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load key: %v", err)
+	}
+
+	if tenant != "" {
+		data, err := s.client.Get(ctx, apiKeyKeyPrefix+hash).Bytes()
+		if err == redis.Nil {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to load key: %v", err)
+		}
+		var key APIKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			return false, fmt.Errorf("failed to decode key: %v", err)
+		}
+		if key.TenantOrDefault() != tenant {
+			return false, nil
+		}
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, apiKeyKeyPrefix+hash)
+	pipe.HDel(ctx, apiKeyIndexKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to revoke key: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,310 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Back-in-stock subscription statuses
+const (
+	BackInStockActive   = "active"
+	BackInStockNotified = "notified"
+)
+
+// backInStockDeliveryTTL bounds a delivery claim, so a crashed delivery is
+// retried on a later restock or poll
+const backInStockDeliveryTTL = 10 * time.Minute
+
+// BackInStockSubscription asks for one notification when a variant is back
+// in stock. Channel is email or webhook and Target the address or URL.
+// Token authorizes the unsubscribe link.
+type BackInStockSubscription struct {
+	ID              string     `json:"id"`
+	Token           string     `json:"token"`
+	Tenant          string     `json:"tenant,omitempty"`
+	VariantID       string     `json:"variantId"`
+	InventoryItemID string     `json:"inventoryItemId"`
+	ProductID       string     `json:"productId"`
+	ProductHandle   string     `json:"productHandle"`
+	ProductTitle    string     `json:"productTitle"`
+	VariantTitle    string     `json:"variantTitle"`
+	Channel         string     `json:"channel"`
+	Target          string     `json:"target"`
+	Status          string     `json:"status"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	NotifiedAt      *time.Time `json:"notifiedAt,omitempty"`
+}
+
+func backInStockKey(id string) string {
+	return "backinstock:sub:" + id
+}
+
+func backInStockItemKey(itemID string) string {
+	return "backinstock:item:" + NumericIDFromGID(itemID)
+}
+
+// backInStockItemsKey lists the inventory items with subscribers, for the
+// poller
+const backInStockItemsKey = "backinstock:items"
+
+func backInStockTargetKey(target string) string {
+	return "backinstock:target:" + HashIdentifier(target)
+}
+
+func backInStockDedupeKey(variantID, target string) string {
+	return "backinstock:dedupe:" + NumericIDFromGID(variantID) + ":" + HashIdentifier(target)
+}
+
+func backInStockDeliveryKey(id string) string {
+	return "backinstock:delivery:" + id
+}
+
+func backInStockRegistrationsKey(target string) string {
+	return "backinstock:registrations:" + HashIdentifier(target)
+}
+
+// backInStockRegistrationWindow is the fixed window registrations per
+// target are counted in
+const backInStockRegistrationWindow = 24 * time.Hour
+
+// CountBackInStockRegistration records a registration attempt for target
+// and returns the attempts in the current day, including this one
+func (s *Store) CountBackInStockRegistration(ctx context.Context, target string) (int64, error) {
+	key := backInStockRegistrationsKey(target)
+	count, err := s.client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count back-in-stock registrations: %v", err)
+	}
+	if count == 1 {
+		if err := s.client.Expire(ctx, key, backInStockRegistrationWindow).Err(); err != nil {
+			return 0, fmt.Errorf("failed to count back-in-stock registrations: %v", err)
+		}
+	}
+	return count, nil
+}
+
+// SaveBackInStockSubscription stores a new subscription for ttl. A target
+// already subscribed to the variant gets its existing subscription back,
+// with created false.
+func (s *Store) SaveBackInStockSubscription(ctx context.Context, sub BackInStockSubscription, ttl time.Duration) (BackInStockSubscription, bool, error) {
+	dedupeKey := backInStockDedupeKey(sub.VariantID, sub.Target)
+	if existingID, err := s.client.Get(ctx, dedupeKey).Result(); err == nil {
+		existing, err := s.GetBackInStockSubscription(ctx, existingID)
+		if err != nil {
+			return BackInStockSubscription{}, false, err
+		}
+		if existing != nil && existing.Status == BackInStockActive {
+			return *existing, false, nil
+		}
+	} else if err != redis.Nil {
+		return BackInStockSubscription{}, false, fmt.Errorf("failed to load back-in-stock subscription: %v", err)
+	}
+
+	var err error
+	if sub.ID, err = randomHex(12); err != nil {
+		return BackInStockSubscription{}, false, fmt.Errorf("failed to generate subscription id: %v", err)
+	}
+	if sub.Token, err = randomHex(16); err != nil {
+		return BackInStockSubscription{}, false, fmt.Errorf("failed to generate unsubscribe token: %v", err)
+	}
+	sub.Status = BackInStockActive
+	sub.CreatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return BackInStockSubscription{}, false, fmt.Errorf("failed to encode back-in-stock subscription: %v", err)
+	}
+
+	itemKey := backInStockItemKey(sub.InventoryItemID)
+	targetKey := backInStockTargetKey(sub.Target)
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, backInStockKey(sub.ID), data, ttl)
+	pipe.Set(ctx, dedupeKey, sub.ID, ttl)
+	pipe.SAdd(ctx, itemKey, sub.ID)
+	pipe.Expire(ctx, itemKey, ttl)
+	pipe.SAdd(ctx, backInStockItemsKey, NumericIDFromGID(sub.InventoryItemID))
+	pipe.SAdd(ctx, targetKey, sub.ID)
+	pipe.Expire(ctx, targetKey, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return BackInStockSubscription{}, false, fmt.Errorf("failed to store back-in-stock subscription: %v", err)
+	}
+	return sub, true, nil
+}
+
+// GetBackInStockSubscription returns a subscription, or nil when it does not
+// exist or has expired
+func (s *Store) GetBackInStockSubscription(ctx context.Context, id string) (*BackInStockSubscription, error) {
+	data, err := s.client.Get(ctx, backInStockKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load back-in-stock subscription: %v", err)
+	}
+
+	var sub BackInStockSubscription
+	if err := json.Unmarshal(data, &sub); err != nil {
+		return nil, fmt.Errorf("failed to decode back-in-stock subscription: %v", err)
+	}
+	return &sub, nil
+}
+
+// DeleteBackInStockSubscription removes a subscription and its index entries
+func (s *Store) DeleteBackInStockSubscription(ctx context.Context, sub BackInStockSubscription) error {
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, backInStockKey(sub.ID), backInStockDedupeKey(sub.VariantID, sub.Target), backInStockDeliveryKey(sub.ID))
+	pipe.SRem(ctx, backInStockItemKey(sub.InventoryItemID), sub.ID)
+	pipe.SRem(ctx, backInStockTargetKey(sub.Target), sub.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete back-in-stock subscription: %v", err)
+	}
+	return nil
+}
+
+// ActiveBackInStockSubscriptions returns the subscriptions waiting for an
+// inventory item, pruning expired ones from its index
+func (s *Store) ActiveBackInStockSubscriptions(ctx context.Context, itemID string) ([]BackInStockSubscription, error) {
+	itemKey := backInStockItemKey(itemID)
+	ids, err := s.client.SMembers(ctx, itemKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load back-in-stock subscriptions: %v", err)
+	}
+
+	var subs []BackInStockSubscription
+	for _, id := range ids {
+		sub, err := s.GetBackInStockSubscription(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if sub == nil || sub.Status != BackInStockActive {
+			s.client.SRem(ctx, itemKey, id)
+			continue
+		}
+		subs = append(subs, *sub)
+	}
+	if len(subs) == 0 {
+		s.client.SRem(ctx, backInStockItemsKey, NumericIDFromGID(itemID))
+	}
+	return subs, nil
+}
+
+// BackInStockItems returns the numeric IDs of inventory items with
+// subscribers
+func (s *Store) BackInStockItems(ctx context.Context) ([]string, error) {
+	items, err := s.client.SMembers(ctx, backInStockItemsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load back-in-stock items: %v", err)
+	}
+	return items, nil
+}
+
+// ClaimBackInStockDelivery reserves the right to notify a subscription, so
+// a webhook and the poller seeing the same restock notify only once
+func (s *Store) ClaimBackInStockDelivery(ctx context.Context, id string) (bool, error) {
+	claimed, err := s.client.SetNX(ctx, backInStockDeliveryKey(id), time.Now().UTC().Format(time.RFC3339), backInStockDeliveryTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim back-in-stock delivery: %v", err)
+	}
+	return claimed, nil
+}
+
+// ReleaseBackInStockDelivery gives up a claim after a failed delivery so the
+// next restock retries it
+func (s *Store) ReleaseBackInStockDelivery(ctx context.Context, id string) error {
+	if err := s.client.Del(ctx, backInStockDeliveryKey(id)).Err(); err != nil {
+		return fmt.Errorf("failed to release back-in-stock delivery: %v", err)
+	}
+	return nil
+}
+
+// MarkBackInStockNotified records a delivered notification. The
+// subscription is kept until it expires so its status can still be read,
+// but leaves the item index and is never notified again.
+func (s *Store) MarkBackInStockNotified(ctx context.Context, sub BackInStockSubscription) error {
+	now := time.Now().UTC()
+	sub.Status = BackInStockNotified
+	sub.NotifiedAt = &now
+
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return fmt.Errorf("failed to encode back-in-stock subscription: %v", err)
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, backInStockKey(sub.ID), data, redis.KeepTTL)
+	pipe.Del(ctx, backInStockDedupeKey(sub.VariantID, sub.Target))
+	pipe.SRem(ctx, backInStockItemKey(sub.InventoryItemID), sub.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to mark back-in-stock subscription notified: %v", err)
+	}
+	return nil
+}
+
+// backInStockSubscriptionsFor returns the subscriptions a shopper's emails
+// made with the shopper's tenant
+func (s *Store) backInStockSubscriptionsFor(ctx context.Context, id ShopperIdentity) ([]BackInStockSubscription, error) {
+	subs := []BackInStockSubscription{}
+	for _, email := range id.Emails {
+		ids, err := s.client.SMembers(ctx, backInStockTargetKey(email)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load back-in-stock subscriptions: %v", err)
+		}
+		for _, subID := range ids {
+			sub, err := s.GetBackInStockSubscription(ctx, subID)
+			if err != nil {
+				return nil, err
+			}
+			if sub != nil && (sub.Tenant == id.Tenant || sub.Tenant == "" && id.Tenant == DefaultTenant) {
+				subs = append(subs, *sub)
+			}
+		}
+	}
+	return subs, nil
+}
+
+func init() {
+	RegisterShopperDataSource(func(s *Store) ShopperDataSource {
+		return ShopperDataSource{
+			Name: "backInStockSubscriptions",
+			Export: func(ctx context.Context, id ShopperIdentity) (interface{}, error) {
+				subs, err := s.backInStockSubscriptionsFor(ctx, id)
+				if err != nil {
+					return nil, err
+				}
+				for i := range subs {
+					subs[i].Token = ""
+				}
+				return subs, nil
+			},
+			Keys: func(ctx context.Context, id ShopperIdentity) ([]string, error) {
+				var keys []string
+				for _, email := range id.Emails {
+					keys = append(keys, backInStockRegistrationsKey(email))
+				}
+				return keys, nil
+			},
+			// Target indexes are shared between tenants and item indexes
+			// between shoppers, so subscriptions are deleted one by one
+			Erase: func(ctx context.Context, id ShopperIdentity) error {
+				subs, err := s.backInStockSubscriptionsFor(ctx, id)
+				if err != nil {
+					return err
+				}
+				for _, sub := range subs {
+					if err := s.DeleteBackInStockSubscription(ctx, sub); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Remaining: func(ctx context.Context, id ShopperIdentity) (int, error) {
+				subs, err := s.backInStockSubscriptionsFor(ctx, id)
+				return len(subs), err
+			},
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package models
+
+import "encoding/json"
+
+// Collection is a storefront collection as served by the collection
+// endpoints
+type Collection struct {
+	ID          string           `json:"id"`
+	NumericID   string           `json:"numericId"`
+	Handle      string           `json:"handle"`
+	Title       string           `json:"title"`
+	Description string           `json:"description,omitempty"`
+	Image       *CollectionImage `json:"image,omitempty"`
+	UpdatedAt   string           `json:"updatedAt,omitempty"`
+}
+
+// CollectionImage is the featured image of a collection
+type CollectionImage struct {
+	URL     string `json:"url"`
+	AltText string `json:"altText,omitempty"`
+}
+
+// CollectionList is the response body of the collection list endpoint
+type CollectionList struct {
+	Collections []Collection `json:"collections"`
+	PageInfo    PageInfo     `json:"pageInfo"`
+}
+
+// CollectionPage is one page of a collection's products. Products is the
+// Storefront connection with the same projection as the /v2/products list;
+// Filters are the facets the page can be narrowed by.
+type CollectionPage struct {
+	Collection Collection         `json:"collection"`
+	Products   json.RawMessage    `json:"products"`
+	Filters    []CollectionFilter `json:"filters"`
+}
+
+// CollectionFilter is a facet of a collection's products, such as
+// availability, price or a variant option
+type CollectionFilter struct {
+	ID     string                  `json:"id"`
+	Label  string                  `json:"label"`
+	Type   string                  `json:"type"`
+	Values []CollectionFilterValue `json:"values"`
+}
+
+// CollectionFilterValue is one value of a facet. Input is the Storefront
+// ProductFilter that selects it.
+type CollectionFilterValue struct {
+	ID    string          `json:"id"`
+	Label string          `json:"label"`
+	Count int             `json:"count"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// CollectionRules describes how a smart collection selects its products.
+// Manual collections have Smart unset and no rules.
+type CollectionRules struct {
+	Handle               string           `json:"handle"`
+	Smart                bool             `json:"smart"`
+	AppliedDisjunctively bool             `json:"appliedDisjunctively"`
+	Rules                []CollectionRule `json:"rules"`
+}
+
+// CollectionRule is one condition of a smart collection, e.g. TAG EQUALS
+// "summer"
+type CollectionRule struct {
+	Column    string `json:"column"`
+	Relation  string `json:"relation"`
+	Condition string `json:"condition"`
+}
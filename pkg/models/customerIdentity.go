@@ -0,0 +1,93 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const maxIdentityTTL = 24 * time.Hour
+
+// CustomerIdentity is a Shopify customer verified for a chat session. The
+// tenant is fixed when the identity is linked, so later requests on the
+// session cannot switch it with their headers.
+type CustomerIdentity struct {
+	CustomerID string    `json:"customerId"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Method     string    `json:"method"`
+	VerifiedAt time.Time `json:"verifiedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// TenantOrDefault returns the tenant the identity was linked under,
+// treating identities linked before tenants were recorded as the default
+// tenant's
+func (i CustomerIdentity) TenantOrDefault() string {
+	if i.Tenant == "" {
+		return DefaultTenant
+	}
+	return i.Tenant
+}
+
+func sessionIdentityKey(sessionID string) string {
+	return "chat:session:" + sessionID + ":customer"
+}
+
+// SetSessionIdentity attaches a verified customer to a chat session until
+// the identity expires, capped at a day, and binds the session to the
+// identity's tenant
+func (s *Store) SetSessionIdentity(ctx context.Context, sessionID string, identity CustomerIdentity) error {
+	identity.Tenant = identity.TenantOrDefault()
+	ttl := time.Until(identity.ExpiresAt)
+	if ttl <= 0 {
+		return fmt.Errorf("identity already expired")
+	}
+	if ttl > maxIdentityTTL {
+		ttl = maxIdentityTTL
+		identity.ExpiresAt = time.Now().Add(ttl).UTC()
+	}
+
+	data, err := json.Marshal(identity)
+	if err != nil {
+		return fmt.Errorf("failed to encode identity: %v", err)
+	}
+	if err := s.client.Set(ctx, sessionIdentityKey(sessionID), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store identity: %v", err)
+	}
+	if err := s.BindSessionTenant(ctx, sessionID, identity.Tenant); err != nil {
+		return err
+	}
+	return s.IndexShopperSession(ctx, identity.Tenant, IdentifierCustomer, identity.CustomerID, sessionID)
+}
+
+// GetSessionIdentity returns the verified customer of a session, or nil
+func (s *Store) GetSessionIdentity(ctx context.Context, sessionID string) (*CustomerIdentity, error) {
+	if s.client == nil {
+		return nil, nil
+	}
+
+	data, err := s.client.Get(ctx, sessionIdentityKey(sessionID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity: %v", err)
+	}
+
+	var identity CustomerIdentity
+	if err := json.Unmarshal(data, &identity); err != nil {
+		return nil, fmt.Errorf("failed to decode identity: %v", err)
+	}
+	return &identity, nil
+}
+
+// ClearSessionIdentity detaches the customer from a session
+func (s *Store) ClearSessionIdentity(ctx context.Context, sessionID string) error {
+	if err := s.client.Del(ctx, sessionIdentityKey(sessionID)).Err(); err != nil {
+		return fmt.Errorf("failed to clear identity: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package models
+
+import "strings"
+
+// NumericIDFromGID extracts the trailing numeric ID from a Shopify global ID
+// such as "gid://shopify/Product/123456". Values that are not GIDs are
+// returned unchanged.
+func NumericIDFromGID(gid string) string {
+	if !strings.HasPrefix(gid, "gid://") {
+		return gid
+	}
+	id := gid[strings.LastIndex(gid, "/")+1:]
+	// Strip query parameters some GIDs carry, e.g. "?variant=..."
+	if i := strings.Index(id, "?"); i >= 0 {
+		id = id[:i]
+	}
+	return id
+}
+
+// GIDFromID returns the Shopify global ID of a numeric ID of the given
+// resource type, such as "Order". GIDs are returned unchanged.
+func GIDFromID(resource, id string) string {
+	if id == "" || strings.HasPrefix(id, "gid://") {
+		return id
+	}
+	return "gid://shopify/" + resource + "/" + id
+}
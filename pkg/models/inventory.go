@@ -0,0 +1,86 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Stock statuses of a variant or of one location
+const (
+	StockInStock   = "in_stock"
+	StockLow       = "low_stock"
+	StockOut       = "out_of_stock"
+	StockUntracked = "untracked"
+)
+
+// ProductInventory is the stock of every variant of a product
+type ProductInventory struct {
+	ProductID string             `json:"productId"`
+	Handle    string             `json:"handle"`
+	Title     string             `json:"title"`
+	Variants  []VariantInventory `json:"variants"`
+	UpdatedAt time.Time          `json:"updatedAt"`
+}
+
+// VariantInventory is the stock of one variant across locations. Signal is
+// the shopper-facing low-stock message, e.g. "Only 2 left".
+type VariantInventory struct {
+	VariantID       string              `json:"variantId"`
+	InventoryItemID string              `json:"inventoryItemId"`
+	Title           string              `json:"title"`
+	SKU             string              `json:"sku,omitempty"`
+	SelectedOptions []SelectedOption    `json:"selectedOptions"`
+	Tracked         bool                `json:"tracked"`
+	Available       int                 `json:"available"`
+	Status          string              `json:"status"`
+	Signal          string              `json:"signal,omitempty"`
+	Locations       []LocationInventory `json:"locations"`
+}
+
+// LocationInventory is the stock of a variant at one location
+type LocationInventory struct {
+	LocationID string `json:"locationId"`
+	Name       string `json:"name"`
+	City       string `json:"city,omitempty"`
+	Province   string `json:"province,omitempty"`
+	Country    string `json:"country,omitempty"`
+	Available  int    `json:"available"`
+	Status     string `json:"status"`
+}
+
+// StockStatus classifies a quantity against the low-stock threshold
+func StockStatus(available, threshold int) string {
+	switch {
+	case available <= 0:
+		return StockOut
+	case available <= threshold:
+		return StockLow
+	}
+	return StockInStock
+}
+
+// Summarize recomputes the variant's total, status and signal from its
+// locations
+func (v *VariantInventory) Summarize(threshold int) {
+	v.Signal = ""
+	if !v.Tracked {
+		v.Available = 0
+		v.Status = StockUntracked
+		for i := range v.Locations {
+			v.Locations[i].Status = StockUntracked
+		}
+		return
+	}
+
+	v.Available = 0
+	for i := range v.Locations {
+		v.Locations[i].Status = StockStatus(v.Locations[i].Available, threshold)
+		if v.Locations[i].Available > 0 {
+			v.Available += v.Locations[i].Available
+		}
+	}
+	v.Status = StockStatus(v.Available, threshold)
+	if v.Status == StockLow {
+		v.Signal = fmt.Sprintf("Only %d left", v.Available)
+	}
+}
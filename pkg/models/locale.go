@@ -0,0 +1,22 @@
+package models
+
+import "strings"
+
+// Locale is the shopper's buying context forwarded to Storefront @inContext
+type Locale struct {
+	Country  string `json:"country,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+// IsZero reports whether no country or language was requested
+func (l Locale) IsZero() bool {
+	return l.Country == "" && l.Language == ""
+}
+
+// CacheKey returns a stable cache key segment for the locale
+func (l Locale) CacheKey() string {
+	if l.IsZero() {
+		return "default"
+	}
+	return strings.ToLower(l.Country + "-" + l.Language)
+}
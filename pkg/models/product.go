@@ -0,0 +1,84 @@
+package models
+
+// Product is the stable public product schema served by the /v3 endpoints.
+// It is decoupled from the Shopify GraphQL shape so schema changes upstream
+// do not leak into clients.
+type Product struct {
+	ID              string           `json:"id"`
+	NumericID       string           `json:"numericId"`
+	Handle          string           `json:"handle"`
+	Title           string           `json:"title"`
+	DescriptionHTML string           `json:"descriptionHtml"`
+	Vendor          string           `json:"vendor,omitempty"`
+	ProductType     string           `json:"productType,omitempty"`
+	Tags            []string         `json:"tags"`
+	CreatedAt       string           `json:"createdAt,omitempty"`
+	UpdatedAt       string           `json:"updatedAt,omitempty"`
+	PriceRange      PriceRange       `json:"priceRange"`
+	Options         []ProductOption  `json:"options"`
+	Variants        []ProductVariant `json:"variants"`
+	Media           []ProductMedia   `json:"media"`
+}
+
+// Money is a decimal amount with its ISO 4217 currency code
+type Money struct {
+	Amount       string `json:"amount"`
+	CurrencyCode string `json:"currencyCode,omitempty"`
+}
+
+// PriceRange holds the lowest and highest variant price of a product
+type PriceRange struct {
+	Min Money `json:"min"`
+	Max Money `json:"max"`
+}
+
+// ProductOption is a product option such as Size or Color
+type ProductOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// SelectedOption is the option value a variant represents
+type SelectedOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ProductVariant is a purchasable variant of a product
+type ProductVariant struct {
+	ID               string           `json:"id"`
+	NumericID        string           `json:"numericId"`
+	Title            string           `json:"title"`
+	SKU              string           `json:"sku,omitempty"`
+	Price            Money            `json:"price"`
+	CompareAtPrice   *Money           `json:"compareAtPrice,omitempty"`
+	AvailableForSale bool             `json:"availableForSale"`
+	SelectedOptions  []SelectedOption `json:"selectedOptions"`
+}
+
+// ProductMedia is an image or 3D model attached to a product
+type ProductMedia struct {
+	Type    string        `json:"type"`
+	Alt     string        `json:"alt,omitempty"`
+	URL     string        `json:"url,omitempty"`
+	Sources []MediaSource `json:"sources,omitempty"`
+}
+
+// MediaSource is a downloadable rendition of a 3D model
+type MediaSource struct {
+	URL      string `json:"url"`
+	Format   string `json:"format"`
+	MimeType string `json:"mimeType"`
+}
+
+// PageInfo carries cursor pagination state for list endpoints
+type PageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor,omitempty"`
+}
+
+// ProductList is the response body of the product list endpoint
+type ProductList struct {
+	Products []Product `json:"products"`
+	PageInfo PageInfo  `json:"pageInfo"`
+}
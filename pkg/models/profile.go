@@ -0,0 +1,268 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	profileTTL             = 365 * 24 * time.Hour
+	maxProfileProductItems = 50
+)
+
+// Budget is the price range a shopper is comfortable with
+type Budget struct {
+	Min      float64 `json:"min,omitempty"`
+	Max      float64 `json:"max,omitempty"`
+	Currency string  `json:"currency,omitempty"`
+}
+
+// Profile is the personalization memory of one shopper. It is keyed by the
+// Shopify customer ID when known, otherwise by an anonymous visitor ID.
+type Profile struct {
+	ID                string            `json:"id"`
+	Sizes             map[string]string `json:"sizes"`
+	FavouriteColors   []string          `json:"favouriteColors"`
+	FavouriteStyles   []string          `json:"favouriteStyles"`
+	Budget            *Budget           `json:"budget,omitempty"`
+	ViewedProducts    []string          `json:"viewedProducts"`
+	PurchasedProducts []string          `json:"purchasedProducts"`
+	UpdatedAt         time.Time         `json:"updatedAt"`
+}
+
+// PreferenceUpdate holds preferences extracted from a conversation or sent
+// by the shopper. Empty fields leave the profile unchanged.
+type PreferenceUpdate struct {
+	Sizes           map[string]string `json:"sizes,omitempty"`
+	FavouriteColors []string          `json:"favouriteColors,omitempty"`
+	FavouriteStyles []string          `json:"favouriteStyles,omitempty"`
+	Budget          *Budget           `json:"budget,omitempty"`
+}
+
+// IsEmpty reports whether the update carries no preferences
+func (u PreferenceUpdate) IsEmpty() bool {
+	return len(u.Sizes) == 0 && len(u.FavouriteColors) == 0 && len(u.FavouriteStyles) == 0 && u.Budget == nil
+}
+
+// ProfileID builds a profile ID from a customer GID or visitor ID, preferring
+// the customer so preferences follow a signed-in shopper across devices
+func ProfileID(customerID, visitorID string) string {
+	if customerID != "" {
+		return "customer:" + customerID
+	}
+	if visitorID != "" {
+		return "visitor:" + visitorID
+	}
+	return ""
+}
+
+func profileKey(id string) string {
+	return "shopper:profile:" + id
+}
+
+func newProfile(id string) *Profile {
+	return &Profile{
+		ID:                id,
+		Sizes:             map[string]string{},
+		FavouriteColors:   []string{},
+		FavouriteStyles:   []string{},
+		ViewedProducts:    []string{},
+		PurchasedProducts: []string{},
+	}
+}
+
+// GetProfile loads a profile, returning an empty one when none is stored
+func (s *Store) GetProfile(ctx context.Context, id string) (*Profile, error) {
+	data, err := s.client.Get(ctx, profileKey(id)).Bytes()
+	if err == redis.Nil {
+		return newProfile(id), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profile: %v", err)
+	}
+
+	profile := newProfile(id)
+	if err := json.Unmarshal(data, profile); err != nil {
+		return nil, fmt.Errorf("failed to decode profile: %v", err)
+	}
+	return profile, nil
+}
+
+// SaveProfile stores a profile and refreshes its TTL
+func (s *Store) SaveProfile(ctx context.Context, profile *Profile) error {
+	profile.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return fmt.Errorf("failed to encode profile: %v", err)
+	}
+	if err := s.client.Set(ctx, profileKey(profile.ID), data, profileTTL).Err(); err != nil {
+		return fmt.Errorf("failed to store profile: %v", err)
+	}
+	return nil
+}
+
+// DeleteProfile removes a stored profile
+func (s *Store) DeleteProfile(ctx context.Context, id string) error {
+	if err := s.client.Del(ctx, profileKey(id)).Err(); err != nil {
+		return fmt.Errorf("failed to delete profile: %v", err)
+	}
+	return nil
+}
+
+// Merge applies a preference update. Sizes are overwritten per category,
+// colors and styles are added without duplicates.
+func (p *Profile) Merge(update PreferenceUpdate) {
+	for category, size := range update.Sizes {
+		category = strings.ToLower(strings.TrimSpace(category))
+		if category != "" && size != "" {
+			p.Sizes[category] = size
+		}
+	}
+	p.FavouriteColors = appendUnique(p.FavouriteColors, update.FavouriteColors...)
+	p.FavouriteStyles = appendUnique(p.FavouriteStyles, update.FavouriteStyles...)
+	if update.Budget != nil {
+		p.Budget = update.Budget
+	}
+}
+
+// Replace overwrites every editable preference with update
+func (p *Profile) Replace(update PreferenceUpdate) {
+	p.Sizes = map[string]string{}
+	p.FavouriteColors = []string{}
+	p.FavouriteStyles = []string{}
+	p.Budget = nil
+	p.Merge(update)
+}
+
+// RecordProduct adds a product to the viewed or purchased history, keeping
+// the most recent entries first
+func (p *Profile) RecordProduct(event, productID string) error {
+	switch event {
+	case "viewed":
+		p.ViewedProducts = pushRecent(p.ViewedProducts, productID)
+	case "purchased":
+		p.PurchasedProducts = pushRecent(p.PurchasedProducts, productID)
+	default:
+		return fmt.Errorf("unknown product event %q", event)
+	}
+	return nil
+}
+
+// Summary renders the profile as plain text for the system prompt. Values
+// come from shoppers, so each is quoted and only those keep accepts are
+// included.
+func (p *Profile) Summary(keep func(value string) bool) string {
+	quoted := func(values []string) []string {
+		var kept []string
+		for _, v := range values {
+			if keep(v) {
+				kept = append(kept, strconv.Quote(v))
+			}
+		}
+		return kept
+	}
+
+	var lines []string
+	categories := make([]string, 0, len(p.Sizes))
+	for category := range p.Sizes {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		if size := p.Sizes[category]; keep(category) && keep(size) {
+			lines = append(lines, fmt.Sprintf("Size for %q: %q", category, size))
+		}
+	}
+	if colors := quoted(p.FavouriteColors); len(colors) > 0 {
+		lines = append(lines, "Favourite colors: "+strings.Join(colors, ", "))
+	}
+	if styles := quoted(p.FavouriteStyles); len(styles) > 0 {
+		lines = append(lines, "Favourite styles: "+strings.Join(styles, ", "))
+	}
+	if p.Budget != nil && keep(p.Budget.Currency) {
+		lines = append(lines, fmt.Sprintf("Budget: %g to %g %q", p.Budget.Min, p.Budget.Max, p.Budget.Currency))
+	}
+	if viewed := quoted(firstN(p.ViewedProducts, 10)); len(viewed) > 0 {
+		lines = append(lines, "Recently viewed products: "+strings.Join(viewed, ", "))
+	}
+	if purchased := quoted(firstN(p.PurchasedProducts, 10)); len(purchased) > 0 {
+		lines = append(lines, "Purchased products: "+strings.Join(purchased, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func appendUnique(values []string, additions ...string) []string {
+	for _, addition := range additions {
+		addition = strings.ToLower(strings.TrimSpace(addition))
+		if addition == "" {
+			continue
+		}
+		found := false
+		for _, v := range values {
+			if v == addition {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, addition)
+		}
+	}
+	return values
+}
+
+func pushRecent(values []string, value string) []string {
+	recent := []string{value}
+	for _, v := range values {
+		if v != value {
+			recent = append(recent, v)
+		}
+	}
+	return firstN(recent, maxProfileProductItems)
+}
+
+func firstN(values []string, n int) []string {
+	if len(values) > n {
+		return values[:n]
+	}
+	return values
+}
+
+func init() {
+	RegisterShopperDataSource(func(s *Store) ShopperDataSource {
+		return ShopperDataSource{
+			Name: "profiles",
+			Export: func(ctx context.Context, id ShopperIdentity) (interface{}, error) {
+				profiles := []*Profile{}
+				for _, profileID := range id.ProfileIDs() {
+					data, err := s.client.Get(ctx, profileKey(profileID)).Bytes()
+					if err == redis.Nil {
+						continue
+					}
+					if err != nil {
+						return nil, fmt.Errorf("failed to load profile: %v", err)
+					}
+					profile := newProfile(profileID)
+					if err := json.Unmarshal(data, profile); err == nil {
+						profiles = append(profiles, profile)
+					}
+				}
+				return profiles, nil
+			},
+			Keys: func(ctx context.Context, id ShopperIdentity) ([]string, error) {
+				var keys []string
+				for _, profileID := range id.ProfileIDs() {
+					keys = append(keys, profileKey(profileID))
+				}
+				return keys, nil
+			},
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// PromotionOverrides are a tenant's choices about which active promotions
+// the chatbot may mention. Entries are discount IDs or codes. Automatic
+// discounts may be mentioned unless Blocked; code discounts only once
+// Allowed, so a new code is never leaked before an admin opts in.
+type PromotionOverrides struct {
+	Allowed   []string  `json:"allowed"`
+	Blocked   []string  `json:"blocked"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// Mentionable reports whether the promotion with id and codes may be
+// mentioned to shoppers
+func (o PromotionOverrides) Mentionable(id string, codes []string) bool {
+	matches := func(entries []string) bool {
+		for _, entry := range entries {
+			entry = strings.TrimSpace(entry)
+			if entry == id || entry == NumericIDFromGID(id) {
+				return true
+			}
+			for _, code := range codes {
+				if strings.EqualFold(entry, code) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if matches(o.Blocked) {
+		return false
+	}
+	return len(codes) == 0 || matches(o.Allowed)
+}
+
+func promotionOverridesKey(tenant string) string {
+	return "promotions:overrides:" + tenant
+}
+
+// GetPromotionOverrides returns tenant's overrides, empty when it has none
+func (s *Store) GetPromotionOverrides(ctx context.Context, tenant string) (PromotionOverrides, error) {
+	empty := PromotionOverrides{Allowed: []string{}, Blocked: []string{}}
+	if s.client == nil {
+		return empty, nil
+	}
+
+	data, err := s.client.Get(ctx, promotionOverridesKey(tenant)).Bytes()
+	if err == redis.Nil {
+		return empty, nil
+	}
+	if err != nil {
+		return empty, fmt.Errorf("failed to load promotion overrides: %v", err)
+	}
+
+	var overrides PromotionOverrides
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return empty, fmt.Errorf("failed to decode promotion overrides: %v", err)
+	}
+	return overrides, nil
+}
+
+// SavePromotionOverrides stores tenant's overrides
+func (s *Store) SavePromotionOverrides(ctx context.Context, tenant string, overrides PromotionOverrides) error {
+	overrides.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return fmt.Errorf("failed to encode promotion overrides: %v", err)
+	}
+	if err := s.client.Set(ctx, promotionOverridesKey(tenant), data, 0).Err(); err != nil {
+		return fmt.Errorf("failed to store promotion overrides: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"strategy-fox-go-bd/pkg/config"
+)
+
+// ReturnPolicy is a tenant's rules for self-service returns and exchanges.
+// WindowDays counts from delivery; zero accepts no returns.
+type ReturnPolicy struct {
+	WindowDays       int       `json:"windowDays"`
+	FinalSaleTags    []string  `json:"finalSaleTags"`
+	ExchangesEnabled bool      `json:"exchangesEnabled"`
+	UpdatedAt        time.Time `json:"updatedAt,omitempty"`
+}
+
+// DefaultReturnPolicy is the policy of tenants that have not saved one
+func DefaultReturnPolicy(cfg config.ReturnsConfig) ReturnPolicy {
+	return ReturnPolicy{
+		WindowDays:       cfg.WindowDays,
+		FinalSaleTags:    append([]string{}, cfg.FinalSaleTags...),
+		ExchangesEnabled: cfg.ExchangesEnabled,
+	}
+}
+
+// IsFinalSale reports whether a product with tags is excluded from returns
+func (p ReturnPolicy) IsFinalSale(tags []string) bool {
+	for _, tag := range tags {
+		for _, finalSale := range p.FinalSaleTags {
+			if strings.EqualFold(strings.TrimSpace(tag), strings.TrimSpace(finalSale)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func returnPolicyKey(tenant string) string {
+	return "returns:policy:" + tenant
+}
+
+// GetReturnPolicy returns tenant's saved policy, or fallback when it has none
+func (s *Store) GetReturnPolicy(ctx context.Context, tenant string, fallback ReturnPolicy) (ReturnPolicy, error) {
+	if s.client == nil {
+		return fallback, nil
+	}
+
+	data, err := s.client.Get(ctx, returnPolicyKey(tenant)).Bytes()
+	if err == redis.Nil {
+		return fallback, nil
+	}
+	if err != nil {
+		return fallback, fmt.Errorf("failed to load return policy: %v", err)
+	}
+
+	var policy ReturnPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return fallback, fmt.Errorf("failed to decode return policy: %v", err)
+	}
+	return policy, nil
+}
+
+// SaveReturnPolicy stores tenant's policy
+func (s *Store) SaveReturnPolicy(ctx context.Context, tenant string, policy ReturnPolicy) error {
+	policy.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("failed to encode return policy: %v", err)
+	}
+	if err := s.client.Set(ctx, returnPolicyKey(tenant), data, 0).Err(); err != nil {
+		return fmt.Errorf("failed to store return policy: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package models
+
+// The types below mirror the Shopify Storefront GraphQL response shape. They
+// are only used to decode upstream responses and are mapped into Product
+// before anything is written to a client.
+
+type ShopifyMoney struct {
+	Amount       string `json:"amount"`
+	CurrencyCode string `json:"currencyCode"`
+}
+
+type ShopifyMediaNode struct {
+	MediaContentType string `json:"mediaContentType"`
+	Alt              string `json:"alt"`
+	Sources          []struct {
+		URL      string `json:"url"`
+		Format   string `json:"format"`
+		MimeType string `json:"mimeType"`
+	} `json:"sources"`
+	Image *struct {
+		URL string `json:"url"`
+	} `json:"image"`
+}
+
+type ShopifyVariantNode struct {
+	ID               string        `json:"id"`
+	Title            string        `json:"title"`
+	SKU              string        `json:"sku"`
+	Price            ShopifyMoney  `json:"price"`
+	CompareAtPrice   *ShopifyMoney `json:"compareAtPrice"`
+	AvailableForSale bool          `json:"availableForSale"`
+	SelectedOptions  []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"selectedOptions"`
+}
+
+type ShopifyPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type ShopifyProduct struct {
+	ID              string   `json:"id"`
+	Handle          string   `json:"handle"`
+	Title           string   `json:"title"`
+	DescriptionHTML string   `json:"descriptionHtml"`
+	Vendor          string   `json:"vendor"`
+	ProductType     string   `json:"productType"`
+	Tags            []string `json:"tags"`
+	CreatedAt       string   `json:"createdAt"`
+	UpdatedAt       string   `json:"updatedAt"`
+	PriceRange      struct {
+		MinVariantPrice ShopifyMoney `json:"minVariantPrice"`
+		MaxVariantPrice ShopifyMoney `json:"maxVariantPrice"`
+	} `json:"priceRange"`
+	Options []struct {
+		Name   string   `json:"name"`
+		Values []string `json:"values"`
+	} `json:"options"`
+	Media struct {
+		Edges []struct {
+			Node ShopifyMediaNode `json:"node"`
+		} `json:"edges"`
+	} `json:"media"`
+	Variants struct {
+		Edges []struct {
+			Node ShopifyVariantNode `json:"node"`
+		} `json:"edges"`
+	} `json:"variants"`
+}
+
+type ShopifyProductConnection struct {
+	Edges []struct {
+		Node ShopifyProduct `json:"node"`
+	} `json:"edges"`
+	PageInfo ShopifyPageInfo `json:"pageInfo"`
+}
+
+// ToProduct maps a Shopify GraphQL product into the public Product schema
+func (p ShopifyProduct) ToProduct() Product {
+	product := Product{
+		ID:              p.ID,
+		NumericID:       NumericIDFromGID(p.ID),
+		Handle:          p.Handle,
+		Title:           p.Title,
+		DescriptionHTML: p.DescriptionHTML,
+		Vendor:          p.Vendor,
+		ProductType:     p.ProductType,
+		Tags:            p.Tags,
+		CreatedAt:       p.CreatedAt,
+		UpdatedAt:       p.UpdatedAt,
+		PriceRange: PriceRange{
+			Min: Money(p.PriceRange.MinVariantPrice),
+			Max: Money(p.PriceRange.MaxVariantPrice),
+		},
+		Options:  []ProductOption{},
+		Variants: []ProductVariant{},
+		Media:    []ProductMedia{},
+	}
+	if product.Tags == nil {
+		product.Tags = []string{}
+	}
+
+	for _, o := range p.Options {
+		product.Options = append(product.Options, ProductOption{Name: o.Name, Values: o.Values})
+	}
+
+	for _, edge := range p.Variants.Edges {
+		v := edge.Node
+		variant := ProductVariant{
+			ID:               v.ID,
+			NumericID:        NumericIDFromGID(v.ID),
+			Title:            v.Title,
+			SKU:              v.SKU,
+			Price:            Money(v.Price),
+			AvailableForSale: v.AvailableForSale,
+			SelectedOptions:  []SelectedOption{},
+		}
+		if v.CompareAtPrice != nil {
+			compareAt := Money(*v.CompareAtPrice)
+			variant.CompareAtPrice = &compareAt
+		}
+		for _, so := range v.SelectedOptions {
+			variant.SelectedOptions = append(variant.SelectedOptions, SelectedOption{Name: so.Name, Value: so.Value})
+		}
+		product.Variants = append(product.Variants, variant)
+	}
+
+	for _, edge := range p.Media.Edges {
+		m := edge.Node
+		media := ProductMedia{Type: m.MediaContentType, Alt: m.Alt}
+		if m.Image != nil {
+			media.URL = m.Image.URL
+		}
+		for _, s := range m.Sources {
+			media.Sources = append(media.Sources, MediaSource{URL: s.URL, Format: s.Format, MimeType: s.MimeType})
+		}
+		product.Media = append(product.Media, media)
+	}
+
+	return product
+}
+
+// ToProductList maps a Shopify product connection into a ProductList
+func (c ShopifyProductConnection) ToProductList() ProductList {
+	list := ProductList{
+		Products: []Product{},
+		PageInfo: PageInfo{HasNextPage: c.PageInfo.HasNextPage, EndCursor: c.PageInfo.EndCursor},
+	}
+	for _, edge := range c.Edges {
+		list.Products = append(list.Products, edge.Node.ToProduct())
+	}
+	return list
+}
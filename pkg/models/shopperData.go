@@ -0,0 +1,408 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"strategy-fox-go-bd/pkg/config"
+)
+
+const (
+	IdentifierSession  = "session"
+	IdentifierEmail    = "email"
+	IdentifierCustomer = "customer"
+	IdentifierVisitor  = "visitor"
+)
+
+// ShopperIdentity is everything known to identify one shopper of a tenant
+// across keyspaces. It is resolved from a single identifier.
+type ShopperIdentity struct {
+	Tenant     string   `json:"tenant"`
+	SessionIDs []string `json:"sessionIds"`
+	Emails     []string `json:"emails,omitempty"`
+	CustomerID string   `json:"customerId,omitempty"`
+	VisitorIDs []string `json:"visitorIds,omitempty"`
+}
+
+// ProfileIDs returns the IDs of every profile that may belong to the shopper
+func (id ShopperIdentity) ProfileIDs() []string {
+	var ids []string
+	if id.CustomerID != "" {
+		ids = append(ids, ProfileID(id.CustomerID, ""))
+	}
+	for _, visitorID := range id.VisitorIDs {
+		ids = append(ids, ProfileID("", visitorID))
+	}
+	return ids
+}
+
+// ShopperDataSource is a keyspace holding shopper data. Every subsystem that
+// stores personal data registers one so exports and erasures stay complete.
+type ShopperDataSource struct {
+	Name string
+	// Export returns the stored data for the shopper
+	Export func(ctx context.Context, id ShopperIdentity) (interface{}, error)
+	// Keys lists the Redis keys holding the shopper's data. Optional.
+	Keys func(ctx context.Context, id ShopperIdentity) ([]string, error)
+	// Erase removes data that does not live in dedicated keys. Optional.
+	Erase func(ctx context.Context, id ShopperIdentity) error
+	// Remaining counts the data Erase left behind, for sources without
+	// dedicated keys. Optional.
+	Remaining func(ctx context.Context, id ShopperIdentity) (int, error)
+}
+
+var (
+	shopperDataSources   []func(s *Store) ShopperDataSource
+	shopperDataSourcesMu sync.Mutex
+)
+
+// RegisterShopperDataSource adds a keyspace to GDPR exports and erasures.
+// source builds the keyspace's ShopperDataSource for the Store a request
+// runs against.
+func RegisterShopperDataSource(source func(s *Store) ShopperDataSource) {
+	shopperDataSourcesMu.Lock()
+	defer shopperDataSourcesMu.Unlock()
+	shopperDataSources = append(shopperDataSources, source)
+}
+
+// registeredShopperDataSources returns every registered source bound to s
+func (s *Store) registeredShopperDataSources() []ShopperDataSource {
+	shopperDataSourcesMu.Lock()
+	defer shopperDataSourcesMu.Unlock()
+
+	sources := make([]ShopperDataSource, 0, len(shopperDataSources))
+	for _, source := range shopperDataSources {
+		sources = append(sources, source(s))
+	}
+	return sources
+}
+
+// HashIdentifier returns a stable, non-reversible form of an identifier for
+// index keys and audit records
+func HashIdentifier(value string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(value))))
+	return hex.EncodeToString(sum[:])
+}
+
+func shopperIndexKey(tenant, kind, value string) string {
+	return fmt.Sprintf("shopper:sessions:%s:%s:%s", tenant, kind, HashIdentifier(value))
+}
+
+func sessionTenantKey(sessionID string) string {
+	return "chat:session:" + sessionID + ":tenant"
+}
+
+// BindSessionTenant records the tenant a chat session belongs to. The first
+// tenant to use a session keeps it, so GDPR requests of other tenants never
+// reach it.
+func (s *Store) BindSessionTenant(ctx context.Context, sessionID, tenant string) error {
+	if s.client == nil {
+		return nil
+	}
+
+	key := sessionTenantKey(sessionID)
+	pipe := s.client.TxPipeline()
+	pipe.SetNX(ctx, key, tenant, transcriptTTL)
+	pipe.Expire(ctx, key, transcriptTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to bind session tenant: %v", err)
+	}
+	return nil
+}
+
+// sessionInTenant reports whether sessionID is bound to tenant. Sessions
+// from before tenants were bound belong to DefaultTenant.
+func (s *Store) sessionInTenant(ctx context.Context, sessionID, tenant string) (bool, error) {
+	bound, err := s.client.Get(ctx, sessionTenantKey(sessionID)).Result()
+	if err == redis.Nil {
+		return tenant == DefaultTenant, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load session tenant: %v", err)
+	}
+	return bound == tenant, nil
+}
+
+// IndexShopperSession records that a session of tenant belongs to the
+// shopper with the given email or customer ID, so the session can be found
+// for the tenant's GDPR requests
+func (s *Store) IndexShopperSession(ctx context.Context, tenant, kind, value, sessionID string) error {
+	if s.client == nil || value == "" {
+		return nil
+	}
+
+	key := shopperIndexKey(tenant, kind, value)
+	pipe := s.client.TxPipeline()
+	pipe.SAdd(ctx, key, sessionID)
+	pipe.Expire(ctx, key, transcriptTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to index shopper session: %v", err)
+	}
+	return nil
+}
+
+func sessionVisitorKey(sessionID string) string {
+	return "chat:session:" + sessionID + ":visitor"
+}
+
+// LinkSessionVisitor records the anonymous visitor a chat session of tenant
+// belongs to
+func (s *Store) LinkSessionVisitor(ctx context.Context, tenant, sessionID, visitorID string) error {
+	if s.client == nil || visitorID == "" {
+		return nil
+	}
+	if err := s.client.Set(ctx, sessionVisitorKey(sessionID), visitorID, transcriptTTL).Err(); err != nil {
+		return fmt.Errorf("failed to link session visitor: %v", err)
+	}
+	return s.IndexShopperSession(ctx, tenant, IdentifierVisitor, visitorID, sessionID)
+}
+
+// ResolveShopperIdentity expands an identifier into every session of tenant
+// linked to it. Customer and visitor IDs, whose profiles are shared between
+// tenants, are only kept when the tenant has sessions of the shopper.
+func (s *Store) ResolveShopperIdentity(ctx context.Context, tenant, kind, value string) (ShopperIdentity, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ShopperIdentity{}, fmt.Errorf("identifier value is required")
+	}
+
+	var sessions []string
+	switch kind {
+	case IdentifierSession:
+		sessions = []string{value}
+	case IdentifierEmail, IdentifierCustomer, IdentifierVisitor:
+		var err error
+		sessions, err = s.client.SMembers(ctx, shopperIndexKey(tenant, kind, value)).Result()
+		if err != nil {
+			return ShopperIdentity{}, fmt.Errorf("failed to resolve shopper sessions: %v", err)
+		}
+		sort.Strings(sessions)
+	default:
+		return ShopperIdentity{}, fmt.Errorf("unknown identifier type %q", kind)
+	}
+
+	identity := ShopperIdentity{Tenant: tenant, SessionIDs: []string{}}
+	for _, sessionID := range sessions {
+		ok, err := s.sessionInTenant(ctx, sessionID, tenant)
+		if err != nil {
+			return ShopperIdentity{}, err
+		}
+		if ok {
+			identity.SessionIDs = append(identity.SessionIDs, sessionID)
+		}
+	}
+
+	switch {
+	case kind == IdentifierEmail:
+		identity.Emails = []string{value}
+	case kind == IdentifierCustomer && len(identity.SessionIDs) > 0:
+		identity.CustomerID = value
+	case kind == IdentifierVisitor && len(identity.SessionIDs) > 0:
+		identity.VisitorIDs = []string{value}
+	}
+
+	// Sessions lead to the visitor IDs whose profiles belong to the shopper
+	for _, sessionID := range identity.SessionIDs {
+		visitorID, err := s.client.Get(ctx, sessionVisitorKey(sessionID)).Result()
+		if err == nil && !containsString(identity.VisitorIDs, visitorID) {
+			identity.VisitorIDs = append(identity.VisitorIDs, visitorID)
+		}
+	}
+
+	return identity, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportShopperData collects the shopper's data from every registered
+// source and from extra, the sources bound to a store instance
+func (s *Store) ExportShopperData(ctx context.Context, id ShopperIdentity, extra ...ShopperDataSource) (map[string]interface{}, error) {
+	archive := map[string]interface{}{}
+	for _, source := range append(s.registeredShopperDataSources(), extra...) {
+		data, err := source.Export(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %v", source.Name, err)
+		}
+		archive[source.Name] = data
+	}
+	return archive, nil
+}
+
+// ErasureReport lists what an erasure removed and whether it was verified
+type ErasureReport struct {
+	DeletedKeys map[string]int64 `json:"deletedKeys"`
+	Verified    bool             `json:"verified"`
+	Remaining   []string         `json:"remaining,omitempty"`
+}
+
+// EraseShopperData deletes the shopper's data from every registered source
+// and from extra, then re-checks each source to verify nothing is left
+// behind
+func (s *Store) EraseShopperData(ctx context.Context, id ShopperIdentity, extra ...ShopperDataSource) (ErasureReport, error) {
+	report := ErasureReport{DeletedKeys: map[string]int64{}}
+	sources := append(s.registeredShopperDataSources(), extra...)
+
+	for _, source := range sources {
+		if source.Erase != nil {
+			if err := source.Erase(ctx, id); err != nil {
+				return report, fmt.Errorf("failed to erase %s: %v", source.Name, err)
+			}
+		}
+		if source.Keys == nil {
+			continue
+		}
+
+		keys, err := source.Keys(ctx, id)
+		if err != nil {
+			return report, fmt.Errorf("failed to list %s keys: %v", source.Name, err)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		deleted, err := config.DeleteKeys(ctx, s.client, keys...)
+		if err != nil {
+			return report, fmt.Errorf("failed to erase %s: %v", source.Name, err)
+		}
+		report.DeletedKeys[source.Name] = deleted
+	}
+
+	// Verify every keyspace is empty for the shopper
+	for _, source := range sources {
+		if source.Remaining != nil {
+			remaining, err := source.Remaining(ctx, id)
+			if err != nil {
+				return report, fmt.Errorf("failed to verify %s: %v", source.Name, err)
+			}
+			if remaining > 0 {
+				report.Remaining = append(report.Remaining, source.Name)
+				continue
+			}
+		}
+		if source.Keys == nil {
+			continue
+		}
+
+		keys, err := source.Keys(ctx, id)
+		if err != nil {
+			return report, fmt.Errorf("failed to verify %s: %v", source.Name, err)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		remaining, err := config.CountExisting(ctx, s.client, keys...)
+		if err != nil {
+			return report, fmt.Errorf("failed to verify %s: %v", source.Name, err)
+		}
+		if remaining > 0 {
+			report.Remaining = append(report.Remaining, source.Name)
+		}
+	}
+
+	report.Verified = len(report.Remaining) == 0
+	return report, nil
+}
+
+// GDPRAuditRecord is an append-only record of a data subject request.
+// Identifiers are stored hashed so the audit log holds no personal data.
+type GDPRAuditRecord struct {
+	ID             string         `json:"id"`
+	Action         string         `json:"action"`
+	IdentifierType string         `json:"identifierType"`
+	IdentifierHash string         `json:"identifierHash"`
+	Tenant         string         `json:"tenant"`
+	Actor          string         `json:"actor"`
+	Sessions       int            `json:"sessions"`
+	Status         string         `json:"status"`
+	Erasure        *ErasureReport `json:"erasure,omitempty"`
+	At             time.Time      `json:"at"`
+}
+
+func gdprAuditKey(tenant string) string {
+	return "gdpr:audit:" + tenant
+}
+
+// AppendGDPRAudit stores an audit record for a data subject request in its
+// tenant's audit trail
+func (s *Store) AppendGDPRAudit(ctx context.Context, record GDPRAuditRecord) error {
+	if record.ID == "" {
+		id, err := randomHex(8)
+		if err != nil {
+			return fmt.Errorf("failed to generate audit id: %v", err)
+		}
+		record.ID = id
+	}
+	if record.At.IsZero() {
+		record.At = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %v", err)
+	}
+	if err := s.client.RPush(ctx, gdprAuditKey(record.Tenant), data).Err(); err != nil {
+		return fmt.Errorf("failed to store audit record: %v", err)
+	}
+	return nil
+}
+
+// ListGDPRAudit returns the most recent audit records of tenant, newest
+// first
+func (s *Store) ListGDPRAudit(ctx context.Context, tenant string, limit int64) ([]GDPRAuditRecord, error) {
+	raw, err := s.client.LRange(ctx, gdprAuditKey(tenant), -limit, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit records: %v", err)
+	}
+
+	records := make([]GDPRAuditRecord, 0, len(raw))
+	for i := len(raw) - 1; i >= 0; i-- {
+		var record GDPRAuditRecord
+		if err := json.Unmarshal([]byte(raw[i]), &record); err == nil {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func init() {
+	// The identifier index itself links a shopper to their sessions
+	RegisterShopperDataSource(func(s *Store) ShopperDataSource {
+		return ShopperDataSource{
+			Name: "identityIndex",
+			Export: func(ctx context.Context, id ShopperIdentity) (interface{}, error) {
+				return id, nil
+			},
+			Keys: func(ctx context.Context, id ShopperIdentity) ([]string, error) {
+				var keys []string
+				for _, email := range id.Emails {
+					keys = append(keys, shopperIndexKey(id.Tenant, IdentifierEmail, email))
+				}
+				if id.CustomerID != "" {
+					keys = append(keys, shopperIndexKey(id.Tenant, IdentifierCustomer, id.CustomerID))
+				}
+				for _, visitorID := range id.VisitorIDs {
+					keys = append(keys, shopperIndexKey(id.Tenant, IdentifierVisitor, visitorID))
+				}
+				for _, sessionID := range id.SessionIDs {
+					keys = append(keys, sessionVisitorKey(sessionID), sessionIdentityKey(sessionID), sessionTenantKey(sessionID))
+				}
+				return keys, nil
+			},
+		}
+	})
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"github.com/go-redis/redis/v8"
+)
+
+// Store reads and writes the models kept in Redis. It is built from the
+// client the caller was given, so the server, the CLI and tests each work
+// against their own connection. A Store with a nil client skips optional
+// writes, such as transcripts, and fails the rest.
+type Store struct {
+	client redis.UniversalClient
+}
+
+// NewStore returns a Store using client
+func NewStore(client redis.UniversalClient) *Store {
+	return &Store{client: client}
+}
+
+// Connected reports whether the Store has a Redis client
+func (s *Store) Connected() bool {
+	return s != nil && s.client != nil
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func tokenUsageKey(tenant string, day time.Time) string {
+	return fmt.Sprintf("llm:tokens:%s:%s", tenant, day.UTC().Format("20060102"))
+}
+
+// TokenBudgetExhausted reports whether tenant has used up today's budget.
+// A budget of zero means unlimited.
+func (s *Store) TokenBudgetExhausted(ctx context.Context, tenant string, budget int64) (bool, error) {
+	if budget <= 0 || s.client == nil {
+		return false, nil
+	}
+
+	used, err := s.client.Get(ctx, tokenUsageKey(tenant, time.Now())).Int64()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read token usage: %v", err)
+	}
+
+	return used >= budget, nil
+}
+
+// RecordTokenUsage adds tokens to tenant's usage for today
+func (s *Store) RecordTokenUsage(ctx context.Context, tenant string, tokens int64) error {
+	if tokens <= 0 || s.client == nil {
+		return nil
+	}
+
+	key := tokenUsageKey(tenant, time.Now())
+	pipe := s.client.TxPipeline()
+	pipe.IncrBy(ctx, key, tokens)
+	pipe.Expire(ctx, key, 48*time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record token usage: %v", err)
+	}
+	return nil
+}
+
+// NextBudgetReset returns when the daily budget window rolls over (UTC midnight)
+func NextBudgetReset(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const transcriptTTL = 30 * 24 * time.Hour
+
+// TranscriptEntry is one redacted chat message. Transcripts must only ever
+// contain text that has been through the redact package.
+type TranscriptEntry struct {
+	Role string    `json:"role"`
+	Text string    `json:"text"`
+	At   time.Time `json:"at"`
+}
+
+func transcriptKey(sessionID string) string {
+	return "chat:transcript:" + sessionID
+}
+
+// AppendTranscript appends entries to a session transcript and refreshes its TTL
+func (s *Store) AppendTranscript(ctx context.Context, sessionID string, entries ...TranscriptEntry) error {
+	if s.client == nil || len(entries) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode transcript entry: %v", err)
+		}
+		values = append(values, data)
+	}
+
+	key := transcriptKey(sessionID)
+	pipe := s.client.TxPipeline()
+	pipe.RPush(ctx, key, values...)
+	pipe.Expire(ctx, key, transcriptTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store transcript: %v", err)
+	}
+	return nil
+}
+
+// GetTranscript returns the stored transcript of a session
+func (s *Store) GetTranscript(ctx context.Context, sessionID string) ([]TranscriptEntry, error) {
+	raw, err := s.client.LRange(ctx, transcriptKey(sessionID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load transcript: %v", err)
+	}
+
+	entries := make([]TranscriptEntry, 0, len(raw))
+	for _, item := range raw {
+		var entry TranscriptEntry
+		if err := json.Unmarshal([]byte(item), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// NewSessionID returns a random chat session identifier
+func NewSessionID() (string, error) {
+	return randomHex(16)
+}
+
+func init() {
+	RegisterShopperDataSource(func(s *Store) ShopperDataSource {
+		return ShopperDataSource{
+			Name: "chatTranscripts",
+			Export: func(ctx context.Context, id ShopperIdentity) (interface{}, error) {
+				transcripts := map[string][]TranscriptEntry{}
+				for _, sessionID := range id.SessionIDs {
+					entries, err := s.GetTranscript(ctx, sessionID)
+					if err != nil {
+						return nil, err
+					}
+					if len(entries) > 0 {
+						transcripts[sessionID] = entries
+					}
+				}
+				return transcripts, nil
+			},
+			Keys: func(ctx context.Context, id ShopperIdentity) ([]string, error) {
+				keys := make([]string, 0, len(id.SessionIDs))
+				for _, sessionID := range id.SessionIDs {
+					keys = append(keys, transcriptKey(sessionID))
+				}
+				return keys, nil
+			},
+		}
+	})
+}
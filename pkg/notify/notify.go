@@ -0,0 +1,227 @@
+// Package notify delivers shopper notifications, such as back-in-stock
+// alerts, by email or to a shopper-registered webhook.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"strategy-fox-go-bd/pkg/config"
+)
+
+// Delivery channels
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// Notification is one message to one recipient. Email recipients get
+// Subject and Body; webhooks receive Data as a JSON POST.
+type Notification struct {
+	Channel string
+	Target  string
+	Subject string
+	Body    string
+	Data    map[string]any
+}
+
+// Notifier delivers notifications
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// New returns a Notifier routing each channel to the implementation
+// selected in cfg
+func New(cfg config.BackInStockConfig) Notifier {
+	log := NewLog()
+	router := channelRouter{ChannelEmail: log, ChannelWebhook: log}
+	if strings.EqualFold(cfg.EmailNotifier, "smtp") {
+		router[ChannelEmail] = &SMTPNotifier{
+			Addr:     net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+			Host:     cfg.SMTPHost,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword.Value(),
+			From:     cfg.SMTPFrom,
+		}
+	}
+	if strings.EqualFold(cfg.WebhookNotifier, "http") {
+		router[ChannelWebhook] = &WebhookNotifier{Client: NewWebhookClient(10 * time.Second)}
+	}
+	return router
+}
+
+// channelRouter dispatches notifications on their channel
+type channelRouter map[string]Notifier
+
+func (r channelRouter) Notify(ctx context.Context, n Notification) error {
+	notifier, ok := r[n.Channel]
+	if !ok {
+		return fmt.Errorf("unknown notification channel %q", n.Channel)
+	}
+	return notifier.Notify(ctx, n)
+}
+
+// SMTPNotifier sends plain-text email. Username may be empty for relays
+// that do not authenticate.
+type SMTPNotifier struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPNotifier) Notify(ctx context.Context, n Notification) error {
+	if strings.ContainsAny(n.Target, "\r\n") || strings.ContainsAny(n.Subject, "\r\n") {
+		return fmt.Errorf("invalid email header value")
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", s.From, n.Target, n.Subject)
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	if err := smtp.SendMail(s.Addr, auth, s.From, []string{n.Target}, msg.Bytes()); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return nil
+}
+
+// ErrNonPublicAddress is returned for webhooks resolving to an address
+// inside the network, such as loopback, private or link-local ones
+var ErrNonPublicAddress = errors.New("webhook address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range, which IsPrivate misses
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// PublicAddress reports whether ip is reachable on the public internet
+func PublicAddress(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// PublicHost reports whether host may be a webhook target before it is
+// resolved: IP literals must be public and local names are refused. The
+// client from NewWebhookClient checks the resolved address again.
+func PublicHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		return PublicAddress(ip)
+	}
+	if host == "" || !strings.Contains(host, ".") {
+		return false
+	}
+	for _, suffix := range []string{".localhost", ".local", ".internal"} {
+		if strings.HasSuffix(host, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewWebhookClient returns a client for shopper-registered URLs. It checks
+// every address it connects to after DNS resolution, so a public name
+// rebinding to an internal address is refused, bypasses proxies and does
+// not follow redirects.
+func NewWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !PublicAddress(ip) {
+				return fmt.Errorf("%w: %s", ErrNonPublicAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     30 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// WebhookNotifier POSTs Data as JSON to the target URL and expects a 2xx.
+// Redirects count as failures. A nil Client uses NewWebhookClient.
+type WebhookNotifier struct {
+	Client *http.Client
+}
+
+func (h *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := h.Client
+	if client == nil {
+		client = NewWebhookClient(10 * time.Second)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// LogNotifier logs notifications instead of delivering them and keeps
+// them in memory, for development and tests. Targets are not logged.
+type LogNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+// NewLog returns an empty LogNotifier
+func NewLog() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (l *LogNotifier) Notify(ctx context.Context, n Notification) error {
+	slog.InfoContext(ctx, "notification", slog.String("channel", n.Channel), slog.String("subject", n.Subject))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sent = append(l.sent, n)
+	return nil
+}
+
+// Sent returns the notifications recorded so far
+func (l *LogNotifier) Sent() []Notification {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Notification(nil), l.sent...)
+}
@@ -0,0 +1,222 @@
+// Package redact replaces personal data in chat messages with reversible
+// placeholder tokens so that emails, phone numbers, street addresses and
+// card numbers never reach the LLM provider or stored transcripts.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Kind is a category of personal data
+type Kind string
+
+const (
+	KindCard    Kind = "CARD"
+	KindEmail   Kind = "EMAIL"
+	KindPhone   Kind = "PHONE"
+	KindAddress Kind = "ADDRESS"
+)
+
+type detector struct {
+	kind    Kind
+	pattern *regexp.Regexp
+	valid   func(match string) bool
+	// restorable reports whether the original may be put back into a reply
+	restorable bool
+}
+
+// detectors run in order; card numbers go first so their digits are not
+// mistaken for phone numbers. Phone numbers need a leading + or separators
+// between digit groups, so bare catalog IDs are left alone.
+var detectors = []detector{
+	{
+		kind:    KindCard,
+		pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		valid:   func(m string) bool { return luhnValid(digitsOf(m)) },
+	},
+	{
+		kind:       KindEmail,
+		pattern:    regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}\b`),
+		restorable: true,
+	},
+	{
+		kind:       KindPhone,
+		pattern:    regexp.MustCompile(`\+\d{1,3}[\s.-]?(?:\(\d{1,5}\)[\s.-]?)?\d{1,5}(?:[\s.-]?\d{1,5}){0,5}\b|(?:\(\d{2,5}\)[\s.-]?|\b\d{2,5}[\s.-])\d{2,5}(?:[\s.-]\d{2,5}){0,3}\b`),
+		valid:      func(m string) bool { n := len(digitsOf(m)); return n >= 10 && n <= 15 },
+		restorable: true,
+	},
+	{
+		kind: KindAddress,
+		pattern: regexp.MustCompile(`(?i)\b\d{1,6}[a-z]?\s+(?:[a-z][a-z0-9'.-]*\s+){1,5}` +
+			`(?:street|st|avenue|ave|road|rd|boulevard|blvd|lane|ln|drive|dr|court|ct|place|pl|way|terrace|crescent|close|highway|hwy)\b\.?` +
+			`(?:,?\s*(?:apt|apartment|suite|unit|flat)\.?\s*#?\s*[a-z0-9-]+)?`),
+		restorable: true,
+	},
+}
+
+var tokenPattern = regexp.MustCompile(`\[(CARD|EMAIL|PHONE|ADDRESS)_\d+\]`)
+
+// gidPattern finds Shopify GIDs, whose digits are never personal data
+var gidPattern = regexp.MustCompile(`(?i)gid://shopify/[a-z]+/\d+`)
+
+// orderNumberPattern finds short order numbers such as "#1001" or "order
+// 100234", which phone and address detectors would otherwise take for
+// digits of a personal value. Card numbers are redacted even here.
+var orderNumberPattern = regexp.MustCompile(`(?i)#\d{1,10}\b|\borders?(?:\s+(?:id|number|no\.?))?\s*[:#]?\s*\d{4,10}\b`)
+
+// Redactor tokenizes personal data for one exchange and can restore it in
+// the reply. The token map only lives in memory and is never persisted.
+type Redactor struct {
+	originals map[string]string
+	tokens    map[string]string
+	counts    map[Kind]int
+}
+
+// New returns an empty Redactor
+func New() *Redactor {
+	return &Redactor{
+		originals: map[string]string{},
+		tokens:    map[string]string{},
+		counts:    map[Kind]int{},
+	}
+}
+
+// Redact replaces personal data in text with tokens such as [EMAIL_1]. The
+// same value always maps to the same token within a Redactor.
+func (r *Redactor) Redact(text string) string {
+	for _, d := range detectors {
+		catalogIDs := gidPattern.FindAllStringIndex(text, -1)
+		if d.kind != KindCard {
+			catalogIDs = append(catalogIDs, orderNumbers(text)...)
+		}
+		var b strings.Builder
+		last := 0
+		for _, loc := range d.pattern.FindAllStringIndex(text, -1) {
+			match := text[loc[0]:loc[1]]
+			trimmed := strings.TrimSpace(match)
+			if overlapsAny(loc, catalogIDs) || tokenPattern.MatchString(trimmed) || (d.valid != nil && !d.valid(trimmed)) {
+				continue
+			}
+			b.WriteString(text[last:loc[0]])
+			b.WriteString(strings.Replace(match, trimmed, r.token(d.kind, trimmed), 1))
+			last = loc[1]
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+	return text
+}
+
+// orderNumbers returns the spans of short order numbers in text. A number
+// continued by another digit group is left out, as it may be the start of a
+// phone number.
+func orderNumbers(text string) [][]int {
+	var spans [][]int
+	for _, loc := range orderNumberPattern.FindAllStringIndex(text, -1) {
+		rest := text[loc[1]:]
+		if len(rest) > 1 && strings.ContainsRune(" .-", rune(rest[0])) && rest[1] >= '0' && rest[1] <= '9' {
+			continue
+		}
+		spans = append(spans, loc)
+	}
+	return spans
+}
+
+// overlapsAny reports whether the span loc overlaps one of spans
+func overlapsAny(loc []int, spans [][]int) bool {
+	for _, span := range spans {
+		if loc[0] < span[1] && span[0] < loc[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Restore puts restorable originals back in place of their tokens. Card
+// numbers stay masked so they are never echoed back to the shopper.
+func (r *Redactor) Restore(text string) string {
+	return tokenPattern.ReplaceAllStringFunc(text, func(token string) string {
+		original, ok := r.originals[token]
+		if !ok {
+			return token
+		}
+		if strings.HasPrefix(token, "["+string(KindCard)+"_") {
+			return maskCard(original)
+		}
+		return original
+	})
+}
+
+// Found reports how many values of each kind were redacted
+func (r *Redactor) Found() map[Kind]int {
+	found := make(map[Kind]int, len(r.counts))
+	for k, v := range r.counts {
+		found[k] = v
+	}
+	return found
+}
+
+// Values returns the original values of the given kind found so far
+func (r *Redactor) Values(kind Kind) []string {
+	var values []string
+	for i := 1; i <= r.counts[kind]; i++ {
+		values = append(values, r.originals[fmt.Sprintf("[%s_%d]", kind, i)])
+	}
+	return values
+}
+
+func (r *Redactor) token(kind Kind, value string) string {
+	key := string(kind) + ":" + value
+	if token, ok := r.tokens[key]; ok {
+		return token
+	}
+	r.counts[kind]++
+	token := fmt.Sprintf("[%s_%d]", kind, r.counts[kind])
+	r.tokens[key] = token
+	r.originals[token] = value
+	return token
+}
+
+// Text redacts text with a throwaway Redactor, for logs and storage
+func Text(text string) string {
+	return New().Redact(text)
+}
+
+func digitsOf(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// luhnValid reports whether digits pass the Luhn checksum used by card numbers
+func luhnValid(digits string) bool {
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func maskCard(card string) string {
+	digits := digitsOf(card)
+	return "•••• " + digits[len(digits)-4:]
+}
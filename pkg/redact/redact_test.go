@@ -0,0 +1,70 @@
+package redact
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"email", "I am jane.doe+shop@example.com", "I am [EMAIL_1]"},
+		{"international phone", "call +1 415 555 2671", "call [PHONE_1]"},
+		{"international phone without separators", "call +14155552671", "call [PHONE_1]"},
+		{"local phone with area code", "call (415) 555-2671 please", "call [PHONE_1] please"},
+		{"local phone with spaces", "my number is 030 1234 5678", "my number is [PHONE_1]"},
+		{"card with spaces", "card 4111 1111 1111 1111 ok", "card [CARD_1] ok"},
+		{"card without spaces", "pay with 4111111111111111", "pay with [CARD_1]"},
+		{"street address", "ship to 221B Baker Street tomorrow", "ship to [ADDRESS_1] tomorrow"},
+		{"address with unit", "12 Main St, Apt 4", "[ADDRESS_1]"},
+		{"repeated value", "a@example.com or a@example.com", "[EMAIL_1] or [EMAIL_1]"},
+
+		// Catalog IDs and sizes are not personal data
+		{"product id", "is product 7891234567890 in stock?", "is product 7891234567890 in stock?"},
+		{"product gid", "gid://shopify/Product/8012345678901", "gid://shopify/Product/8012345678901"},
+		{"order number", "where is order 100234", "where is order 100234"},
+		{"order number with hash", "order #1001 is late", "order #1001 is late"},
+
+		// Card numbers are redacted wherever they appear
+		{"luhn-valid bare variant id", "variant 44012345678901 please", "variant [CARD_1] please"},
+		{"card after order", "where is order 4532015112830366", "where is order [CARD_1]"},
+		{"spaced card after order", "pay for order 4111 1111 1111 1111", "pay for order [CARD_1]"},
+		{"phone after order", "order 0301 234 5678", "order [PHONE_1]"},
+		{"variant id", "variant 44012345678902 please", "variant 44012345678902 please"},
+		{"variant gid", "gid://shopify/ProductVariant/44012345678901", "gid://shopify/ProductVariant/44012345678901"},
+		{"bare number", "SKU 7891234567890", "SKU 7891234567890"},
+		{"pack and way", "a 2 pack 3 way dress", "a 2 pack 3 way dress"},
+		{"date", "delivered on 2024-05-01", "delivered on 2024-05-01"},
+		{"sizes", "size 38 in 2 colours", "size 38 in 2 colours"},
+		{"failed checksum", "ref 1234 5678 9012 3456", "ref 1234 5678 9012 3456"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := New().Redact(tt.in); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	r := New()
+	redacted := r.Redact("I am jane@example.com, card 4111 1111 1111 1111, phone +44 20 7946 0958")
+	if redacted != "I am [EMAIL_1], card [CARD_1], phone [PHONE_1]" {
+		t.Fatalf("Redact = %q", redacted)
+	}
+
+	restored := r.Restore("Thanks [EMAIL_1], we will call [PHONE_1] about [CARD_1] and [EMAIL_2]")
+	if want := "Thanks jane@example.com, we will call +44 20 7946 0958 about •••• 1111 and [EMAIL_2]"; restored != want {
+		t.Errorf("Restore = %q, want %q", restored, want)
+	}
+	if found := r.Found(); fmt.Sprint(found) != "map[CARD:1 EMAIL:1 PHONE:1]" {
+		t.Errorf("Found = %v", found)
+	}
+	if values := r.Values(KindEmail); len(values) != 1 || values[0] != "jane@example.com" {
+		t.Errorf("Values = %v", values)
+	}
+}
@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/controllers"
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
+)
+
+var AdminRoutes = func(router *mux.Router, deps Dependencies) {
+	router.Use(middleware.RequireRole(models.RoleAdmin))
+
+	analytics := controllers.NewAnalyticsController(deps.Config, deps.Analytics)
+	gdpr := controllers.NewGDPRController(deps.Store, deps.Analytics)
+	returns := controllers.NewReturnsController(deps.Config, deps.Shopify, deps.Store)
+	promotions := controllers.NewPromotionsController(deps.Config, deps.Shopify, deps.Cache, deps.Store)
+	keys := controllers.NewAPIKeyController(deps.Store)
+
+	router.HandleFunc("/keys", keys.ListAPIKeys).Methods("GET")
+	router.HandleFunc("/keys/{id}", keys.RevokeAPIKey).Methods("DELETE")
+
+	router.HandleFunc("/gdpr/export", gdpr.ExportShopperData).Methods("GET")
+	router.HandleFunc("/gdpr/erase", gdpr.EraseShopperData).Methods("POST")
+	router.HandleFunc("/gdpr/audit", gdpr.ListGDPRAudit).Methods("GET")
+
+	router.HandleFunc("/analytics", analytics.GetAnalyticsSummary).Methods("GET")
+	router.HandleFunc("/analytics/events", analytics.ListAnalyticsEvents).Methods("GET")
+
+	router.HandleFunc("/returns/policy", returns.GetReturnPolicy).Methods("GET")
+	router.HandleFunc("/returns/policy", returns.UpdateReturnPolicy).Methods("PUT")
+
+	router.HandleFunc("/promotions", promotions.ListAdminPromotions).Methods("GET")
+	router.HandleFunc("/promotions/overrides", promotions.GetPromotionOverrides).Methods("GET")
+	router.HandleFunc("/promotions/overrides", promotions.UpdatePromotionOverrides).Methods("PUT")
+}
@@ -3,8 +3,34 @@ package routes
 import (
 	"github.com/gorilla/mux"
 	"strategy-fox-go-bd/pkg/controllers"
+	"strategy-fox-go-bd/pkg/middleware"
 )
 
-var ChatBotRoutes = func(router *mux.Router) {
-	router.HandleFunc("/chat", controllers.HandleChat).Methods("POST")
+var ChatBotRoutes = func(router *mux.Router, deps Dependencies) {
+	chat := controllers.NewChatController(deps.Config, deps.Shopify, deps.Cache, deps.LLM, deps.Store, deps.Analytics)
+	returns := controllers.NewReturnsController(deps.Config, deps.Shopify, deps.Store)
+	backInStock := controllers.NewBackInStockController(deps.Config, deps.Shopify, deps.Store, deps.Notifier)
+
+	router.Use(middleware.LimitBody(16 << 10))
+	router.Use(middleware.RateLimitMiddleware(deps.Redis, middleware.PerMinute("chat", deps.Config.RateLimit.ChatPerMinute)))
+
+	router.HandleFunc("/chat", chat.HandleChat).Methods("POST")
+
+	router.HandleFunc("/profile", chat.GetProfile).Methods("GET")
+	router.HandleFunc("/profile", chat.UpdateProfile).Methods("PUT")
+	router.HandleFunc("/profile", chat.DeleteProfile).Methods("DELETE")
+	router.HandleFunc("/profile/products", chat.RecordProfileProduct).Methods("POST")
+
+	router.HandleFunc("/identity", chat.LinkIdentity).Methods("POST")
+	router.HandleFunc("/identity", chat.GetIdentity).Methods("GET")
+	router.HandleFunc("/identity", chat.UnlinkIdentity).Methods("DELETE")
+
+	router.HandleFunc("/returns/eligibility", returns.GetReturnEligibility).Methods("GET")
+	router.HandleFunc("/returns/exchange-options", returns.GetExchangeOptions).Methods("GET")
+	router.HandleFunc("/returns", returns.CreateReturn).Methods("POST")
+	router.HandleFunc("/returns/{id}", returns.GetReturn).Methods("GET")
+
+	router.HandleFunc("/back-in-stock", backInStock.Subscribe).Methods("POST")
+	// Unsubscribe links are opened from email, so GET is accepted too
+	router.HandleFunc("/back-in-stock/{id}/unsubscribe", backInStock.Unsubscribe).Methods("GET", "POST")
 }
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/controllers"
+)
+
+// HealthRoutes registers the probes. They are mounted outside the API router
+// so they skip CORS, authentication, request logging and rate limits.
+var HealthRoutes = func(router *mux.Router, deps Dependencies) {
+	health := controllers.NewHealthController(deps.Config, deps.Shopify, deps.Redis)
+
+	router.HandleFunc("/healthz", health.Healthz).Methods("GET")
+	router.HandleFunc("/readyz", health.Readyz).Methods("GET")
+}
@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+
+	"strategy-fox-go-bd/pkg/controllers"
+)
+
+func TestHealthz(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := s.do(t, "GET", "/healthz", "")
+	expectStatus(t, rec, http.StatusOK)
+}
+
+func TestReadyz(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["ReadinessCheck"] = `{"data": {"shop": {"name": "Sause"}}}`
+	s.shopify.admin["ReadinessCheck"] = `{"data": {"shop": {"name": "Sause"}}}`
+
+	rec := s.do(t, "GET", "/readyz", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var resp controllers.ReadinessResponse
+	decodeBody(t, rec, &resp)
+	for name, check := range resp.Checks {
+		if !check.OK {
+			t.Errorf("check %s failed: %s", name, check.Error)
+		}
+	}
+}
+
+func TestReadyzReportsRejectedShopifyToken(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := s.do(t, "GET", "/readyz", "")
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+
+	var resp controllers.ReadinessResponse
+	decodeBody(t, rec, &resp)
+	if resp.Status != "unavailable" || resp.Checks["shopify"].OK || !resp.Checks["redis"].OK {
+		t.Errorf("readiness = %+v, want only the Shopify check failing", resp)
+	}
+}
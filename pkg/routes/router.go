@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/rs/cors"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/controllers"
+	"strategy-fox-go-bd/pkg/metrics"
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/notify"
+	"strategy-fox-go-bd/pkg/tracing"
+)
+
+// Dependencies are the services the route handlers are built from. main
+// wires the real Shopify, Redis and Gemini implementations; tests pass fakes.
+type Dependencies struct {
+	Config  *config.Config
+	Shopify controllers.ShopifyClient
+	Cache   controllers.Cache
+	LLM     controllers.LLMProvider
+	Redis   redis.UniversalClient
+	// Store keeps sessions, profiles, API keys and the other Redis models
+	Store *models.Store
+	// Analytics records chat events; nil disables analytics
+	Analytics models.AnalyticsStore
+	// Notifier delivers back-in-stock notifications; nil only logs them
+	Notifier notify.Notifier
+}
+
+// NewRouter assembles the full HTTP handler: the health probes, and the
+// API routes behind CORS, request logging and authentication
+func NewRouter(deps Dependencies) http.Handler {
+	corsMiddleware := cors.New(cors.Options{
+		AllowedOrigins: deps.Config.Server.AllowedOrigins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key", "X-Session-ID", "X-Visitor-ID", "X-Request-ID", "traceparent", "tracestate", "baggage"},
+		ExposedHeaders: []string{"Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-Request-ID"},
+	})
+
+	router := mux.NewRouter()
+	router.Use(otelmux.Middleware(tracing.ServiceName))
+	router.Use(middleware.ClientContext(deps.Config))
+	router.Use(middleware.RequestLogger)
+	router.Use(middleware.Authenticate(deps.Config, deps.Store))
+
+	// Scrapers authenticate with an admin API key
+	router.Handle("/metrics", middleware.RequireRole(models.RoleAdmin)(metrics.Handler())).Methods("GET")
+
+	shopifyRouter := router.PathPrefix("/api/shopify").Subrouter()
+	chatbotRouter := router.PathPrefix("/api/chatbot").Subrouter()
+	adminRouter := router.PathPrefix("/api/admin").Subrouter()
+	webhookRouter := router.PathPrefix("/api/webhooks").Subrouter()
+
+	ShopifyRoutes(shopifyRouter, deps)
+	ChatBotRoutes(chatbotRouter, deps)
+	AdminRoutes(adminRouter, deps)
+	WebhookRoutes(webhookRouter, deps)
+
+	rootRouter := mux.NewRouter()
+	HealthRoutes(rootRouter, deps)
+	rootRouter.PathPrefix("/").Handler(corsMiddleware.Handler(router))
+
+	return rootRouter
+}
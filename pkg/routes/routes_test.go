@@ -0,0 +1,285 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"strategy-fox-go-bd/pkg/config"
+	"strategy-fox-go-bd/pkg/controllers"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/notify"
+	"strategy-fox-go-bd/pkg/testsupport"
+)
+
+// operationPattern extracts the operation name of a GraphQL document
+var operationPattern = regexp.MustCompile(`^\s*(?:query|mutation)\s+([A-Za-z_][A-Za-z0-9_]*)`)
+
+// shopifyCall is one operation received by fakeShopify
+type shopifyCall struct {
+	API       string
+	Operation string
+	Variables map[string]interface{}
+}
+
+// fakeShopify answers GraphQL operations by name with canned bodies.
+// Anonymous operations are looked up as "anonymous".
+type fakeShopify struct {
+	mu         sync.Mutex
+	storefront map[string]string
+	admin      map[string]string
+	calls      []shopifyCall
+}
+
+func newFakeShopify() *fakeShopify {
+	return &fakeShopify{storefront: map[string]string{}, admin: map[string]string{}}
+}
+
+func (f *fakeShopify) Storefront(ctx context.Context, query string, variables map[string]interface{}) ([]byte, error) {
+	return f.respond("storefront", f.storefront, query, variables)
+}
+
+func (f *fakeShopify) Admin(ctx context.Context, query string, variables map[string]interface{}) ([]byte, error) {
+	return f.respond("admin", f.admin, query, variables)
+}
+
+func (f *fakeShopify) respond(api string, responses map[string]string, query string, variables map[string]interface{}) ([]byte, error) {
+	operation := "anonymous"
+	if match := operationPattern.FindStringSubmatch(query); match != nil {
+		operation = match[1]
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, shopifyCall{API: api, Operation: operation, Variables: variables})
+
+	body, ok := responses[operation]
+	if !ok {
+		return nil, fmt.Errorf("GraphQL query failed with status: 500, response: no fixture for %s %s", api, operation)
+	}
+	return []byte(body), nil
+}
+
+// callsTo returns the recorded calls of an operation
+func (f *fakeShopify) callsTo(operation string) []shopifyCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var calls []shopifyCall
+	for _, call := range f.calls {
+		if call.Operation == operation {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// fakeLLM replies with a fixed text, first making the configured tool
+// calls and recording what the tools returned. JSON calls are answered from
+// jsonReplies by call name, defaulting to "{}".
+type fakeLLM struct {
+	mu            sync.Mutex
+	reply         string
+	toolCalls     []genai.FunctionCall
+	toolResponses []genai.FunctionResponse
+	messages      []string
+	systems       []string
+	offeredTools  [][]string
+	jsonReplies   map[string]string
+	jsonCalls     []string
+}
+
+func (f *fakeLLM) Chat(ctx context.Context, req controllers.LLMChatRequest) (controllers.LLMResult, error) {
+	result := controllers.LLMResult{
+		Text:  f.reply,
+		Usage: &genai.UsageMetadata{PromptTokenCount: 8, CandidatesTokenCount: 4, TotalTokenCount: 12},
+	}
+	var responses []genai.FunctionResponse
+	for _, call := range f.toolCalls {
+		result.ToolCalls = append(result.ToolCalls, call.Name)
+		responses = append(responses, req.RunTool(ctx, call))
+	}
+
+	var offered []string
+	for _, tool := range req.Tools {
+		for _, declaration := range tool.FunctionDeclarations {
+			offered = append(offered, declaration.Name)
+		}
+	}
+	sort.Strings(offered)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, req.Message)
+	var system strings.Builder
+	for _, part := range req.System {
+		if text, ok := part.(genai.Text); ok {
+			system.WriteString(string(text))
+		}
+	}
+	f.systems = append(f.systems, system.String())
+	f.offeredTools = append(f.offeredTools, offered)
+	f.toolResponses = append(f.toolResponses, responses...)
+	return result, nil
+}
+
+func (f *fakeLLM) GenerateJSON(ctx context.Context, req controllers.LLMJSONRequest) (controllers.LLMResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jsonCalls = append(f.jsonCalls, req.Call)
+
+	text, ok := f.jsonReplies[req.Call]
+	if !ok {
+		text = "{}"
+	}
+	return controllers.LLMResult{
+		Text:  text,
+		Usage: &genai.UsageMetadata{PromptTokenCount: 3, CandidatesTokenCount: 1, TotalTokenCount: 4},
+	}, nil
+}
+
+// jsonCallsTo counts the JSON calls made for call
+func (f *fakeLLM) jsonCallsTo(call string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, c := range f.jsonCalls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+// testServer is the full router backed by fakes and an in-memory Redis.
+// shopify is nil when the server talks to a testsupport.FakeShopify.
+type testServer struct {
+	handler  http.Handler
+	shopify  *fakeShopify
+	llm      *fakeLLM
+	cfg      *config.Config
+	store    *models.Store
+	notifier *notify.LogNotifier
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	shopify := newFakeShopify()
+	s := buildTestServer(t, testConfig(), shopify)
+	s.shopify = shopify
+	return s
+}
+
+// testConfig returns the defaults with the secrets the handlers require
+func testConfig() config.Config {
+	cfg := config.Defaults()
+	cfg.LLM.GeminiAPIKey = "test-key"
+	cfg.Shopify.AdminAccessToken = "test-admin-token"
+	cfg.Shopify.StorefrontSessionSecret = "test-session-secret"
+	cfg.Server.TenantOrigins = []string{"https://doodad.example.com=doodad"}
+	return cfg
+}
+
+// buildTestServer wires the router to shopify, a fake LLM and an in-memory
+// Redis
+func buildTestServer(t *testing.T, cfg config.Config, shopify controllers.ShopifyClient) *testServer {
+	t.Helper()
+	client, _ := testsupport.NewFakeRedis(t)
+
+	llm := &fakeLLM{reply: "Here is what I found."}
+	store := models.NewStore(client)
+	notifier := notify.NewLog()
+	handler := NewRouter(Dependencies{
+		Config:    &cfg,
+		Shopify:   shopify,
+		Cache:     controllers.NewRedisCache(client),
+		LLM:       llm,
+		Redis:     client,
+		Store:     store,
+		Analytics: models.NewAnalyticsStore(cfg.Analytics, client),
+		Notifier:  notifier,
+	})
+
+	return &testServer{handler: handler, llm: llm, cfg: &cfg, store: store, notifier: notifier}
+}
+
+// do serves one request, adding headers given as "Name: value" pairs
+func (s *testServer) do(t *testing.T, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ": ")
+		req.Header.Set(name, value)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// apiKey creates an API key with role in the fake Redis and returns its
+// X-API-Key header
+func (s *testServer) apiKey(t *testing.T, role string) string {
+	t.Helper()
+	return s.tenantAPIKey(t, role, models.DefaultTenant)
+}
+
+// tenantAPIKey creates an API key with role for tenant and returns its
+// X-API-Key header
+func (s *testServer) tenantAPIKey(t *testing.T, role, tenant string) string {
+	t.Helper()
+
+	secret, _, err := s.store.CreateAPIKey(context.Background(), "test "+role, role, tenant)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	return "X-API-Key: " + secret
+}
+
+// expectStatus fails the test when the response status differs from want
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, want, rec.Body.String())
+	}
+}
+
+// decodeBody decodes the JSON response body into out
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, out interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatalf("decoding body %q: %v", rec.Body.String(), err)
+	}
+}
+
+// errorCode returns the code of an error envelope response
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var envelope struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	decodeBody(t, rec, &envelope)
+	return envelope.Error.Code
+}
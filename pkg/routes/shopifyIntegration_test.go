@@ -0,0 +1,224 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"strategy-fox-go-bd/pkg/controllers"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/testsupport"
+)
+
+// newIntegrationServer serves the router through the real Shopify client,
+// pointed at a fake shop over HTTPS
+func newIntegrationServer(t *testing.T) (*testServer, *testsupport.FakeShopify) {
+	t.Helper()
+
+	shop := testsupport.NewFakeShopify(t)
+	cfg := testConfig()
+	sessionSecret := cfg.Shopify.StorefrontSessionSecret
+	cfg.Shopify = shop.Config()
+	cfg.Shopify.StorefrontSessionSecret = sessionSecret
+
+	return buildTestServer(t, cfg, controllers.NewShopifyClient(cfg.Shopify, shop.Client())), shop
+}
+
+func TestIntegrationGetProductsV2(t *testing.T) {
+	s, _ := newIntegrationServer(t)
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var body struct {
+		Data struct {
+			Products struct {
+				Edges []struct {
+					Node struct {
+						Handle string `json:"handle"`
+					} `json:"node"`
+				} `json:"edges"`
+			} `json:"products"`
+		} `json:"data"`
+	}
+	decodeBody(t, rec, &body)
+	if edges := body.Data.Products.Edges; len(edges) != 2 || edges[0].Node.Handle != "floral-summer-dress" {
+		t.Errorf("products = %+v, want the two active products", edges)
+	}
+}
+
+func TestIntegrationGetProductV2(t *testing.T) {
+	s, _ := newIntegrationServer(t)
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-name/linen-shirt", "")
+	expectStatus(t, rec, http.StatusOK)
+	var byName struct {
+		Data struct {
+			ProductByHandle struct {
+				ID string `json:"id"`
+			} `json:"productByHandle"`
+		} `json:"data"`
+	}
+	decodeBody(t, rec, &byName)
+	if byName.Data.ProductByHandle.ID != "gid://shopify/Product/1002" {
+		t.Errorf("product = %+v, want 1002", byName.Data.ProductByHandle)
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v2/products/by-id/1001", "")
+	expectStatus(t, rec, http.StatusOK)
+	var byID struct {
+		Data struct {
+			Product struct {
+				Title string `json:"title"`
+			} `json:"product"`
+		} `json:"data"`
+	}
+	decodeBody(t, rec, &byID)
+	if byID.Data.Product.Title != "Floral Summer Dress" {
+		t.Errorf("product = %+v, want the floral dress", byID.Data.Product)
+	}
+}
+
+func TestIntegrationGetProductsV3Paginates(t *testing.T) {
+	s, _ := newIntegrationServer(t)
+
+	rec := s.do(t, "GET", "/api/shopify/v3/products?first=1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var page models.ProductList
+	decodeBody(t, rec, &page)
+	if len(page.Products) != 1 || page.Products[0].Handle != "floral-summer-dress" || !page.PageInfo.HasNextPage {
+		t.Fatalf("first page = %+v", page)
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v3/products?first=1&after="+page.PageInfo.EndCursor, "")
+	expectStatus(t, rec, http.StatusOK)
+	page = models.ProductList{}
+	decodeBody(t, rec, &page)
+	if len(page.Products) != 1 || page.Products[0].Handle != "linen-shirt" || page.PageInfo.HasNextPage {
+		t.Errorf("second page = %+v, want the shirt and no further pages", page)
+	}
+}
+
+func TestIntegrationGetProductV3(t *testing.T) {
+	s, _ := newIntegrationServer(t)
+
+	rec := s.do(t, "GET", "/api/shopify/v3/products/by-id/1001", "")
+	expectStatus(t, rec, http.StatusOK)
+	var product models.Product
+	decodeBody(t, rec, &product)
+	if product.Handle != "floral-summer-dress" || len(product.Variants) != 3 || len(product.Media) != 2 {
+		t.Errorf("product = %+v, want three variants and two media", product)
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v3/products/by-name/linen-shirt", "")
+	expectStatus(t, rec, http.StatusOK)
+}
+
+func TestIntegrationDraftProductIsHidden(t *testing.T) {
+	s, _ := newIntegrationServer(t)
+
+	rec := s.do(t, "GET", "/api/shopify/v3/products/by-name/wool-winter-coat", "")
+	expectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestIntegrationUpdateMetafield(t *testing.T) {
+	s, shop := newIntegrationServer(t)
+	body := `{"id": "gid://shopify/Product/1001", "namespace": "custom", "key": "fit", "value": "runs small", "type": "single_line_text_field"}`
+
+	rec := s.do(t, "POST", "/api/shopify/product/metafield", body, s.apiKey(t, models.RoleAdmin))
+	expectStatus(t, rec, http.StatusOK)
+
+	product, _ := shop.Product("floral-summer-dress")
+	if len(product.Metafields) != 1 || product.Metafields[0].Value != "runs small" {
+		t.Errorf("metafields = %+v, want custom.fit overwritten", product.Metafields)
+	}
+}
+
+func TestIntegrationUpdateMetafieldPassesUserErrors(t *testing.T) {
+	s, shop := newIntegrationServer(t)
+	body := `{"id": "gid://shopify/Product/9999", "namespace": "custom", "key": "fit", "value": "relaxed", "type": "single_line_text_field"}`
+
+	rec := s.do(t, "POST", "/api/shopify/product/metafield", body, s.apiKey(t, models.RoleAdmin))
+	expectStatus(t, rec, http.StatusOK)
+
+	var resp struct {
+		Data struct {
+			ProductUpdate struct {
+				UserErrors []testsupport.FakeUserError `json:"userErrors"`
+			} `json:"productUpdate"`
+		} `json:"data"`
+	}
+	decodeBody(t, rec, &resp)
+	if errs := resp.Data.ProductUpdate.UserErrors; len(errs) != 1 || errs[0].Message != "Product does not exist" {
+		t.Errorf("userErrors = %+v", errs)
+	}
+
+	shop.RejectNextMutation(testsupport.FakeUserError{Field: []string{"metafields", "0", "value"}, Message: "Value is invalid"})
+	body = `{"id": "gid://shopify/Product/1001", "namespace": "custom", "key": "fit", "value": "relaxed", "type": "single_line_text_field"}`
+	rec = s.do(t, "POST", "/api/shopify/product/metafield", body, s.apiKey(t, models.RoleAdmin))
+	expectStatus(t, rec, http.StatusOK)
+
+	if product, _ := shop.Product("floral-summer-dress"); product.Metafields[0].Value != "true to size" {
+		t.Errorf("rejected mutation changed the metafield to %q", product.Metafields[0].Value)
+	}
+}
+
+func TestIntegrationThrottledRequest(t *testing.T) {
+	s, shop := newIntegrationServer(t)
+	shop.Throttle(1)
+
+	rec := s.do(t, "GET", "/api/shopify/v3/products/by-id/1001", "")
+	expectStatus(t, rec, http.StatusBadGateway)
+
+	rec = s.do(t, "GET", "/api/shopify/v3/products/by-id/1001", "")
+	expectStatus(t, rec, http.StatusOK)
+}
+
+func TestIntegrationUpstreamFailure(t *testing.T) {
+	s, shop := newIntegrationServer(t)
+	shop.FailNext(http.StatusServiceUnavailable, http.StatusInternalServerError)
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-id/1001", "")
+	expectStatus(t, rec, http.StatusInternalServerError)
+
+	rec = s.do(t, "GET", "/api/shopify/v3/products/by-id/1001", "")
+	expectStatus(t, rec, http.StatusBadGateway)
+	if code := errorCode(t, rec); code != "upstream_error" {
+		t.Errorf("code = %q, want upstream_error", code)
+	}
+}
+
+func TestIntegrationReadyz(t *testing.T) {
+	s, shop := newIntegrationServer(t)
+
+	rec := s.do(t, "GET", "/readyz", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	if requests := shop.RequestsFor("ReadinessCheck"); len(requests) != 2 {
+		t.Errorf("readiness requests = %+v, want one per API", requests)
+	}
+}
+
+func TestIntegrationChatSearchProductsTool(t *testing.T) {
+	s, shop := newIntegrationServer(t)
+	s.llm.toolCalls = []genai.FunctionCall{{Name: "search_products", Args: map[string]any{"query": "tag:floral"}}}
+
+	rec := s.do(t, "POST", "/api/chatbot/chat", `{"userInput": "Anything floral?"}`)
+	expectStatus(t, rec, http.StatusOK)
+
+	if len(s.llm.toolResponses) != 1 {
+		t.Fatalf("tool responses = %+v, want 1", s.llm.toolResponses)
+	}
+	raw, _ := json.Marshal(s.llm.toolResponses[0].Response["products"])
+	var products []struct {
+		Handle string `json:"handle"`
+	}
+	json.Unmarshal(raw, &products)
+	if len(products) != 1 || products[0].Handle != "floral-summer-dress" {
+		t.Errorf("tool response = %s, want only the floral dress", raw)
+	}
+	if requests := shop.RequestsFor("SearchProducts"); len(requests) != 1 || requests[0].API != "storefront" {
+		t.Errorf("requests = %+v, want one Storefront search", requests)
+	}
+}
@@ -1,17 +1,41 @@
 package routes
 
 import (
+	"net/http"
+
 	"github.com/gorilla/mux"
 	"strategy-fox-go-bd/pkg/controllers"
+	"strategy-fox-go-bd/pkg/middleware"
+	"strategy-fox-go-bd/pkg/models"
 )
 
-var ShopifyRoutes = func(router *mux.Router) {
+var ShopifyRoutes = func(router *mux.Router, deps Dependencies) {
+	shopify := controllers.NewShopifyController(deps.Shopify, deps.Cache)
+	promotions := controllers.NewPromotionsController(deps.Config, deps.Shopify, deps.Cache, deps.Store)
+	inventory := controllers.NewInventoryController(deps.Config, deps.Shopify, deps.Cache)
+
+	router.Use(middleware.LimitBody(64 << 10))
+	router.Use(middleware.RateLimitMiddleware(deps.Redis, middleware.PerMinute("catalog", deps.Config.RateLimit.CatalogPerMinute)))
+
 	//router.HandleFunc("/v1/products", controllers.GetProducts).Methods("GET")
-	router.HandleFunc("/v2/products", controllers.GetProductsGQ).Methods("GET")
+	router.HandleFunc("/v2/products", shopify.GetProductsGQ).Methods("GET")
 	//router.HandleFunc("/products/{id}", controllers.GetProduct).Methods("GET")
 	//router.HandleFunc("/products/{id}/model", controllers.GetModel).Methods("GET")
-	router.HandleFunc("/v2/products/by-name/{name}", controllers.GetProductByNameGQ).Methods("GET")
-	router.HandleFunc("/v2/products/by-id/{id}", controllers.GetProductByIdGQ).Methods("GET")
-	router.HandleFunc("/product/metafield", controllers.UpdateMetafieldById).Methods("POST")
+	router.HandleFunc("/v2/products/by-name/{name}", shopify.GetProductByNameGQ).Methods("GET")
+	router.HandleFunc("/v2/products/by-id/{id}", shopify.GetProductByIdGQ).Methods("GET")
+	router.Handle("/product/metafield", middleware.RequireRole(models.RoleAdmin)(http.HandlerFunc(shopify.UpdateMetafieldById))).Methods("POST")
+
+	router.HandleFunc("/v3/products", shopify.GetProductsV3).Methods("GET")
+	router.HandleFunc("/v3/products/by-name/{name}", shopify.GetProductByNameV3).Methods("GET")
+	router.HandleFunc("/v3/products/by-id/{id}", shopify.GetProductByIdV3).Methods("GET")
+
+	router.HandleFunc("/v2/products/by-id/{id}/inventory", inventory.GetInventoryById).Methods("GET")
+	router.HandleFunc("/v2/products/by-name/{name}/inventory", inventory.GetInventoryByName).Methods("GET")
+
+	router.HandleFunc("/v2/collections", shopify.GetCollections).Methods("GET")
+	router.HandleFunc("/v2/collections/{handle}", shopify.GetCollectionByHandle).Methods("GET")
+	router.HandleFunc("/v2/collections/{handle}/rules", shopify.GetCollectionRules).Methods("GET")
+
+	router.HandleFunc("/v2/promotions", promotions.GetPromotions).Methods("GET")
 
 }
@@ -0,0 +1,642 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"strategy-fox-go-bd/pkg/controllers"
+	"strategy-fox-go-bd/pkg/models"
+)
+
+const productFixture = `{
+	"id": "gid://shopify/Product/101",
+	"handle": "floral-summer-dress",
+	"title": "Floral Summer Dress",
+	"descriptionHtml": "<p>Light cotton dress.</p>",
+	"vendor": "Sause",
+	"tags": ["summer"],
+	"priceRange": {
+		"minVariantPrice": {"amount": "49.00", "currencyCode": "EUR"},
+		"maxVariantPrice": {"amount": "49.00", "currencyCode": "EUR"}
+	},
+	"options": [{"name": "Size", "values": ["S", "M"]}],
+	"media": {"edges": []},
+	"variants": {"edges": [{"node": {
+		"id": "gid://shopify/ProductVariant/201",
+		"title": "S",
+		"price": {"amount": "49.00", "currencyCode": "EUR"},
+		"availableForSale": true,
+		"selectedOptions": [{"name": "Size", "value": "S"}]
+	}}]}
+}`
+
+const productsFixture = `{"data": {"products": {
+	"edges": [{"node": ` + productFixture + `}],
+	"pageInfo": {"hasNextPage": true, "endCursor": "cursor-1"}
+}}}`
+
+func TestGetProductsV2KeepsAdminResponseShape(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["anonymous"] = productsFixture
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var body struct {
+		Data struct {
+			Products struct {
+				Edges []struct {
+					Node struct {
+						Title           string `json:"title"`
+						DescriptionHTML string `json:"descriptionHtml"`
+						Status          string `json:"status"`
+						Options         []struct {
+							Name     string `json:"name"`
+							Position int    `json:"position"`
+						} `json:"options"`
+						Variants struct {
+							Edges []struct {
+								Node struct {
+									Price string `json:"price"`
+								} `json:"node"`
+							} `json:"edges"`
+						} `json:"variants"`
+					} `json:"node"`
+				} `json:"edges"`
+			} `json:"products"`
+		} `json:"data"`
+	}
+	decodeBody(t, rec, &body)
+	if len(body.Data.Products.Edges) != 1 {
+		t.Fatalf("body = %s, want one product", rec.Body.String())
+	}
+	product := body.Data.Products.Edges[0].Node
+	if product.Status != "ACTIVE" || product.DescriptionHTML != "<p>Light cotton dress.</p>" {
+		t.Errorf("product = %+v, want an ACTIVE product with its description", product)
+	}
+	if len(product.Options) != 1 || product.Options[0].Position != 1 {
+		t.Errorf("options = %+v, want positions from 1", product.Options)
+	}
+	if variants := product.Variants.Edges; len(variants) != 1 || variants[0].Node.Price != "49.00" {
+		t.Errorf("variants = %+v, want the price as an amount string", variants)
+	}
+}
+
+func TestGetProductByNameV2(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["ProductByHandle"] = `{"data": {"productByHandle": ` + productFixture + `}}`
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-name/floral-summer-dress", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	calls := s.shopify.callsTo("ProductByHandle")
+	if len(calls) != 1 || calls[0].Variables["handle"] != "floral-summer-dress" {
+		t.Errorf("calls = %+v, want one ProductByHandle for floral-summer-dress", calls)
+	}
+}
+
+func TestGetProductByIdV2(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["ProductById"] = `{"data": {"product": ` + productFixture + `}}`
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-id/101", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	calls := s.shopify.callsTo("ProductById")
+	if len(calls) != 1 || calls[0].API != "storefront" || calls[0].Variables["id"] != "gid://shopify/Product/101" {
+		t.Errorf("calls = %+v, want one Storefront ProductById for product 101", calls)
+	}
+}
+
+func TestGetProductV2UpstreamError(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-id/101", "")
+	expectStatus(t, rec, http.StatusInternalServerError)
+}
+
+func TestUpdateMetafieldRequiresAdmin(t *testing.T) {
+	s := newTestServer(t)
+	body := `{"id": "gid://shopify/Product/101", "namespace": "custom", "key": "fit", "value": "relaxed", "type": "single_line_text_field"}`
+
+	rec := s.do(t, "POST", "/api/shopify/product/metafield", body)
+	expectStatus(t, rec, http.StatusUnauthorized)
+
+	rec = s.do(t, "POST", "/api/shopify/product/metafield", body, s.apiKey(t, models.RoleAgent))
+	expectStatus(t, rec, http.StatusForbidden)
+
+	if calls := s.shopify.callsTo("UpdateProductMetafield"); len(calls) != 0 {
+		t.Errorf("rejected requests reached Shopify: %+v", calls)
+	}
+}
+
+func TestUpdateMetafield(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.admin["UpdateProductMetafield"] = `{"data": {"productUpdate": {"product": {"id": "gid://shopify/Product/101"}, "userErrors": []}}}`
+	body := `{"id": "gid://shopify/Product/101", "namespace": "custom", "key": "fit", "value": "relaxed", "type": "single_line_text_field"}`
+
+	rec := s.do(t, "POST", "/api/shopify/product/metafield", body, s.apiKey(t, models.RoleAdmin))
+	expectStatus(t, rec, http.StatusOK)
+
+	calls := s.shopify.callsTo("UpdateProductMetafield")
+	if len(calls) != 1 || calls[0].API != "admin" {
+		t.Errorf("calls = %+v, want one Admin mutation", calls)
+	}
+}
+
+func TestUpdateMetafieldRejectsIncompleteBody(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := s.do(t, "POST", "/api/shopify/product/metafield", `{"id": "gid://shopify/Product/101"}`, s.apiKey(t, models.RoleAdmin))
+	expectStatus(t, rec, http.StatusBadRequest)
+}
+
+func TestGetProductsV3(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["Products"] = productsFixture
+
+	rec := s.do(t, "GET", "/api/shopify/v3/products?first=10", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var list models.ProductList
+	decodeBody(t, rec, &list)
+	if len(list.Products) != 1 || list.Products[0].NumericID != "101" || !list.PageInfo.HasNextPage {
+		t.Errorf("list = %+v, want product 101 with a next page", list)
+	}
+
+	// The second read is served from the cache
+	rec = s.do(t, "GET", "/api/shopify/v3/products?first=10", "")
+	expectStatus(t, rec, http.StatusOK)
+	if calls := s.shopify.callsTo("Products"); len(calls) != 1 {
+		t.Errorf("Shopify called %d times, want 1", len(calls))
+	}
+}
+
+func TestGetProductsV3RejectsInvalidPageSize(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := s.do(t, "GET", "/api/shopify/v3/products?first=500", "")
+	expectStatus(t, rec, http.StatusBadRequest)
+	if code := errorCode(t, rec); code != "invalid_request" {
+		t.Errorf("code = %q, want invalid_request", code)
+	}
+}
+
+func TestGetProductByIdV3(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["ProductById"] = `{"data": {"product": ` + productFixture + `}}`
+
+	rec := s.do(t, "GET", "/api/shopify/v3/products/by-id/101", "", "Accept-Language: fr-FR")
+	expectStatus(t, rec, http.StatusOK)
+
+	var product models.Product
+	decodeBody(t, rec, &product)
+	if product.Handle != "floral-summer-dress" || len(product.Variants) != 1 {
+		t.Errorf("product = %+v", product)
+	}
+
+	calls := s.shopify.callsTo("ProductById")
+	if len(calls) != 1 || calls[0].Variables["language"] != "FR" {
+		t.Errorf("calls = %+v, want the FR locale forwarded", calls)
+	}
+}
+
+func TestGetProductByIdV3RejectsNonNumericID(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := s.do(t, "GET", "/api/shopify/v3/products/by-id/abc", "")
+	expectStatus(t, rec, http.StatusBadRequest)
+}
+
+func TestGetProductByNameV3(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["ProductByHandle"] = `{"data": {"product": ` + productFixture + `}}`
+
+	rec := s.do(t, "GET", "/api/shopify/v3/products/by-name/floral-summer-dress", "")
+	expectStatus(t, rec, http.StatusOK)
+}
+
+func TestGetProductByNameV3NotFound(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["ProductByHandle"] = `{"data": {"product": null}}`
+
+	rec := s.do(t, "GET", "/api/shopify/v3/products/by-name/missing", "")
+	expectStatus(t, rec, http.StatusNotFound)
+	if code := errorCode(t, rec); code != "not_found" {
+		t.Errorf("code = %q, want not_found", code)
+	}
+}
+
+func TestGetProductByNameV3UpstreamError(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := s.do(t, "GET", "/api/shopify/v3/products/by-name/floral-summer-dress", "", "X-Request-ID: req-upstream-1")
+	expectStatus(t, rec, http.StatusBadGateway)
+	if code := errorCode(t, rec); code != "upstream_error" {
+		t.Errorf("code = %q, want upstream_error", code)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "GraphQL") || strings.Contains(body, "fixture") || !strings.Contains(body, "req-upstream-1") {
+		t.Errorf("body = %s, want a fixed message with the request ID and no upstream detail", body)
+	}
+}
+
+// promotionsFixture has a summer sale on collection 5, an all-items code,
+// a code for product 102, a code that has already ended and a code limited
+// to a customer segment
+const promotionsFixture = `{"data": {
+	"automaticDiscountNodes": {"nodes": [
+		{"id": "gid://shopify/DiscountAutomaticNode/1", "automaticDiscount": {"__typename": "DiscountAutomaticBasic",
+			"title": "Summer sale", "summary": "20% off summer", "startsAt": "2024-01-01T00:00:00Z", "endsAt": null,
+			"customerGets": {"value": {"percentage": 0.2}, "items": {"collections": {"nodes": [{"id": "gid://shopify/Collection/5"}]}}}}}
+	]},
+	"codeDiscountNodes": {"nodes": [
+		{"id": "gid://shopify/DiscountCodeNode/2", "codeDiscount": {"__typename": "DiscountCodeBasic",
+			"title": "Welcome", "startsAt": "2024-01-01T00:00:00Z", "codes": {"nodes": [{"code": "WELCOME10"}]},
+			"customerSelection": {"__typename": "DiscountCustomerAll"},
+			"customerGets": {"value": {"amount": {"amount": "10.0", "currencyCode": "EUR"}}, "items": {"allItems": true}}}},
+		{"id": "gid://shopify/DiscountCodeNode/3", "codeDiscount": {"__typename": "DiscountCodeBasic",
+			"title": "Jacket VIP", "startsAt": "2024-01-01T00:00:00Z", "codes": {"nodes": [{"code": "VIP30"}]},
+			"customerSelection": {"__typename": "DiscountCustomerAll"},
+			"customerGets": {"value": {"percentage": 0.3}, "items": {"products": {"nodes": [{"id": "gid://shopify/Product/102"}]}}}}},
+		{"id": "gid://shopify/DiscountCodeNode/4", "codeDiscount": {"__typename": "DiscountCodeBasic",
+			"title": "Old", "startsAt": "2023-01-01T00:00:00Z", "endsAt": "2023-02-01T00:00:00Z", "codes": {"nodes": [{"code": "OLD50"}]},
+			"customerSelection": {"__typename": "DiscountCustomerAll"},
+			"customerGets": {"value": {"percentage": 0.5}, "items": {"allItems": true}}}},
+		{"id": "gid://shopify/DiscountCodeNode/5", "codeDiscount": {"__typename": "DiscountCodeBasic",
+			"title": "Staff", "startsAt": "2024-01-01T00:00:00Z", "codes": {"nodes": [{"code": "STAFF40"}]},
+			"customerSelection": {"__typename": "DiscountCustomerSegments"},
+			"customerGets": {"value": {"percentage": 0.4}, "items": {"allItems": true}}}}
+	]}
+}}`
+
+// allowPromotionCodes lets the chatbot mention every code in
+// promotionsFixture, including the segment-only one
+func allowPromotionCodes(t *testing.T, s *testServer, blocked string) {
+	t.Helper()
+
+	body := `{"allowed": ["WELCOME10", "VIP30", "STAFF40"], "blocked": [` + blocked + `]}`
+	rec := s.do(t, "PUT", "/api/admin/promotions/overrides", body, s.apiKey(t, models.RoleAdmin))
+	expectStatus(t, rec, http.StatusOK)
+}
+
+// promotionTitles returns the titles of a promotions response
+func promotionTitles(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+
+	var body struct {
+		Promotions []controllers.Promotion `json:"promotions"`
+	}
+	decodeBody(t, rec, &body)
+	titles := []string{}
+	for _, promotion := range body.Promotions {
+		titles = append(titles, promotion.Title)
+	}
+	return titles
+}
+
+func TestGetPromotions(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.admin["ActivePromotions"] = promotionsFixture
+
+	rec := s.do(t, "GET", "/api/shopify/v2/promotions", "")
+	expectStatus(t, rec, http.StatusOK)
+	if titles := promotionTitles(t, rec); fmt.Sprint(titles) != "[Summer sale]" {
+		t.Errorf("titles = %v, want codes hidden until allowed", titles)
+	}
+
+	allowPromotionCodes(t, s, "")
+	rec = s.do(t, "GET", "/api/shopify/v2/promotions", "")
+	expectStatus(t, rec, http.StatusOK)
+	if titles := promotionTitles(t, rec); fmt.Sprint(titles) != "[Summer sale Welcome Jacket VIP]" {
+		t.Errorf("titles = %v, want the live promotions for all customers", titles)
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v2/promotions", "")
+	expectStatus(t, rec, http.StatusOK)
+	if calls := s.shopify.callsTo("ActivePromotions"); len(calls) != 1 || calls[0].API != "admin" {
+		t.Errorf("calls = %+v, want one Admin lookup then the cache", calls)
+	}
+}
+
+func TestGetPromotionsForProductAndCollection(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.admin["ActivePromotions"] = promotionsFixture
+	s.shopify.storefront["PromotionProduct"] = `{"data": {"product": {"id": "gid://shopify/Product/101", "collections": {"nodes": [{"id": "gid://shopify/Collection/5"}]}}}}`
+	s.shopify.storefront["PromotionCollection"] = `{"data": {"collection": {"id": "gid://shopify/Collection/6"}}}`
+	allowPromotionCodes(t, s, "")
+
+	rec := s.do(t, "GET", "/api/shopify/v2/promotions?product=floral-summer-dress", "")
+	expectStatus(t, rec, http.StatusOK)
+	if titles := promotionTitles(t, rec); fmt.Sprint(titles) != "[Summer sale Welcome]" {
+		t.Errorf("product titles = %v", titles)
+	}
+	if calls := s.shopify.callsTo("PromotionProduct"); len(calls) != 1 || calls[0].Variables["handle"] != "floral-summer-dress" {
+		t.Errorf("calls = %+v, want the product looked up by handle", calls)
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v2/promotions?collection=winter", "")
+	expectStatus(t, rec, http.StatusOK)
+	if titles := promotionTitles(t, rec); fmt.Sprint(titles) != "[Welcome]" {
+		t.Errorf("collection titles = %v", titles)
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v2/promotions?product=a&collection=b", "")
+	expectStatus(t, rec, http.StatusBadRequest)
+
+	s.shopify.storefront["PromotionProduct"] = `{"data": {"product": null}}`
+	rec = s.do(t, "GET", "/api/shopify/v2/promotions?product=missing", "")
+	expectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestGetPromotionsAppliesOverrides(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.admin["ActivePromotions"] = promotionsFixture
+
+	allowPromotionCodes(t, s, `"vip30"`)
+
+	rec := s.do(t, "GET", "/api/shopify/v2/promotions", "")
+	expectStatus(t, rec, http.StatusOK)
+	if titles := promotionTitles(t, rec); fmt.Sprint(titles) != "[Summer sale Welcome]" {
+		t.Errorf("titles = %v, want the blocked code hidden", titles)
+	}
+
+	rec = s.do(t, "PUT", "/api/admin/promotions/overrides", `{"allowed": ["1"]}`, s.apiKey(t, models.RoleAdmin))
+	expectStatus(t, rec, http.StatusOK)
+
+	rec = s.do(t, "GET", "/api/shopify/v2/promotions", "")
+	expectStatus(t, rec, http.StatusOK)
+	if titles := promotionTitles(t, rec); fmt.Sprint(titles) != "[Summer sale]" {
+		t.Errorf("titles = %v, want only the allowed discount", titles)
+	}
+}
+
+const collectionsFixture = `{"data": {"collections": {
+	"edges": [
+		{"node": {"id": "gid://shopify/Collection/5", "handle": "new-arrivals", "title": "New Arrivals", "description": "Just in", "updatedAt": "2024-05-01T00:00:00Z", "image": {"url": "https://cdn.example.com/new.jpg", "altText": "New"}}},
+		{"node": {"id": "gid://shopify/Collection/6", "handle": "summer", "title": "Summer", "description": "", "updatedAt": "2024-05-01T00:00:00Z", "image": null}}
+	],
+	"pageInfo": {"hasNextPage": true, "endCursor": "Y3Vyc29yMg=="}
+}}}`
+
+const collectionFixture = `{"data": {"collection": {
+	"id": "gid://shopify/Collection/5", "handle": "new-arrivals", "title": "New Arrivals", "description": "Just in", "updatedAt": "2024-05-01T00:00:00Z", "image": null,
+	"products": {
+		"edges": [{"node": ` + productFixture + `}],
+		"pageInfo": {"hasNextPage": false, "endCursor": "Y3Vyc29yMQ=="},
+		"filters": [{"id": "filter.v.availability", "label": "Availability", "type": "LIST", "values": [
+			{"id": "filter.v.availability.1", "label": "In stock", "count": 1, "input": "{\"available\":true}"}]}]
+	}
+}}}`
+
+func TestGetCollections(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["Collections"] = collectionsFixture
+
+	rec := s.do(t, "GET", "/api/shopify/v2/collections?first=2", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var list models.CollectionList
+	decodeBody(t, rec, &list)
+	if len(list.Collections) != 2 || list.Collections[0].Handle != "new-arrivals" || list.Collections[0].NumericID != "5" || !list.PageInfo.HasNextPage {
+		t.Errorf("list = %+v", list)
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v2/collections?first=2", "")
+	expectStatus(t, rec, http.StatusOK)
+	if calls := s.shopify.callsTo("Collections"); len(calls) != 1 || calls[0].Variables["first"] != 2 {
+		t.Errorf("calls = %+v, want one lookup then the cache", calls)
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v2/collections?first=500", "")
+	expectStatus(t, rec, http.StatusBadRequest)
+}
+
+func TestGetCollectionByHandle(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["CollectionByHandle"] = collectionFixture
+
+	rec := s.do(t, "GET", "/api/shopify/v2/collections/new-arrivals?first=10&sort=price&reverse=true&available=true&minPrice=20&tag=summer&option=Size:M", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var page struct {
+		Collection models.Collection `json:"collection"`
+		Products   struct {
+			Edges []struct {
+				Node struct {
+					Handle string `json:"handle"`
+				} `json:"node"`
+			} `json:"edges"`
+		} `json:"products"`
+		Filters []models.CollectionFilter `json:"filters"`
+	}
+	decodeBody(t, rec, &page)
+	if page.Collection.Title != "New Arrivals" || len(page.Products.Edges) != 1 || page.Products.Edges[0].Node.Handle != "floral-summer-dress" {
+		t.Errorf("page = %+v", page)
+	}
+	if len(page.Filters) != 1 || len(page.Filters[0].Values) != 1 || page.Filters[0].Values[0].Count != 1 {
+		t.Errorf("filters = %+v", page.Filters)
+	}
+
+	calls := s.shopify.callsTo("CollectionByHandle")
+	if len(calls) != 1 {
+		t.Fatalf("calls = %d, want 1", len(calls))
+	}
+	vars := calls[0].Variables
+	filters, _ := vars["filters"].([]map[string]interface{})
+	if vars["handle"] != "new-arrivals" || vars["first"] != 10 || vars["sortKey"] != "PRICE" || vars["reverse"] != true || len(filters) != 4 {
+		t.Errorf("variables = %+v", vars)
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v2/collections/new-arrivals?first=10&sort=price&reverse=true&available=true&minPrice=20&tag=summer&option=Size:M", "")
+	expectStatus(t, rec, http.StatusOK)
+	if calls := s.shopify.callsTo("CollectionByHandle"); len(calls) != 1 {
+		t.Errorf("calls = %d, want the second page served from cache", len(calls))
+	}
+}
+
+func TestGetCollectionByHandleRejectsBadQuery(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["CollectionByHandle"] = `{"data": {"collection": null}}`
+
+	for _, query := range []string{"sort=cheapest", "reverse=maybe", "minPrice=-1", "option=Size", "filter=nope"} {
+		rec := s.do(t, "GET", "/api/shopify/v2/collections/new-arrivals?"+query, "")
+		expectStatus(t, rec, http.StatusBadRequest)
+	}
+
+	rec := s.do(t, "GET", "/api/shopify/v2/collections/missing", "")
+	expectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestGetCollectionRules(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["PublishedCollection"] = `{"data": {"collection": {"id": "gid://shopify/Collection/5"}}}`
+	s.shopify.admin["CollectionRules"] = `{"data": {"collection": {"handle": "summer", "ruleSet": {"appliedDisjunctively": false, "rules": [{"column": "TAG", "relation": "EQUALS", "condition": "summer"}]}}}}`
+
+	rec := s.do(t, "GET", "/api/shopify/v2/collections/summer/rules", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var rules models.CollectionRules
+	decodeBody(t, rec, &rules)
+	if !rules.Smart || len(rules.Rules) != 1 || rules.Rules[0].Condition != "summer" {
+		t.Errorf("rules = %+v", rules)
+	}
+	if calls := s.shopify.callsTo("CollectionRules"); len(calls) != 1 || calls[0].API != "admin" || calls[0].Variables["id"] != "gid://shopify/Collection/5" {
+		t.Errorf("calls = %+v, want one Admin lookup of the published collection", calls)
+	}
+
+	s.shopify.storefront["PublishedCollection"] = `{"data": {"collection": {"id": "gid://shopify/Collection/6"}}}`
+	s.shopify.admin["CollectionRules"] = `{"data": {"collection": {"handle": "new-arrivals", "ruleSet": null}}}`
+	rec = s.do(t, "GET", "/api/shopify/v2/collections/new-arrivals/rules", "")
+	expectStatus(t, rec, http.StatusOK)
+	decodeBody(t, rec, &rules)
+	if rules.Smart || len(rules.Rules) != 0 {
+		t.Errorf("manual collection rules = %+v", rules)
+	}
+}
+
+func TestGetCollectionRulesHidesUnpublishedCollections(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["PublishedCollection"] = `{"data": {"collection": null}}`
+	s.shopify.admin["CollectionRules"] = `{"data": {"collection": {"handle": "staff-picks", "ruleSet": {"appliedDisjunctively": false, "rules": [{"column": "TAG", "relation": "EQUALS", "condition": "internal"}]}}}}`
+
+	rec := s.do(t, "GET", "/api/shopify/v2/collections/staff-picks/rules", "")
+	expectStatus(t, rec, http.StatusNotFound)
+	if calls := s.shopify.callsTo("CollectionRules"); len(calls) != 0 {
+		t.Errorf("calls = %+v, want no Admin lookup of an unpublished collection", calls)
+	}
+}
+
+// inventoryFixture has a jacket in M with 2 left in Berlin and 10 in Paris,
+// and in L sold out everywhere
+const inventoryFixture = `{"data": {"product": {
+	"id": "gid://shopify/Product/42", "handle": "rain-jacket", "title": "Rain Jacket",
+	"variants": {"nodes": [
+		{"id": "gid://shopify/ProductVariant/1", "title": "M", "sku": "RJ-M", "selectedOptions": [{"name": "Size", "value": "M"}],
+			"inventoryItem": {"id": "gid://shopify/InventoryItem/101", "tracked": true, "inventoryLevels": {"nodes": [
+				{"location": {"id": "gid://shopify/Location/7", "name": "Berlin Mitte", "address": {"city": "Berlin", "provinceCode": "BE", "countryCode": "DE"}}, "quantities": [{"name": "available", "quantity": 2}]},
+				{"location": {"id": "gid://shopify/Location/8", "name": "Paris Marais", "address": {"city": "Paris", "provinceCode": "", "countryCode": "FR"}}, "quantities": [{"name": "available", "quantity": 10}]}]}}},
+		{"id": "gid://shopify/ProductVariant/2", "title": "L", "sku": "RJ-L", "selectedOptions": [{"name": "Size", "value": "L"}],
+			"inventoryItem": {"id": "gid://shopify/InventoryItem/102", "tracked": true, "inventoryLevels": {"nodes": [
+				{"location": {"id": "gid://shopify/Location/7", "name": "Berlin Mitte", "address": {"city": "Berlin", "provinceCode": "BE", "countryCode": "DE"}}, "quantities": [{"name": "available", "quantity": 0}]}]}}}
+	]}
+}}}`
+
+// publishedJacket is the Storefront lookup of the published rain jacket
+const publishedJacket = `{"data": {"product": {"id": "gid://shopify/Product/42"}}}`
+
+func TestGetInventoryById(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["InventoryProduct"] = publishedJacket
+	s.shopify.admin["ProductInventory"] = inventoryFixture
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-id/42/inventory", "", s.apiKey(t, models.RoleAdmin))
+	expectStatus(t, rec, http.StatusOK)
+
+	var inventory models.ProductInventory
+	decodeBody(t, rec, &inventory)
+	if len(inventory.Variants) != 2 {
+		t.Fatalf("variants = %+v", inventory.Variants)
+	}
+	medium, large := inventory.Variants[0], inventory.Variants[1]
+	if medium.Available != 12 || medium.SKU != "RJ-M" || medium.Status != models.StockInStock || medium.Signal != "" {
+		t.Errorf("M = %+v, want 12 in stock", medium)
+	}
+	if medium.Locations[0].Status != models.StockLow || medium.Locations[1].Status != models.StockInStock {
+		t.Errorf("M locations = %+v, want Berlin low and Paris in stock", medium.Locations)
+	}
+	if large.Status != models.StockOut {
+		t.Errorf("L = %+v, want out of stock", large)
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v2/products/by-id/42/inventory", "", s.apiKey(t, models.RoleAdmin))
+	expectStatus(t, rec, http.StatusOK)
+	if calls := s.shopify.callsTo("ProductInventory"); len(calls) != 1 || calls[0].API != "admin" || calls[0].Variables["id"] != "gid://shopify/Product/42" {
+		t.Errorf("calls = %+v, want one Admin lookup then the cache", calls)
+	}
+}
+
+func TestGetInventoryByIdHidesQuantitiesFromShoppers(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["InventoryProduct"] = publishedJacket
+	s.shopify.admin["ProductInventory"] = inventoryFixture
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-id/42/inventory", "")
+	expectStatus(t, rec, http.StatusOK)
+	for _, field := range []string{`"available"`, `"sku"`, `"inventoryItemId"`, `"locationId"`} {
+		if strings.Contains(rec.Body.String(), field) {
+			t.Errorf("body has %s: %s", field, rec.Body.String())
+		}
+	}
+
+	var inventory models.ProductInventory
+	decodeBody(t, rec, &inventory)
+	if medium := inventory.Variants[0]; medium.Status != models.StockInStock || len(medium.Locations) != 2 || medium.Locations[0].Status != models.StockLow || medium.Locations[0].Name != "Berlin Mitte" {
+		t.Errorf("M = %+v, want statuses per location", medium)
+	}
+	if calls := s.shopify.callsTo("InventoryProduct"); len(calls) != 1 || calls[0].Variables["id"] != "gid://shopify/Product/42" {
+		t.Errorf("calls = %+v, want the product checked on the storefront", calls)
+	}
+}
+
+func TestGetInventoryByIdSignalsLowStock(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["InventoryProduct"] = publishedJacket
+	s.shopify.admin["ProductInventory"] = strings.Replace(inventoryFixture, `"quantity": 10`, `"quantity": 0`, 1)
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-id/42/inventory", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var inventory models.ProductInventory
+	decodeBody(t, rec, &inventory)
+	if medium := inventory.Variants[0]; medium.Status != models.StockLow || medium.Signal != "Only 2 left" {
+		t.Errorf("M = %+v, want the low-stock signal", medium)
+	}
+}
+
+func TestGetInventoryByIdRejectsBadRequests(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.admin["ProductInventory"] = `{"data": {"product": null}}`
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-id/jacket/inventory", "")
+	expectStatus(t, rec, http.StatusBadRequest)
+
+	rec = s.do(t, "GET", "/api/shopify/v2/products/by-id/43/inventory", "", s.apiKey(t, models.RoleAdmin))
+	expectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestGetInventoryByIdHidesUnpublishedProducts(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["InventoryProduct"] = `{"data": {"product": null}}`
+	s.shopify.admin["ProductInventory"] = inventoryFixture
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-id/42/inventory", "")
+	expectStatus(t, rec, http.StatusNotFound)
+	if calls := s.shopify.callsTo("ProductInventory"); len(calls) != 0 {
+		t.Errorf("calls = %+v, want no Admin lookup for an unpublished product", calls)
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v2/products/by-id/42/inventory", "", s.apiKey(t, models.RoleAdmin))
+	expectStatus(t, rec, http.StatusOK)
+}
+
+func TestGetInventoryByName(t *testing.T) {
+	s := newTestServer(t)
+	s.shopify.storefront["InventoryProduct"] = publishedJacket
+	s.shopify.admin["ProductInventory"] = inventoryFixture
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-name/rain-jacket/inventory", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var inventory models.ProductInventory
+	decodeBody(t, rec, &inventory)
+	if inventory.Handle != "rain-jacket" || len(inventory.Variants) != 2 {
+		t.Errorf("inventory = %+v", inventory)
+	}
+
+	s.shopify.storefront["InventoryProduct"] = `{"data": {"product": null}}`
+	rec = s.do(t, "GET", "/api/shopify/v2/products/by-name/missing/inventory", "")
+	expectStatus(t, rec, http.StatusNotFound)
+}
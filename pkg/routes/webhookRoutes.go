@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/gorilla/mux"
+	"strategy-fox-go-bd/pkg/controllers"
+	"strategy-fox-go-bd/pkg/middleware"
+)
+
+var WebhookRoutes = func(router *mux.Router, deps Dependencies) {
+	webhooks := controllers.NewWebhookController(deps.Config, deps.Shopify, deps.Cache, deps.Store, deps.Notifier)
+
+	router.Use(middleware.LimitBody(256 << 10))
+	router.Use(middleware.VerifyShopifyWebhook(deps.Config.Shopify.WebhookSecret.Value()))
+
+	router.HandleFunc("/shopify", webhooks.HandleShopifyWebhook).Methods("POST")
+}
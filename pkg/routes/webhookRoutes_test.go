@@ -0,0 +1,191 @@
+package routes
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"strategy-fox-go-bd/pkg/controllers"
+	"strategy-fox-go-bd/pkg/models"
+	"strategy-fox-go-bd/pkg/notify"
+)
+
+const testWebhookSecret = "test-webhook-secret"
+
+// newWebhookTestServer returns a test server that accepts webhooks signed
+// with testWebhookSecret
+func newWebhookTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	cfg := testConfig()
+	cfg.Shopify.WebhookSecret = testWebhookSecret
+	shopify := newFakeShopify()
+	s := buildTestServer(t, cfg, shopify)
+	s.shopify = shopify
+	return s
+}
+
+// webhookSignature returns the X-Shopify-Hmac-Sha256 header of body
+func webhookSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "X-Shopify-Hmac-Sha256: " + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookRejectsBadSignature(t *testing.T) {
+	s := newWebhookTestServer(t)
+	body := `{"inventory_item_id": 101, "location_id": 7, "available": 5}`
+
+	rec := s.do(t, "POST", "/api/webhooks/shopify", body, "X-Shopify-Topic: inventory_levels/update", webhookSignature("wrong-secret", body))
+	expectStatus(t, rec, http.StatusUnauthorized)
+
+	rec = s.do(t, "POST", "/api/webhooks/shopify", body, "X-Shopify-Topic: inventory_levels/update")
+	expectStatus(t, rec, http.StatusUnauthorized)
+}
+
+func TestWebhookDisabledWithoutSecret(t *testing.T) {
+	s := newTestServer(t)
+	body := `{"inventory_item_id": 101, "location_id": 7, "available": 5}`
+
+	rec := s.do(t, "POST", "/api/webhooks/shopify", body, "X-Shopify-Topic: inventory_levels/update", webhookSignature("", body))
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	if code := errorCode(t, rec); code != "webhooks_disabled" {
+		t.Errorf("code = %q, want webhooks_disabled", code)
+	}
+}
+
+func TestWebhookInventoryUpdateInvalidatesCache(t *testing.T) {
+	s := newWebhookTestServer(t)
+	s.shopify.admin["ProductInventory"] = inventoryFixture
+	admin := s.apiKey(t, models.RoleAdmin)
+
+	rec := s.do(t, "GET", "/api/shopify/v2/products/by-id/42/inventory", "", admin)
+	expectStatus(t, rec, http.StatusOK)
+
+	// Shopify has the new level by the time the webhook arrives
+	s.shopify.admin["ProductInventory"] = strings.Replace(inventoryFixture, `"quantity": 10`, `"quantity": 0`, 1)
+	body := `{"inventory_item_id": 101, "location_id": 8, "available": 0, "updated_at": "2024-05-01T12:00:00Z"}`
+	rec = s.do(t, "POST", "/api/webhooks/shopify", body, "X-Shopify-Topic: inventory_levels/update", webhookSignature(testWebhookSecret, body))
+	expectStatus(t, rec, http.StatusNoContent)
+	if calls := s.shopify.callsTo("ProductInventory"); len(calls) != 1 {
+		t.Errorf("calls = %d, want the webhook not to fetch stock itself", len(calls))
+	}
+
+	rec = s.do(t, "GET", "/api/shopify/v2/products/by-id/42/inventory", "", admin)
+	expectStatus(t, rec, http.StatusOK)
+
+	var inventory models.ProductInventory
+	decodeBody(t, rec, &inventory)
+	if medium := inventory.Variants[0]; medium.Available != 2 || medium.Signal != "Only 2 left" || medium.Locations[1].Status != models.StockOut {
+		t.Errorf("M = %+v, want Paris sold out and the low-stock signal", medium)
+	}
+	if calls := s.shopify.callsTo("ProductInventory"); len(calls) != 2 {
+		t.Errorf("calls = %d, want the invalidated entry fetched again", len(calls))
+	}
+}
+
+func TestWebhookIgnoresOtherTopics(t *testing.T) {
+	s := newWebhookTestServer(t)
+	body := `{"id": 1}`
+
+	rec := s.do(t, "POST", "/api/webhooks/shopify", body, "X-Shopify-Topic: products/update", webhookSignature(testWebhookSecret, body))
+	expectStatus(t, rec, http.StatusNoContent)
+
+	body = `{"location_id": 7}`
+	rec = s.do(t, "POST", "/api/webhooks/shopify", body, "X-Shopify-Topic: inventory_levels/update", webhookSignature(testWebhookSecret, body))
+	expectStatus(t, rec, http.StatusBadRequest)
+}
+
+func TestWebhookRestockNotifiesSubscribersOnce(t *testing.T) {
+	s := newWebhookTestServer(t)
+	useBackInStockVariant(s)
+	s.shopify.admin["BackInStockProductURL"] = `{"data": {"product": {"onlineStoreUrl": "https://shop.example.com/products/rain-jacket"}}}`
+	email := subscribeBackInStock(t, s, `{"variantId": "2", "email": "jane@example.com"}`)
+	subscribeBackInStock(t, s, `{"variantId": "2", "webhookUrl": "https://hooks.example.com/restock"}`)
+
+	send := func(body string) {
+		t.Helper()
+		rec := s.do(t, "POST", "/api/webhooks/shopify", body, "X-Shopify-Topic: inventory_levels/update", webhookSignature(testWebhookSecret, body))
+		expectStatus(t, rec, http.StatusNoContent)
+	}
+
+	send(`{"inventory_item_id": 102, "location_id": 7, "available": 0}`)
+	if sent := s.notifier.Sent(); len(sent) != 0 {
+		t.Fatalf("sent %d notifications while sold out", len(sent))
+	}
+
+	send(`{"inventory_item_id": 102, "location_id": 7, "available": 3}`)
+	sent := waitForNotifications(t, s, 2)
+	if len(sent) != 2 {
+		t.Fatalf("sent %d notifications, want one per subscriber", len(sent))
+	}
+	for _, n := range sent {
+		switch n.Channel {
+		case notify.ChannelEmail:
+			if n.Target != "jane@example.com" || n.Subject != "Rain Jacket (L) is back in stock" || !strings.Contains(n.Body, email["unsubscribeUrl"]) || !strings.Contains(n.Body, "https://shop.example.com/products/rain-jacket") {
+				t.Errorf("email = %+v", n)
+			}
+		case notify.ChannelWebhook:
+			if n.Target != "https://hooks.example.com/restock" || n.Data["event"] != "back_in_stock" || n.Data["variantId"] != "gid://shopify/ProductVariant/2" {
+				t.Errorf("webhook = %+v", n)
+			}
+		}
+	}
+
+	send(`{"inventory_item_id": 102, "location_id": 8, "available": 5}`)
+	if sent := waitForNotifications(t, s, 3); len(sent) != 2 {
+		t.Errorf("sent %d notifications after a second restock, want no repeats", len(sent))
+	}
+
+	// A notified subscription no longer blocks registering again
+	subscribeBackInStock(t, s, `{"variantId": "2", "email": "jane@example.com"}`)
+}
+
+// waitForNotifications waits briefly for the background restock delivery
+// to send n notifications and returns what was sent
+func waitForNotifications(t *testing.T, s *testServer, n int) []notify.Notification {
+	t.Helper()
+
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for {
+		sent := s.notifier.Sent()
+		if len(sent) >= n || time.Now().After(deadline) {
+			return sent
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBackInStockPollerNotifiesRestocks(t *testing.T) {
+	s := newTestServer(t)
+	useBackInStockVariant(s)
+	s.shopify.admin["BackInStockProductURL"] = `{"data": {"product": {"onlineStoreUrl": null}}}`
+	s.shopify.admin["BackInStockLevels"] = `{"data": {"inventoryItem": {"inventoryLevels": {"nodes": [{"quantities": [{"name": "available", "quantity": 0}]}]}}}}`
+	subscribeBackInStock(t, s, `{"variantId": "2", "email": "jane@example.com"}`)
+
+	poller := controllers.NewBackInStockController(s.cfg, s.shopify, s.store, s.notifier)
+	if err := poller.PollRestocks(context.Background()); err != nil {
+		t.Fatalf("PollRestocks: %v", err)
+	}
+	if sent := s.notifier.Sent(); len(sent) != 0 {
+		t.Fatalf("sent %d notifications while sold out", len(sent))
+	}
+
+	s.shopify.admin["BackInStockLevels"] = `{"data": {"inventoryItem": {"inventoryLevels": {"nodes": [{"quantities": [{"name": "available", "quantity": 2}]}]}}}}`
+	for i := 0; i < 2; i++ {
+		if err := poller.PollRestocks(context.Background()); err != nil {
+			t.Fatalf("PollRestocks: %v", err)
+		}
+	}
+	if sent := s.notifier.Sent(); len(sent) != 1 || strings.Contains(sent[0].Body, "Shop it here") {
+		t.Errorf("sent = %+v, want one email without a store link", sent)
+	}
+	if calls := s.shopify.callsTo("BackInStockLevels"); len(calls) != 3 || calls[0].Variables["id"] != "gid://shopify/InventoryItem/102" {
+		t.Errorf("calls = %+v, want the item checked until it has no subscribers", calls)
+	}
+}
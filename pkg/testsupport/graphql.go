@@ -0,0 +1,248 @@
+package testsupport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// graphQLField is a root field selected by an operation, with its arguments
+// resolved against the request variables
+type graphQLField struct {
+	Key  string
+	Name string
+	Args map[string]interface{}
+}
+
+// graphQLParser reads just enough of a GraphQL document to find the root
+// fields of its operation and their arguments. Nested selections are
+// skipped: the fake returns every fixture field and clients ignore the rest.
+type graphQLParser struct {
+	src       string
+	pos       int
+	variables map[string]interface{}
+}
+
+// parseRootFields returns the root fields of the first operation in query
+func parseRootFields(query string, variables map[string]interface{}) ([]graphQLField, error) {
+	start := strings.IndexByte(query, '{')
+	if start < 0 {
+		return nil, fmt.Errorf("no selection set")
+	}
+
+	p := &graphQLParser{src: query, pos: start + 1, variables: variables}
+	var fields []graphQLField
+	for {
+		p.skipIgnored()
+		if p.pos >= len(p.src) {
+			return nil, fmt.Errorf("unterminated selection set")
+		}
+		if p.src[p.pos] == '}' {
+			return fields, nil
+		}
+
+		name := p.readName()
+		if name == "" {
+			return nil, fmt.Errorf("unexpected %q at offset %d", p.src[p.pos], p.pos)
+		}
+		field := graphQLField{Key: name, Name: name, Args: map[string]interface{}{}}
+
+		p.skipIgnored()
+		if p.peek() == ':' {
+			p.pos++
+			p.skipIgnored()
+			field.Name = p.readName()
+			p.skipIgnored()
+		}
+
+		if p.peek() == '(' {
+			p.pos++
+			args, err := p.readArguments()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", field.Name, err)
+			}
+			field.Args = args
+			p.skipIgnored()
+		}
+
+		if p.peek() == '{' {
+			if err := p.skipBlock(); err != nil {
+				return nil, err
+			}
+		}
+		fields = append(fields, field)
+	}
+}
+
+func (p *graphQLParser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// skipIgnored skips whitespace, commas and comments
+func (p *graphQLParser) skipIgnored() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ',' || unicode.IsSpace(rune(c)):
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *graphQLParser) readName() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c != '_' && !unicode.IsLetter(rune(c)) && !(p.pos > start && unicode.IsDigit(rune(c))) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// skipBlock skips a balanced { ... } selection set
+func (p *graphQLParser) skipBlock() error {
+	depth := 0
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '"':
+			if _, err := p.readString(); err != nil {
+				return err
+			}
+			continue
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return nil
+			}
+		}
+		p.pos++
+	}
+	return fmt.Errorf("unterminated selection set")
+}
+
+// readArguments reads "name: value" pairs up to the closing parenthesis
+func (p *graphQLParser) readArguments() (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for {
+		p.skipIgnored()
+		if p.peek() == ')' {
+			p.pos++
+			return args, nil
+		}
+
+		name := p.readName()
+		p.skipIgnored()
+		if name == "" || p.peek() != ':' {
+			return nil, fmt.Errorf("malformed argument at offset %d", p.pos)
+		}
+		p.pos++
+		p.skipIgnored()
+
+		value, err := p.readValue()
+		if err != nil {
+			return nil, err
+		}
+		args[name] = value
+	}
+}
+
+// readValue reads a literal or variable, returning it in the shape
+// encoding/json would produce for the same JSON value
+func (p *graphQLParser) readValue() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '$':
+		p.pos++
+		return p.variables[p.readName()], nil
+	case c == '"':
+		return p.readString()
+	case c == '[':
+		p.pos++
+		var list []interface{}
+		for {
+			p.skipIgnored()
+			if p.peek() == ']' {
+				p.pos++
+				return list, nil
+			}
+			value, err := p.readValue()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+	case c == '{':
+		p.pos++
+		object := map[string]interface{}{}
+		for {
+			p.skipIgnored()
+			if p.peek() == '}' {
+				p.pos++
+				return object, nil
+			}
+			name := p.readName()
+			p.skipIgnored()
+			if name == "" || p.peek() != ':' {
+				return nil, fmt.Errorf("malformed object field at offset %d", p.pos)
+			}
+			p.pos++
+			p.skipIgnored()
+			value, err := p.readValue()
+			if err != nil {
+				return nil, err
+			}
+			object[name] = value
+		}
+	case c == '-' || unicode.IsDigit(rune(c)):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		return strconv.ParseFloat(p.src[start:p.pos], 64)
+	default:
+		// true, false, null or an enum value
+		switch name := p.readName(); name {
+		case "":
+			return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		default:
+			return name, nil
+		}
+	}
+}
+
+func (p *graphQLParser) readString() (string, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos += 2
+			continue
+		case '"':
+			p.pos++
+			return strconv.Unquote(p.src[start:p.pos])
+		}
+		p.pos++
+	}
+	return "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,25 @@
+// Package testsupport holds fakes for the services the server depends on, so
+// tests run without a live Redis or Shopify store.
+package testsupport
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// NewFakeRedis returns a client of an in-memory Redis that is closed when
+// the test ends. The returned server can be inspected or fast-forwarded to
+// expire keys.
+func NewFakeRedis(t testing.TB) (redis.UniversalClient, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	return client, server
+}
@@ -0,0 +1,537 @@
+package testsupport
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"strategy-fox-go-bd/pkg/config"
+)
+
+const (
+	// FakeShopName is the shop name returned by the shop query
+	FakeShopName = "Fake Fashion Store"
+
+	// FakeStorefrontToken and FakeAdminToken are the access tokens the fake
+	// accepts; Config sets them
+	FakeStorefrontToken = "fake-storefront-token"
+	FakeAdminToken      = "fake-admin-token"
+
+	fakeAPIVersion = "2024-10"
+	fakeCurrency   = "EUR"
+
+	// fakeCostBudget mirrors the Admin API leaky bucket size
+	fakeCostBudget = 2000
+)
+
+// FakeShopifyRequest is one GraphQL request received by FakeShopify
+type FakeShopifyRequest struct {
+	API       string
+	Operation string
+	Query     string
+	Variables map[string]interface{}
+}
+
+// FakeShopify is an in-memory shop served over TLS by httptest. It answers
+// the Storefront and Admin GraphQL endpoints from fixtures:
+//   - shop, product(id:|handle:), productByHandle(handle:) and
+//     products(first:, after:, query:) read the catalog
+//   - productUpdate(input:) and metafieldsSet(metafields:) write metafields
+//     on the Admin endpoint, reporting invalid input as userErrors
+//
+// Throttle, FailNext and RejectNextMutation script Shopify failures. Point a
+// client at it with Config and Client.
+type FakeShopify struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	products     []*FakeProduct
+	throttled    int
+	failures     []int
+	rejections   [][]FakeUserError
+	requests     []FakeShopifyRequest
+	nextObjectID int
+}
+
+// NewFakeShopify starts a fake shop holding DefaultProducts and stops it
+// when the test ends
+func NewFakeShopify(t testing.TB) *FakeShopify {
+	t.Helper()
+
+	f := &FakeShopify{nextObjectID: 9000}
+	f.SetProducts(DefaultProducts()...)
+	f.server = httptest.NewTLSServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// Config returns Shopify settings pointing at the fake with valid tokens
+func (f *FakeShopify) Config() config.ShopifyConfig {
+	return config.ShopifyConfig{
+		StoreName:             strings.TrimPrefix(f.server.URL, "https://"),
+		APIVersion:            fakeAPIVersion,
+		StorefrontAccessToken: FakeStorefrontToken,
+		AdminAccessToken:      FakeAdminToken,
+	}
+}
+
+// Client returns an HTTP client that trusts the fake's certificate
+func (f *FakeShopify) Client() *http.Client {
+	return f.server.Client()
+}
+
+// SetProducts replaces the catalog
+func (f *FakeShopify) SetProducts(products ...FakeProduct) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.products = nil
+	for i := range products {
+		product := products[i]
+		f.products = append(f.products, &product)
+	}
+}
+
+// Product returns a copy of the product with handle, including any
+// metafields written through the Admin API
+func (f *FakeShopify) Product(handle string) (FakeProduct, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, p := range f.products {
+		if p.Handle == handle {
+			product := *p
+			product.Metafields = append([]FakeMetafield(nil), p.Metafields...)
+			return product, true
+		}
+	}
+	return FakeProduct{}, false
+}
+
+// Throttle makes the next n requests fail with a THROTTLED error, as
+// Shopify does when the query cost bucket is empty
+func (f *FakeShopify) Throttle(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.throttled += n
+}
+
+// FailNext makes the next requests fail with the given HTTP statuses, one
+// request per status
+func (f *FakeShopify) FailNext(statuses ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, statuses...)
+}
+
+// RejectNextMutation makes the next productUpdate or metafieldsSet return
+// userErrors without changing anything
+func (f *FakeShopify) RejectNextMutation(userErrors ...FakeUserError) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rejections = append(f.rejections, userErrors)
+}
+
+// Requests returns every request received so far
+func (f *FakeShopify) Requests() []FakeShopifyRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeShopifyRequest(nil), f.requests...)
+}
+
+// RequestsFor returns the requests of a named operation
+func (f *FakeShopify) RequestsFor(operation string) []FakeShopifyRequest {
+	var matching []FakeShopifyRequest
+	for _, req := range f.Requests() {
+		if req.Operation == operation {
+			matching = append(matching, req)
+		}
+	}
+	return matching
+}
+
+var (
+	storefrontPath = regexp.MustCompile(`^/api/[^/]+/graphql\.json$`)
+	adminPath      = regexp.MustCompile(`^/admin/api/[^/]+/graphql\.json$`)
+	operationName  = regexp.MustCompile(`^\s*(query|mutation)\b\s*([A-Za-z_][A-Za-z0-9_]*)?`)
+)
+
+func (f *FakeShopify) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var api, tokenHeader, token string
+	switch {
+	case storefrontPath.MatchString(r.URL.Path):
+		api, tokenHeader, token = "storefront", "X-Shopify-Storefront-Access-Token", FakeStorefrontToken
+	case adminPath.MatchString(r.URL.Path):
+		api, tokenHeader, token = "admin", "X-Shopify-Access-Token", FakeAdminToken
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeFakeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"errors": "Method not allowed"})
+		return
+	}
+	if r.Header.Get(tokenHeader) != token {
+		writeFakeJSON(w, http.StatusUnauthorized, map[string]interface{}{"errors": "[API] Invalid API key or access token (unrecognized login or wrong password)"})
+		return
+	}
+
+	var body struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": "Bad Request"})
+		return
+	}
+
+	operationType, operation := "query", "anonymous"
+	if match := operationName.FindStringSubmatch(body.Query); match != nil {
+		operationType = match[1]
+		if match[2] != "" {
+			operation = match[2]
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, FakeShopifyRequest{API: api, Operation: operation, Query: body.Query, Variables: body.Variables})
+
+	if len(f.failures) > 0 {
+		status := f.failures[0]
+		f.failures = f.failures[1:]
+		writeFakeJSON(w, status, map[string]interface{}{"errors": http.StatusText(status)})
+		return
+	}
+
+	if f.throttled > 0 {
+		f.throttled--
+		writeFakeJSON(w, http.StatusOK, map[string]interface{}{
+			"errors": []interface{}{map[string]interface{}{
+				"message":    "Throttled",
+				"extensions": map[string]interface{}{"code": "THROTTLED"},
+			}},
+			"extensions": costExtension(1, 0),
+		})
+		return
+	}
+
+	fields, err := parseRootFields(body.Query, body.Variables)
+	if err != nil {
+		writeFakeJSON(w, http.StatusOK, map[string]interface{}{
+			"errors": []interface{}{map[string]interface{}{"message": "Parse error: " + err.Error()}},
+		})
+		return
+	}
+
+	data := map[string]interface{}{}
+	var errors []interface{}
+	cost := 1
+	for _, field := range fields {
+		value, nodes, err := f.resolve(api, operationType, field)
+		if err != nil {
+			errors = append(errors, map[string]interface{}{
+				"message": err.Error(),
+				"path":    []string{field.Key},
+			})
+			data[field.Key] = nil
+			continue
+		}
+		data[field.Key] = value
+		cost += nodes
+	}
+
+	response := map[string]interface{}{"data": data, "extensions": costExtension(cost, fakeCostBudget-cost)}
+	if len(errors) > 0 {
+		response["errors"] = errors
+	}
+	writeFakeJSON(w, http.StatusOK, response)
+}
+
+// resolve answers one root field, returning its value and how many
+// product nodes it read for the query cost
+func (f *FakeShopify) resolve(api, operationType string, field graphQLField) (interface{}, int, error) {
+	if operationType == "mutation" {
+		if api != "admin" {
+			return nil, 0, fmt.Errorf("Field '%s' doesn't exist on type 'Mutation'", field.Name)
+		}
+		switch field.Name {
+		case "productUpdate":
+			return f.productUpdate(field.Args), 10, nil
+		case "metafieldsSet":
+			return f.metafieldsSet(field.Args), 10, nil
+		}
+		return nil, 0, fmt.Errorf("Field '%s' doesn't exist on type 'Mutation'", field.Name)
+	}
+
+	switch field.Name {
+	case "shop":
+		return map[string]interface{}{"name": FakeShopName}, 0, nil
+	case "product", "productByHandle":
+		id, _ := field.Args["id"].(string)
+		handle, _ := field.Args["handle"].(string)
+		if id == "" && handle == "" {
+			return nil, 0, fmt.Errorf("product requires an id or handle")
+		}
+		product := f.find(api, id, handle)
+		if product == nil {
+			return nil, 1, nil
+		}
+		return product.node(fakeCurrency), 1, nil
+	case "products":
+		return f.productConnection(api, field.Args)
+	}
+	return nil, 0, fmt.Errorf("Field '%s' doesn't exist on type 'QueryRoot'", field.Name)
+}
+
+// find looks a product up by GID or handle, hiding unpublished products
+// from the Storefront API
+func (f *FakeShopify) find(api, id, handle string) *FakeProduct {
+	for _, p := range f.products {
+		if (id != "" && p.ID == id) || (handle != "" && p.Handle == handle) {
+			if api == "storefront" && !p.active() {
+				return nil
+			}
+			return p
+		}
+	}
+	return nil
+}
+
+// productConnection pages through the catalog with opaque cursors
+func (f *FakeShopify) productConnection(api string, args map[string]interface{}) (interface{}, int, error) {
+	first, ok := args["first"].(float64)
+	if !ok || first < 1 || first > 250 {
+		return nil, 0, fmt.Errorf("you must provide `first` between 1 and 250")
+	}
+	search, _ := args["query"].(string)
+
+	var matching []*FakeProduct
+	for _, p := range f.products {
+		if (api == "admin" || p.active()) && p.matches(search) {
+			matching = append(matching, p)
+		}
+	}
+	if reverse, _ := args["reverse"].(bool); reverse {
+		for i, j := 0, len(matching)-1; i < j; i, j = i+1, j-1 {
+			matching[i], matching[j] = matching[j], matching[i]
+		}
+	}
+
+	start := 0
+	if after, _ := args["after"].(string); after != "" {
+		index, err := decodeCursor(after)
+		if err != nil {
+			return nil, 0, err
+		}
+		start = index + 1
+	}
+
+	edges := []interface{}{}
+	endCursor := ""
+	for i := start; i < len(matching) && len(edges) < int(first); i++ {
+		endCursor = encodeCursor(i)
+		edges = append(edges, map[string]interface{}{"cursor": endCursor, "node": matching[i].node(fakeCurrency)})
+	}
+
+	var end interface{}
+	if endCursor != "" {
+		end = endCursor
+	}
+	return map[string]interface{}{
+		"edges": edges,
+		"pageInfo": map[string]interface{}{
+			"hasNextPage":     start+len(edges) < len(matching),
+			"hasPreviousPage": start > 0,
+			"endCursor":       end,
+		},
+	}, len(edges), nil
+}
+
+func encodeCursor(index int) string {
+	return base64.StdEncoding.EncodeToString([]byte("product:" + strconv.Itoa(index)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.StdEncoding.DecodeString(cursor)
+	if err == nil {
+		if index, ok := strings.CutPrefix(string(raw), "product:"); ok {
+			if n, err := strconv.Atoi(index); err == nil {
+				return n, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("Invalid cursor for current pagination.")
+}
+
+// productUpdate applies input.metafields (and title or descriptionHtml) to
+// the product input.id
+func (f *FakeShopify) productUpdate(args map[string]interface{}) interface{} {
+	if userErrors := f.nextRejection(); userErrors != nil {
+		return map[string]interface{}{"product": nil, "userErrors": userErrors}
+	}
+
+	input, _ := args["input"].(map[string]interface{})
+	id, _ := input["id"].(string)
+	product := f.find("admin", id, "")
+	if product == nil {
+		return map[string]interface{}{"product": nil, "userErrors": []FakeUserError{
+			{Field: []string{"id"}, Message: "Product does not exist"},
+		}}
+	}
+
+	entries, _ := input["metafields"].([]interface{})
+	var metafields []FakeMetafield
+	var userErrors []FakeUserError
+	for i, entry := range entries {
+		metafield, userError := metafieldInput(entry, []string{"metafields", strconv.Itoa(i)})
+		if userError != nil {
+			userErrors = append(userErrors, *userError)
+			continue
+		}
+		metafields = append(metafields, metafield)
+	}
+	if len(userErrors) > 0 {
+		return map[string]interface{}{"product": nil, "userErrors": userErrors}
+	}
+
+	for _, metafield := range metafields {
+		f.setMetafield(product, metafield)
+	}
+	if title, ok := input["title"].(string); ok {
+		product.Title = title
+	}
+	if description, ok := input["descriptionHtml"].(string); ok {
+		product.DescriptionHTML = description
+	}
+
+	return map[string]interface{}{"product": product.node(fakeCurrency), "userErrors": []FakeUserError{}}
+}
+
+// metafieldsSet writes metafields on their owners. Like Shopify it is
+// atomic: any invalid entry rejects the whole request.
+func (f *FakeShopify) metafieldsSet(args map[string]interface{}) interface{} {
+	if userErrors := f.nextRejection(); userErrors != nil {
+		return map[string]interface{}{"metafields": nil, "userErrors": userErrors}
+	}
+
+	entries, _ := args["metafields"].([]interface{})
+	type write struct {
+		owner     *FakeProduct
+		metafield FakeMetafield
+	}
+	var writes []write
+	var userErrors []FakeUserError
+	for i, entry := range entries {
+		path := []string{"metafields", strconv.Itoa(i)}
+		metafield, userError := metafieldInput(entry, path)
+		if userError != nil {
+			userErrors = append(userErrors, *userError)
+			continue
+		}
+
+		ownerID, _ := entry.(map[string]interface{})["ownerId"].(string)
+		owner := f.find("admin", ownerID, "")
+		if owner == nil {
+			userErrors = append(userErrors, FakeUserError{Field: append(path, "ownerId"), Message: "Owner subject does not exist", Code: "INVALID"})
+			continue
+		}
+		writes = append(writes, write{owner: owner, metafield: metafield})
+	}
+	if len(userErrors) > 0 {
+		return map[string]interface{}{"metafields": nil, "userErrors": userErrors}
+	}
+
+	metafields := []interface{}{}
+	for _, w := range writes {
+		metafield := f.setMetafield(w.owner, w.metafield)
+		metafields = append(metafields, map[string]interface{}{
+			"id":        metafield.ID,
+			"namespace": metafield.Namespace,
+			"key":       metafield.Key,
+			"value":     metafield.Value,
+			"type":      metafield.Type,
+			"owner":     map[string]interface{}{"id": w.owner.ID},
+		})
+	}
+	return map[string]interface{}{"metafields": metafields, "userErrors": []FakeUserError{}}
+}
+
+// metafieldInput validates a MetafieldInput object
+func metafieldInput(entry interface{}, path []string) (FakeMetafield, *FakeUserError) {
+	object, _ := entry.(map[string]interface{})
+	metafield := FakeMetafield{}
+	metafield.Namespace, _ = object["namespace"].(string)
+	metafield.Key, _ = object["key"].(string)
+	metafield.Value, _ = object["value"].(string)
+	metafield.Type, _ = object["type"].(string)
+
+	switch {
+	case metafield.Key == "":
+		return metafield, &FakeUserError{Field: append(path, "key"), Message: "Key can't be blank", Code: "BLANK"}
+	case metafield.Type == "":
+		return metafield, &FakeUserError{Field: append(path, "type"), Message: "Type can't be blank", Code: "BLANK"}
+	case metafield.Value == "":
+		return metafield, &FakeUserError{Field: append(path, "value"), Message: "Value can't be blank", Code: "BLANK"}
+	}
+	if metafield.Namespace == "" {
+		metafield.Namespace = "custom"
+	}
+	return metafield, nil
+}
+
+// setMetafield creates or overwrites the metafield with the same namespace
+// and key
+func (f *FakeShopify) setMetafield(product *FakeProduct, metafield FakeMetafield) FakeMetafield {
+	for i, existing := range product.Metafields {
+		if existing.Namespace == metafield.Namespace && existing.Key == metafield.Key {
+			metafield.ID = existing.ID
+			product.Metafields[i] = metafield
+			return metafield
+		}
+	}
+
+	f.nextObjectID++
+	metafield.ID = fmt.Sprintf("gid://shopify/Metafield/%d", f.nextObjectID)
+	product.Metafields = append(product.Metafields, metafield)
+	return metafield
+}
+
+func (f *FakeShopify) nextRejection() []FakeUserError {
+	if len(f.rejections) == 0 {
+		return nil
+	}
+	userErrors := f.rejections[0]
+	f.rejections = f.rejections[1:]
+	return userErrors
+}
+
+// costExtension is the "extensions.cost" object Shopify reports
+func costExtension(cost, available int) map[string]interface{} {
+	return map[string]interface{}{
+		"cost": map[string]interface{}{
+			"requestedQueryCost": cost,
+			"actualQueryCost":    cost,
+			"throttleStatus": map[string]interface{}{
+				"maximumAvailable":   fakeCostBudget,
+				"currentlyAvailable": available,
+				"restoreRate":        100,
+			},
+		},
+	}
+}
+
+func writeFakeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
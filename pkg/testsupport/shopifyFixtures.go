@@ -0,0 +1,235 @@
+package testsupport
+
+import (
+	_ "embed"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// defaultProductsJSON holds the catalog every FakeShopify starts with: two
+// active products and one draft that the Storefront API must not expose
+//
+//go:embed testdata/shopify_products.json
+var defaultProductsJSON []byte
+
+// DefaultProducts returns a fresh copy of the default catalog
+func DefaultProducts() []FakeProduct {
+	var products []FakeProduct
+	if err := json.Unmarshal(defaultProductsJSON, &products); err != nil {
+		panic("testsupport: invalid product fixtures: " + err.Error())
+	}
+	return products
+}
+
+// FakeProduct is a product in the fake store. Status is ACTIVE, DRAFT or
+// ARCHIVED; only ACTIVE products are visible through the Storefront API.
+type FakeProduct struct {
+	ID              string          `json:"id"`
+	Handle          string          `json:"handle"`
+	Title           string          `json:"title"`
+	DescriptionHTML string          `json:"descriptionHtml"`
+	Vendor          string          `json:"vendor"`
+	ProductType     string          `json:"productType"`
+	Tags            []string        `json:"tags"`
+	Status          string          `json:"status"`
+	CreatedAt       string          `json:"createdAt"`
+	UpdatedAt       string          `json:"updatedAt"`
+	Options         []FakeOption    `json:"options"`
+	Variants        []FakeVariant   `json:"variants"`
+	Media           []FakeMedia     `json:"media"`
+	Metafields      []FakeMetafield `json:"metafields"`
+}
+
+// FakeOption is a product option such as Size
+type FakeOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// FakeVariant is a purchasable variant. Prices are decimal strings in the
+// store currency.
+type FakeVariant struct {
+	ID               string               `json:"id"`
+	Title            string               `json:"title"`
+	SKU              string               `json:"sku"`
+	Price            string               `json:"price"`
+	CompareAtPrice   string               `json:"compareAtPrice,omitempty"`
+	AvailableForSale bool                 `json:"availableForSale"`
+	SelectedOptions  []FakeSelectedOption `json:"selectedOptions"`
+}
+
+// FakeSelectedOption is the option value a variant represents
+type FakeSelectedOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// FakeMedia is an IMAGE with a URL or a MODEL_3D with sources
+type FakeMedia struct {
+	Type    string            `json:"type"`
+	Alt     string            `json:"alt"`
+	URL     string            `json:"url,omitempty"`
+	Sources []FakeMediaSource `json:"sources,omitempty"`
+}
+
+// FakeMediaSource is one file of a 3D model
+type FakeMediaSource struct {
+	URL      string `json:"url"`
+	Format   string `json:"format"`
+	MimeType string `json:"mimeType"`
+}
+
+// FakeMetafield is a product metafield
+type FakeMetafield struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Type      string `json:"type"`
+}
+
+// FakeUserError is a mutation userErrors entry
+type FakeUserError struct {
+	Field   []string `json:"field"`
+	Message string   `json:"message"`
+	Code    string   `json:"code,omitempty"`
+}
+
+// active reports whether the product is published to the storefront
+func (p *FakeProduct) active() bool {
+	return p.Status == "" || p.Status == "ACTIVE"
+}
+
+// matches reports whether the product satisfies a products(query:) search.
+// Terms are ANDed; "tag:", "product_type:", "vendor:" and "title:" filter a
+// single field and bare words match any of them.
+func (p *FakeProduct) matches(query string) bool {
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		field, value, scoped := strings.Cut(term, ":")
+		if !scoped {
+			field, value = "", term
+		}
+		value = strings.Trim(value, `"'`)
+
+		var candidates []string
+		switch field {
+		case "tag":
+			candidates = p.Tags
+		case "product_type":
+			candidates = []string{p.ProductType}
+		case "vendor":
+			candidates = []string{p.Vendor}
+		case "title":
+			candidates = []string{p.Title}
+		default:
+			candidates = append([]string{p.Title, p.ProductType, p.Vendor}, p.Tags...)
+		}
+
+		found := false
+		for _, candidate := range candidates {
+			if strings.Contains(strings.ToLower(candidate), value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// node renders the product with every field the server's queries select.
+// The same shape is served by both APIs.
+func (p *FakeProduct) node(currency string) map[string]interface{} {
+	money := func(amount string) map[string]interface{} {
+		return map[string]interface{}{"amount": amount, "currencyCode": currency}
+	}
+
+	var minPrice, maxPrice string
+	variants := []interface{}{}
+	for _, v := range p.Variants {
+		if minPrice == "" || parsePrice(v.Price) < parsePrice(minPrice) {
+			minPrice = v.Price
+		}
+		if maxPrice == "" || parsePrice(v.Price) > parsePrice(maxPrice) {
+			maxPrice = v.Price
+		}
+
+		var compareAt interface{}
+		if v.CompareAtPrice != "" {
+			compareAt = money(v.CompareAtPrice)
+		}
+		selected := []interface{}{}
+		for _, so := range v.SelectedOptions {
+			selected = append(selected, map[string]interface{}{"name": so.Name, "value": so.Value})
+		}
+		variants = append(variants, edge(map[string]interface{}{
+			"id":               v.ID,
+			"title":            v.Title,
+			"sku":              v.SKU,
+			"price":            money(v.Price),
+			"compareAtPrice":   compareAt,
+			"availableForSale": v.AvailableForSale,
+			"selectedOptions":  selected,
+		}))
+	}
+
+	media := []interface{}{}
+	for _, m := range p.Media {
+		node := map[string]interface{}{"mediaContentType": m.Type, "alt": m.Alt}
+		if m.URL != "" {
+			node["image"] = map[string]interface{}{"url": m.URL, "altText": m.Alt}
+		}
+		if len(m.Sources) > 0 {
+			node["sources"] = m.Sources
+		}
+		media = append(media, edge(node))
+	}
+
+	options := []interface{}{}
+	for _, o := range p.Options {
+		options = append(options, map[string]interface{}{"name": o.Name, "values": o.Values})
+	}
+
+	metafields := []interface{}{}
+	for _, m := range p.Metafields {
+		metafields = append(metafields, edge(m))
+	}
+
+	tags := p.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return map[string]interface{}{
+		"id":              p.ID,
+		"handle":          p.Handle,
+		"title":           p.Title,
+		"descriptionHtml": p.DescriptionHTML,
+		"vendor":          p.Vendor,
+		"productType":     p.ProductType,
+		"tags":            tags,
+		"status":          p.Status,
+		"createdAt":       p.CreatedAt,
+		"updatedAt":       p.UpdatedAt,
+		"priceRange": map[string]interface{}{
+			"minVariantPrice": money(minPrice),
+			"maxVariantPrice": money(maxPrice),
+		},
+		"options":    options,
+		"media":      map[string]interface{}{"edges": media},
+		"variants":   map[string]interface{}{"edges": variants},
+		"metafields": map[string]interface{}{"edges": metafields},
+	}
+}
+
+func edge(node interface{}) map[string]interface{} {
+	return map[string]interface{}{"node": node}
+}
+
+func parsePrice(amount string) float64 {
+	price, _ := strconv.ParseFloat(amount, 64)
+	return price
+}
@@ -0,0 +1,86 @@
+package testsupport
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// post sends a GraphQL request to the fake's Admin endpoint
+func post(t *testing.T, f *FakeShopify, token, query string, variables map[string]interface{}) (int, map[string]interface{}) {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	req, _ := http.NewRequest("POST", f.server.URL+"/admin/api/"+fakeAPIVersion+"/graphql.json", bytes.NewReader(body))
+	req.Header.Set("X-Shopify-Access-Token", token)
+	resp, err := f.Client().Do(req)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var out map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	return resp.StatusCode, out
+}
+
+func TestFakeShopifyRejectsInvalidToken(t *testing.T) {
+	f := NewFakeShopify(t)
+
+	status, _ := post(t, f, "wrong", `{ shop { name } }`, nil)
+	if status != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", status)
+	}
+}
+
+func TestFakeShopifyMetafieldsSet(t *testing.T) {
+	f := NewFakeShopify(t)
+	mutation := `mutation SetMetafields($metafields: [MetafieldsSetInput!]!) {
+		metafieldsSet(metafields: $metafields) { metafields { id } userErrors { field message code } }
+	}`
+
+	_, resp := post(t, f, FakeAdminToken, mutation, map[string]interface{}{"metafields": []interface{}{
+		map[string]interface{}{"ownerId": "gid://shopify/Product/1002", "namespace": "custom", "key": "fit", "value": "relaxed", "type": "single_line_text_field"},
+		map[string]interface{}{"ownerId": "gid://shopify/Product/1002", "namespace": "custom", "key": "care", "value": "", "type": "single_line_text_field"},
+	}})
+	result := resp["data"].(map[string]interface{})["metafieldsSet"].(map[string]interface{})
+	if errs := result["userErrors"].([]interface{}); len(errs) != 1 {
+		t.Fatalf("userErrors = %+v, want the blank value rejected", errs)
+	}
+	if product, _ := f.Product("linen-shirt"); len(product.Metafields) != 0 {
+		t.Errorf("metafields = %+v, want nothing written by a rejected request", product.Metafields)
+	}
+
+	_, resp = post(t, f, FakeAdminToken, mutation, map[string]interface{}{"metafields": []interface{}{
+		map[string]interface{}{"ownerId": "gid://shopify/Product/1002", "namespace": "custom", "key": "fit", "value": "relaxed", "type": "single_line_text_field"},
+	}})
+	result = resp["data"].(map[string]interface{})["metafieldsSet"].(map[string]interface{})
+	if errs := result["userErrors"].([]interface{}); len(errs) != 0 {
+		t.Fatalf("userErrors = %+v", errs)
+	}
+	if product, _ := f.Product("linen-shirt"); len(product.Metafields) != 1 || product.Metafields[0].Value != "relaxed" {
+		t.Errorf("metafields = %+v, want custom.fit set", product.Metafields)
+	}
+}
+
+func TestFakeShopifyProductsRejectsInvalidCursor(t *testing.T) {
+	f := NewFakeShopify(t)
+
+	_, resp := post(t, f, FakeAdminToken, `query { products(first: 1, after: "bogus") { edges { cursor } } }`, nil)
+	if _, ok := resp["errors"]; !ok {
+		t.Errorf("response = %+v, want an invalid cursor error", resp)
+	}
+}
+
+func TestFakeShopifyAdminListsDrafts(t *testing.T) {
+	f := NewFakeShopify(t)
+
+	_, resp := post(t, f, FakeAdminToken, `{ products(first: 10, query: "tag:winter") { edges { node { handle } } } }`, nil)
+	edges := resp["data"].(map[string]interface{})["products"].(map[string]interface{})["edges"].([]interface{})
+	if len(edges) != 1 {
+		t.Errorf("edges = %+v, want the draft coat", edges)
+	}
+}
@@ -0,0 +1,86 @@
+// Package tracing configures OpenTelemetry tracing. Spans are exported to
+// stdout or an OTLP collector depending on OTEL_TRACES_EXPORTER, and W3C
+// trace context is accepted from the widget and forwarded upstream.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName is reported on every span unless OTEL_SERVICE_NAME overrides it
+const ServiceName = "strategy-fox-go-bd"
+
+const instrumentationName = "strategy-fox-go-bd"
+
+// Tracer returns the tracer used for the server's own spans
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Setup installs the global tracer provider and W3C propagators. The
+// exporter is one of:
+//   - "stdout": pretty-printed spans on stderr, for local runs
+//   - "otlp": OTLP over HTTP, configured by the standard
+//     OTEL_EXPORTER_OTLP_* variables (default localhost:4318)
+//   - "none" or unset: spans are not recorded, context still propagates
+//
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, exporterName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := strings.ToLower(exporterName); name {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		// stderr keeps the JSON log stream on stdout parseable
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint(), stdouttrace.WithWriter(os.Stderr))
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %v", err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// InjectHeaders writes the trace context of ctx into outgoing request headers
+func InjectHeaders(ctx context.Context, header propagation.HeaderCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, header)
+}
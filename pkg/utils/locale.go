@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"strategy-fox-go-bd/pkg/models"
+)
+
+var (
+	countryCodePattern  = regexp.MustCompile(`^[A-Z]{2}$`)
+	languageCodePattern = regexp.MustCompile(`^[A-Z]{2,3}(_[A-Z]{2,4})?$`)
+)
+
+// ParseLocale resolves the shopper locale from the country and language query
+// parameters, falling back to the first Accept-Language entry. Invalid codes
+// are ignored so the shop defaults apply.
+func ParseLocale(r *http.Request) models.Locale {
+	return NormalizeLocale(models.Locale{
+		Country:  r.URL.Query().Get("country"),
+		Language: r.URL.Query().Get("language"),
+	}, r.Header.Get("Accept-Language"))
+}
+
+// NormalizeLocale upper-cases and validates a locale, filling missing parts
+// from an Accept-Language header value
+func NormalizeLocale(locale models.Locale, acceptLanguage string) models.Locale {
+	country := strings.ToUpper(strings.TrimSpace(locale.Country))
+	language := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(locale.Language), "-", "_"))
+
+	if tag := firstLanguageTag(acceptLanguage); tag != "" {
+		parts := strings.SplitN(tag, "-", 2)
+		if language == "" {
+			language = strings.ToUpper(parts[0])
+		}
+		if country == "" && len(parts) == 2 {
+			country = strings.ToUpper(parts[1])
+		}
+	}
+
+	if !countryCodePattern.MatchString(country) {
+		country = ""
+	}
+	if !languageCodePattern.MatchString(language) {
+		language = ""
+	}
+
+	return models.Locale{Country: country, Language: language}
+}
+
+// firstLanguageTag returns the first tag of an Accept-Language header,
+// e.g. "fr-CA" for "fr-CA,fr;q=0.9,en;q=0.8"
+func firstLanguageTag(header string) string {
+	if header == "" {
+		return ""
+	}
+	tag := strings.TrimSpace(strings.SplitN(header, ",", 2)[0])
+	tag = strings.TrimSpace(strings.SplitN(tag, ";", 2)[0])
+	if tag == "*" {
+		return ""
+	}
+	return tag
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrorBody describes a single API error returned to clients
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ErrorResponse is the envelope every versioned endpoint uses for failures
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// WriteJSON encodes payload as JSON with the given status code
+func WriteJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// WriteError writes an ErrorResponse envelope with the given status code
+func WriteError(w http.ResponseWriter, status int, code, message string) {
+	WriteJSON(w, status, ErrorResponse{Error: ErrorBody{Code: code, Message: message}})
+}